@@ -0,0 +1,147 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Version of the schema described in SCHEMA.md. It changes whenever a field
+// is removed or changes meaning, new fields and kinds keep the version.
+const Version = 1
+
+type Kind string
+
+const (
+	// top level
+	KindFunction  Kind = "function"
+	KindInterface Kind = "interface"
+	KindHost      Kind = "host"
+	KindParameter Kind = "parameter"
+	KindMethod    Kind = "method"
+
+	// statements
+	KindAssignment Kind = "assignment"
+	// an assignment to an element of a collection
+	KindElement    Kind = "element"
+	KindExpression Kind = "expression"
+	KindReturn     Kind = "return"
+	KindIf         Kind = "if"
+	KindArm        Kind = "arm"
+	KindForIn      Kind = "for"
+
+	// expressions, an `if` and a `function` are expressions too
+	KindLiteral    Kind = "literal"
+	KindIdentifier Kind = "identifier"
+	KindBinary     Kind = "binary"
+	KindCall       Kind = "call"
+	KindIndex      Kind = "index"
+	KindBuiltin    Kind = "builtin"
+	KindCollection Kind = "collection"
+	// a call of a method, `receiver.name(arguments)`
+	KindInvoke Kind = "invoke"
+	// a value turned into a value of an interface type
+	KindConversion Kind = "conversion"
+)
+
+// Document is the root of a dumped program
+type Document struct {
+	Version int `json:"version"`
+	// name of the function the program starts at, empty when there is none
+	Entry      string  `json:"entry,omitempty"`
+	Statements []*Node `json:"statements"`
+}
+
+// Span is where a node is in the source, from its first character to right
+// after its last. Lines and columns start at 1, columns count characters.
+type Span struct {
+	Line      uint64 `json:"line"`
+	Column    uint64 `json:"column"`
+	EndLine   uint64 `json:"endLine"`
+	EndColumn uint64 `json:"endColumn"`
+}
+
+// Node is any node of the tree. Which fields are set depends on Kind, see SCHEMA.md.
+type Node struct {
+	Kind Kind `json:"kind"`
+	Span Span `json:"span"`
+	// the resolved type, of the value for expressions and of the function for functions
+	Type string `json:"type,omitempty"`
+
+	Name     string `json:"name,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Operator string `json:"operator,omitempty"`
+	Literal  string `json:"literal,omitempty"`
+	// the `///` comments above a declaration, a line each without the slashes
+	Doc   string `json:"doc,omitempty"`
+	Entry bool   `json:"entry,omitempty"`
+	Else  bool   `json:"else,omitempty"`
+
+	Parameters []*Node  `json:"parameters,omitempty"`
+	Captures   []string `json:"captures,omitempty"`
+	Methods    []*Node  `json:"methods,omitempty"`
+
+	// the receiver of a method or of the method an invoke calls
+	Receiver   *Node `json:"receiver,omitempty"`
+	Value      *Node `json:"value,omitempty"`
+	Left       *Node `json:"left,omitempty"`
+	Right      *Node `json:"right,omitempty"`
+	Condition  *Node `json:"condition,omitempty"`
+	Callee     *Node `json:"callee,omitempty"`
+	Collection *Node `json:"collection,omitempty"`
+	Index      *Node `json:"index,omitempty"`
+	// the expression of an arm written as `value: expression`
+	Expression *Node `json:"expression,omitempty"`
+
+	Arguments []*Node `json:"arguments,omitempty"`
+	Keys      []*Node `json:"keys,omitempty"`
+	Elements  []*Node `json:"elements,omitempty"`
+	Arms      []*Node `json:"arms,omitempty"`
+	Body      []*Node `json:"body,omitempty"`
+}
+
+// Encode writes the document as indented JSON
+func Encode(w io.Writer, document *Document) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(document)
+}
+
+// Decode reads a document written by Encode. Fields and kinds it does not
+// know are from a newer writer of the same version and are ignored.
+func Decode(r io.Reader) (*Document, error) {
+	document := &Document{}
+	err := json.NewDecoder(r).Decode(document)
+	if err != nil {
+		return nil, err
+	}
+	if document.Version != Version {
+		return nil, fmt.Errorf("unsupported schema version %d, expected %d", document.Version, Version)
+	}
+	return document, nil
+}
+
+// Walk calls visit for node and then for every node below it
+func Walk(node *Node, visit func(node *Node) error) error {
+	if node == nil {
+		return nil
+	}
+	err := visit(node)
+	if err != nil {
+		return err
+	}
+	children := [][]*Node{
+		node.Parameters, node.Methods,
+		{node.Receiver, node.Value, node.Left, node.Right, node.Condition, node.Callee, node.Collection, node.Index, node.Expression},
+		node.Arguments, node.Keys, node.Elements, node.Arms, node.Body,
+	}
+	for _, group := range children {
+		for _, child := range group {
+			err = Walk(child, visit)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
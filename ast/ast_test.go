@@ -0,0 +1,174 @@
+package ast_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"shake/ast"
+	"shake/codegen/testdata/fixture"
+	"shake/lexer"
+	"shake/parser"
+	"strings"
+	"testing"
+)
+
+// document parses source with its trivia, so declarations keep their doc comments
+func document(t *testing.T, source string) *ast.Document {
+	t.Helper()
+	program, err := parser.NewParser(lexer.NewTriviaLexer(strings.NewReader(source)).All()).ParseProgram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	document, err := ast.FromProgram(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+// features uses every kind of node
+const features = `/// anything with an area
+interface Shape {
+    Area(scale: int32): int32;
+}
+
+fn (side: int32) Area(scale: int32): int32 {
+    return side * side * scale;
+}
+
+fn twice(x: int32): int32: x + x;
+
+/// starts here
+(entry)
+fn start(): int32 {
+    shape: Shape = 2;
+    xs = []int32{1, 2};
+    xs[0] = twice(3);
+    ages = map[int32]int32{1: 30};
+    total = len(xs) + ages[1];
+    for i, x in xs {
+        total = total + i * x;
+    }
+    add = fn(y: int32): int32 {
+        total = total + y;
+        return total;
+    };
+    add(1);
+    if total > 100 {
+        total = 100;
+    }
+    return if total == {
+        1 {
+            return shape.Area(1);
+        }
+        else: total
+    };
+}
+`
+
+// TestRoundTrip checks that decoding an encoded document gives it back
+func TestRoundTrip(t *testing.T) {
+	sources := map[string]string{"features": features}
+	for _, path := range fixture.Corpus(t) {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(path)] = string(source)
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			want := document(t, source)
+			encoded := &bytes.Buffer{}
+			err := ast.Encode(encoded, want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ast.Decode(bytes.NewReader(encoded.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				again := &bytes.Buffer{}
+				ast.Encode(again, got)
+				t.Errorf("decoding changed the document\ngot:\n%s\nwant:\n%s", again, encoded)
+			}
+		})
+	}
+
+	kinds := map[ast.Kind]bool{}
+	for _, node := range document(t, features).Statements {
+		ast.Walk(node, func(node *ast.Node) error {
+			kinds[node.Kind] = true
+			return nil
+		})
+	}
+	for _, kind := range []ast.Kind{
+		ast.KindFunction, ast.KindInterface, ast.KindParameter, ast.KindMethod,
+		ast.KindAssignment, ast.KindElement, ast.KindExpression, ast.KindReturn, ast.KindIf, ast.KindArm, ast.KindForIn,
+		ast.KindLiteral, ast.KindIdentifier, ast.KindBinary, ast.KindCall, ast.KindIndex, ast.KindBuiltin, ast.KindCollection, ast.KindInvoke, ast.KindConversion,
+	} {
+		if !kinds[kind] {
+			t.Errorf("the features program has no %s node", kind)
+		}
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	_, err := ast.Decode(strings.NewReader(`{"version": 2, "statements": [], "future": true}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported schema version 2") {
+		t.Errorf("got %v, want an unsupported version", err)
+	}
+	// fields a newer writer of the same version adds are ignored
+	document, err := ast.Decode(strings.NewReader(`{"version": 1, "statements": [{"kind": "future", "span": {"line": 1}, "colour": "red"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document.Statements[0].Kind != "future" {
+		t.Errorf("got %+v, want the node of an unknown kind", document.Statements[0])
+	}
+}
+
+// TestSpans checks nodes span from their first character to right after the
+// last, in characters
+func TestSpans(t *testing.T) {
+	document := document(t, "fn f(größe: int32): int32 {\n    return größe +\n        1;\n}\n")
+	function := document.Statements[0]
+	ret := function.Body[0]
+	spans := []struct {
+		name string
+		node *ast.Node
+		want ast.Span
+	}{
+		{"function", function, ast.Span{Line: 1, Column: 1, EndLine: 4, EndColumn: 2}},
+		{"parameter", function.Parameters[0], ast.Span{Line: 1, Column: 6, EndLine: 1, EndColumn: 11}},
+		{"return", ret, ast.Span{Line: 2, Column: 5, EndLine: 3, EndColumn: 11}},
+		{"binary", ret.Value, ast.Span{Line: 2, Column: 12, EndLine: 3, EndColumn: 10}},
+		{"identifier", ret.Value.Left, ast.Span{Line: 2, Column: 12, EndLine: 2, EndColumn: 17}},
+		{"literal", ret.Value.Right, ast.Span{Line: 3, Column: 9, EndLine: 3, EndColumn: 10}},
+	}
+	for _, span := range spans {
+		if span.node.Span != span.want {
+			t.Errorf("%s: got %+v, want %+v", span.name, span.node.Span, span.want)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	got := &bytes.Buffer{}
+	err := ast.Print(got, document(t, "fn main(): int32 {\n    return 1 + 2;\n}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `entry main
+function main fn(): int32 1:1-3:2
+  return int32 2:5-2:18
+    value: binary + int32 2:12-2:17
+      left: literal 1 int32 2:12-2:13
+      right: literal 2 int32 2:16-2:17
+`
+	if got.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,453 @@
+package ast
+
+import (
+	"fmt"
+	"shake/parser"
+	"shake/trace"
+	"shake/types"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[AST Error]"), reason, line)
+}
+
+type builder struct {
+	// line of the statement being converted, for errors
+	line uint64
+	// where the parameters and receivers are declared
+	declarations map[*parser.NodeTermIdentifier]parser.Position
+}
+
+// FromProgram converts a parsed program to a document
+func FromProgram(program *parser.NodeProgram) (*Document, error) {
+	b := &builder{declarations: map[*parser.NodeTermIdentifier]parser.Position{}}
+	for _, reference := range program.References() {
+		if reference.Declaration {
+			b.declarations[reference.Identifier] = reference.Position
+		}
+	}
+	document := &Document{Version: Version, Statements: []*Node{}}
+	for _, host := range program.Hosts() {
+		document.Statements = append(document.Statements, &Node{
+			Kind: KindHost,
+			Name: host.Identifier,
+			Type: typeName(host.Type),
+		})
+	}
+	for _, statement := range program.Statements() {
+		var node *Node
+		var err error
+		switch statement := statement.(type) {
+		case *parser.NodeFunction:
+			node, err = b.function(statement)
+			if statement.IsEntry() || (document.Entry == "" && statement.Name() == "main" && statement.Receiver() == nil) {
+				document.Entry = statement.Name()
+			}
+		case *parser.NodeInterface:
+			node = b.declaredInterface(statement)
+		default:
+			err = Error(fmt.Sprintf("Cannot convert top level statement: %T", statement), b.line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		document.Statements = append(document.Statements, node)
+	}
+	if entry := program.Entry(); entry != nil {
+		document.Entry = entry.Name()
+	}
+	return document, nil
+}
+
+func span(s parser.Span) Span {
+	return Span{Line: s.Start.Line, Column: s.Start.Column, EndLine: s.End.Line, EndColumn: s.End.Column}
+}
+
+// declared returns the span of the name of a parameter or a receiver
+func (b *builder) declared(identifier *parser.NodeTermIdentifier) Span {
+	start, ok := b.declarations[identifier]
+	if !ok {
+		return Span{}
+	}
+	return Span{Line: start.Line, Column: start.Column, EndLine: start.Line, EndColumn: start.Column + uint64(utf8.RuneCountInString(identifier.Identifier))}
+}
+
+func typeName(t types.Type) string {
+	if t == types.TypeUnknown {
+		return ""
+	}
+	return t.String()
+}
+
+func (b *builder) function(function *parser.NodeFunction) (*Node, error) {
+	b.line = function.Line()
+	node := &Node{
+		Kind:       KindFunction,
+		Span:       span(function.GetSpan()),
+		Type:       typeName(function.GetType()),
+		Name:       function.Name(),
+		Doc:        function.Doc(),
+		Entry:      function.IsEntry(),
+		Parameters: b.parameters(function.Parameters()),
+	}
+	if receiver := function.Receiver(); receiver != nil {
+		node.Receiver = &Node{
+			Kind: KindParameter,
+			Span: b.declared(receiver),
+			Name: receiver.Identifier,
+			Type: typeName(receiver.Type),
+		}
+	}
+	for _, captured := range function.Captures() {
+		node.Captures = append(node.Captures, captured.Identifier)
+	}
+	body, err := b.scope(function.Scope())
+	if err != nil {
+		return nil, err
+	}
+	node.Body = body
+	return node, nil
+}
+
+func (b *builder) parameters(parameters []*parser.NodeTermIdentifier) []*Node {
+	var nodes []*Node
+	for _, parameter := range parameters {
+		nodes = append(nodes, &Node{
+			Kind: KindParameter,
+			Span: b.declared(parameter),
+			Name: parameter.Identifier,
+			Type: typeName(parameter.Type),
+		})
+	}
+	return nodes
+}
+
+func (b *builder) declaredInterface(declared *parser.NodeInterface) *Node {
+	node := &Node{
+		Kind: KindInterface,
+		Span: span(declared.Span()),
+		Name: declared.Name(),
+		Doc:  declared.Doc(),
+	}
+	for _, method := range declared.Methods() {
+		node.Methods = append(node.Methods, &Node{
+			Kind:       KindMethod,
+			Span:       span(method.Span),
+			Name:       method.Name,
+			Type:       typeName(method.ReturnType),
+			Parameters: b.parameters(method.Parameters),
+		})
+	}
+	return node
+}
+
+func (b *builder) scope(scope *parser.NodeScope) ([]*Node, error) {
+	var nodes []*Node
+	for _, statement := range scope.Statements() {
+		node, err := b.statement(statement)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (b *builder) statement(statement parser.NodeScopedStatement) (*Node, error) {
+	switch statement := statement.(type) {
+	case *parser.NodeAssignment:
+		b.line = statement.LineNumber
+		value, err := b.expression(*statement.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:  KindAssignment,
+			Span:  span(statement.Span),
+			Type:  typeName(statement.Variable.Type),
+			Name:  statement.Identifier,
+			Value: value,
+		}, nil
+	case *parser.NodeIndexAssignment:
+		b.line = statement.LineNumber
+		collection, err := b.expression(statement.Target.Collection)
+		if err != nil {
+			return nil, err
+		}
+		index, err := b.expression(statement.Target.Index)
+		if err != nil {
+			return nil, err
+		}
+		value, err := b.expression(statement.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:       KindElement,
+			Span:       span(statement.Span),
+			Type:       typeName(statement.Target.Type),
+			Collection: collection,
+			Index:      index,
+			Value:      value,
+		}, nil
+	case *parser.NodeExpressionStatement:
+		b.line = statement.LineNumber
+		value, err := b.expression(statement.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:  KindExpression,
+			Span:  span(statement.Span),
+			Type:  value.Type,
+			Value: value,
+		}, nil
+	case *parser.NodeReturn:
+		b.line = statement.LineNumber
+		value, err := b.expression(statement.Value())
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:  KindReturn,
+			Span:  span(statement.Span),
+			Type:  value.Type,
+			Value: value,
+		}, nil
+	case *parser.NodeConditional:
+		b.line = statement.LineNumber
+		return b.conditional(statement)
+	case *parser.NodeForIn:
+		b.line = statement.LineNumber
+		collection, err := b.expression(statement.Collection)
+		if err != nil {
+			return nil, err
+		}
+		body, err := b.scope(statement.Scope())
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:       KindForIn,
+			Span:       span(statement.Span),
+			Key:        statement.Key,
+			Name:       statement.Value,
+			Collection: collection,
+			Body:       body,
+		}, nil
+	}
+	return nil, Error(fmt.Sprintf("Cannot convert statement: %T", statement), b.line)
+}
+
+func (b *builder) conditional(conditional *parser.NodeConditional) (*Node, error) {
+	condition, err := b.expression(conditional.Condition)
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{
+		Kind:      KindIf,
+		Span:      span(conditional.Span),
+		Type:      typeName(conditional.Type),
+		Condition: condition,
+	}
+	if conditional.Scope() != nil {
+		node.Body, err = b.scope(conditional.Scope())
+		if err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	for _, arm := range conditional.Arms {
+		b.line = arm.LineNumber
+		armNode := &Node{
+			Kind: KindArm,
+			Span: span(arm.Span),
+			Else: arm.Value == nil,
+		}
+		if arm.Value != nil {
+			armNode.Value, err = b.expression(arm.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if arm.Scope() != nil {
+			armNode.Body, err = b.scope(arm.Scope())
+		} else {
+			armNode.Expression, err = b.expression(arm.Result)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.Arms = append(node.Arms, armNode)
+	}
+	return node, nil
+}
+
+func (b *builder) expressions(expressions []parser.NodeExpression) ([]*Node, error) {
+	var nodes []*Node
+	for _, expression := range expressions {
+		node, err := b.expression(expression)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (b *builder) expression(expression parser.NodeExpression) (*Node, error) {
+	switch expression := expression.(type) {
+	case parser.NodeExpressionLiteral:
+		node := &Node{
+			Kind: KindLiteral,
+			Span: span(expression.GetSpan()),
+			Type: typeName(expression.Type),
+		}
+		switch term := expression.Value.(type) {
+		case parser.NodeTermInt32:
+			node.Literal = term.Value
+		case parser.NodeTermBool:
+			node.Literal = fmt.Sprint(term.Value)
+		case parser.NodeTermEmpty:
+			node.Literal = "empty"
+		default:
+			return nil, Error(fmt.Sprintf("Cannot convert literal: %T", expression.Value), b.line)
+		}
+		return node, nil
+	case *parser.NodeExpressionIdentifier:
+		identifier, ok := expression.Identifier.(*parser.NodeTermIdentifier)
+		if !ok {
+			return nil, Error(fmt.Sprintf("Cannot convert identifier: %T", expression.Identifier), b.line)
+		}
+		return &Node{
+			Kind: KindIdentifier,
+			Span: span(expression.GetSpan()),
+			Type: typeName(expression.Type),
+			Name: identifier.Identifier,
+		}, nil
+	case *parser.NodeExpressionBinary:
+		left, err := b.expression(expression.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := b.expression(expression.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:     KindBinary,
+			Span:     span(expression.GetSpan()),
+			Type:     typeName(expression.Type),
+			Operator: expression.Operation,
+			Left:     left,
+			Right:    right,
+		}, nil
+	case *parser.NodeExpressionCall:
+		callee, err := b.expression(expression.Callee)
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := b.expressions(expression.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:      KindCall,
+			Span:      span(expression.GetSpan()),
+			Type:      typeName(expression.Type),
+			Callee:    callee,
+			Arguments: arguments,
+		}, nil
+	case *parser.NodeExpressionMethodCall:
+		receiver, err := b.expression(expression.Receiver)
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := b.expressions(expression.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:      KindInvoke,
+			Span:      span(expression.GetSpan()),
+			Type:      typeName(expression.Type),
+			Name:      expression.Method,
+			Receiver:  receiver,
+			Arguments: arguments,
+		}, nil
+	case *parser.NodeExpressionConversion:
+		value, err := b.expression(expression.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:  KindConversion,
+			Span:  span(expression.GetSpan()),
+			Type:  typeName(expression.Type),
+			Value: value,
+		}, nil
+	case *parser.NodeExpressionIndex:
+		collection, err := b.expression(expression.Collection)
+		if err != nil {
+			return nil, err
+		}
+		index, err := b.expression(expression.Index)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:       KindIndex,
+			Span:       span(expression.GetSpan()),
+			Type:       typeName(expression.Type),
+			Collection: collection,
+			Index:      index,
+		}, nil
+	case *parser.NodeExpressionBuiltin:
+		arguments, err := b.expressions(expression.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{
+			Kind:      KindBuiltin,
+			Span:      span(expression.GetSpan()),
+			Type:      typeName(expression.Type),
+			Name:      expression.Name,
+			Arguments: arguments,
+		}, nil
+	case *parser.NodeExpressionCollection:
+		node := &Node{
+			Kind: KindCollection,
+			Span: span(expression.GetSpan()),
+			Type: typeName(expression.Type),
+		}
+		var err error
+		if expression.Keys != nil {
+			node.Keys, err = b.expressions(expression.Keys)
+			if err != nil {
+				return nil, err
+			}
+		}
+		node.Elements, err = b.expressions(expression.Elements)
+		if err != nil {
+			return nil, err
+		}
+		return node, nil
+	case *parser.NodeConditional:
+		line := b.line
+		node, err := b.conditional(expression)
+		b.line = line
+		return node, err
+	case *parser.NodeFunction:
+		line := b.line
+		node, err := b.function(expression)
+		b.line = line
+		return node, err
+	}
+	return nil, Error(fmt.Sprintf("Cannot convert expression: %T", expression), b.line)
+}
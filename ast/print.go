@@ -0,0 +1,95 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Print writes the document as an indented tree, a node per line with its
+// kind, name, type and span. Nodes whose kind does not tell what they are to
+// their parent start with their field.
+func Print(w io.Writer, document *Document) error {
+	p := &printer{w: w}
+	if document.Entry != "" {
+		p.printf("entry %s\n", document.Entry)
+	}
+	for _, node := range document.Statements {
+		p.node("", node, 0)
+	}
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func (p *printer) node(field string, node *Node, depth int) {
+	if node == nil {
+		return
+	}
+	line := strings.Repeat("  ", depth)
+	if field != "" {
+		line += field + ": "
+	}
+	line += string(node.Kind)
+	for _, value := range []string{node.Key, node.Name, node.Operator, node.Literal} {
+		if value != "" {
+			line += " " + value
+		}
+	}
+	if node.Entry {
+		line += " (entry)"
+	}
+	if node.Else {
+		line += " else"
+	}
+	if node.Type != "" {
+		line += " " + node.Type
+	}
+	if len(node.Captures) > 0 {
+		line += " captures " + strings.Join(node.Captures, ", ")
+	}
+	if span := node.Span; span.Line != 0 {
+		line += fmt.Sprintf(" %d:%d-%d:%d", span.Line, span.Column, span.EndLine, span.EndColumn)
+	}
+	p.printf("%s\n", line)
+
+	depth++
+	p.node("receiver", node.Receiver, depth)
+	p.list("", node.Parameters, depth)
+	p.list("", node.Methods, depth)
+	p.node("condition", node.Condition, depth)
+	p.node("callee", node.Callee, depth)
+	p.node("collection", node.Collection, depth)
+	p.node("index", node.Index, depth)
+	p.node("left", node.Left, depth)
+	p.node("right", node.Right, depth)
+	p.node("value", node.Value, depth)
+	p.node("expression", node.Expression, depth)
+	p.list("argument", node.Arguments, depth)
+	if node.Keys != nil {
+		for i, element := range node.Elements {
+			p.node("key", node.Keys[i], depth)
+			p.node("element", element, depth)
+		}
+	} else {
+		p.list("element", node.Elements, depth)
+	}
+	p.list("", node.Arms, depth)
+	p.list("", node.Body, depth)
+}
+
+func (p *printer) list(field string, nodes []*Node, depth int) {
+	for _, node := range nodes {
+		p.node(field, node, depth)
+	}
+}
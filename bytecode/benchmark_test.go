@@ -0,0 +1,425 @@
+package bytecode_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"shake/codegen/testdata/fixture"
+	"shake/parser"
+	"shake/types"
+	"shake/vm"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+const fibSource = `
+fn fib(n: int32): int32 {
+    if n < 2 {
+        return n;
+    }
+    return fib(n - 1) + fib(n - 2);
+}
+
+fn main(): int32 {
+    return fib(20);
+}
+`
+
+const loopSource = `
+fn main(): int32 {
+    xs = [200]int32{};
+    for i, x in xs {
+        xs[i] = i;
+    }
+    sum = 0;
+    for i, x in xs {
+        for j, y in xs {
+            sum = sum + x * y - i + j;
+        }
+    }
+    return sum;
+}
+`
+
+func BenchmarkFib(b *testing.B) {
+	benchmark(b, fibSource)
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchmark(b, loopSource)
+}
+
+// benchmark runs the program on the VM and by walking its tree
+func benchmark(b *testing.B, source string) {
+	program := fixture.ParseString(b, source)
+	b.Run("vm", func(b *testing.B) {
+		compiled := compile(b, source)
+		machine := vm.New(compiled)
+		for i := 0; i < b.N; i++ {
+			_, err := machine.Run()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ast", func(b *testing.B) {
+		w := newWalker(program)
+		for i := 0; i < b.N; i++ {
+			_, err := w.run()
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestWalker checks that the VM and the tree walker the benchmarks measure
+// it against agree, so both do the same work
+func TestWalker(t *testing.T) {
+	sources := map[string]string{"fib": fibSource, "loop": loopSource}
+	paths, err := filepath.Glob("testdata/*.shk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources[filepath.Base(path)] = string(source)
+	}
+
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			want, err := vm.New(compile(t, source)).Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := newWalker(fixture.ParseString(t, source)).run()
+			if err != nil {
+				t.Skip(err)
+			}
+			if got != want {
+				t.Errorf("walker returned %d, VM returned %d", got, want)
+			}
+		})
+	}
+}
+
+// walker interprets the checked tree directly. Values are int64, bool,
+// []any, map[int64]any and *parser.NodeFunction for top level functions.
+type walker struct {
+	program   *parser.NodeProgram
+	functions map[string]*parser.NodeFunction
+}
+
+type locals map[*parser.NodeTermIdentifier]any
+
+func newWalker(program *parser.NodeProgram) *walker {
+	w := &walker{program: program, functions: map[string]*parser.NodeFunction{}}
+	for _, statement := range program.Statements() {
+		if function, ok := statement.(*parser.NodeFunction); ok && function.Receiver() == nil {
+			w.functions[function.Name()] = function
+		}
+	}
+	return w
+}
+
+func (w *walker) run() (int64, error) {
+	entry := w.program.Entry()
+	if entry == nil {
+		entry = w.functions["main"]
+	}
+	result, err := w.call(entry, nil)
+	if err != nil {
+		return 0, err
+	}
+	return result.(int64), nil
+}
+
+func (w *walker) call(function *parser.NodeFunction, arguments []any) (any, error) {
+	if len(function.Captures()) > 0 || function.Receiver() != nil {
+		return nil, fmt.Errorf("the walker does not support closures or methods")
+	}
+	frame := locals{}
+	for i, parameter := range function.Parameters() {
+		frame[parameter] = arguments[i]
+	}
+	result, _, err := w.scope(function.Scope(), frame)
+	return result, err
+}
+
+// scope runs the statements of scope and reports whether one of them returned
+func (w *walker) scope(scope *parser.NodeScope, frame locals) (any, bool, error) {
+	for _, statement := range scope.Statements() {
+		switch statement := statement.(type) {
+		case *parser.NodeAssignment:
+			value, err := w.expression(*statement.Expression, frame)
+			if err != nil {
+				return nil, false, err
+			}
+			frame[statement.Variable] = value
+		case *parser.NodeIndexAssignment:
+			collection, err := w.expression(statement.Target.Collection, frame)
+			if err != nil {
+				return nil, false, err
+			}
+			index, err := w.expression(statement.Target.Index, frame)
+			if err != nil {
+				return nil, false, err
+			}
+			value, err := w.expression(statement.Expression, frame)
+			if err != nil {
+				return nil, false, err
+			}
+			switch collection := collection.(type) {
+			case []any:
+				collection[index.(int64)] = value
+			case map[int64]any:
+				collection[index.(int64)] = value
+			}
+		case *parser.NodeExpressionStatement:
+			_, err := w.expression(statement.Expression, frame)
+			if err != nil {
+				return nil, false, err
+			}
+		case *parser.NodeReturn:
+			value, err := w.expression(statement.Value(), frame)
+			return value, true, err
+		case *parser.NodeForIn:
+			value, returned, err := w.forIn(statement, frame)
+			if err != nil || returned {
+				return value, returned, err
+			}
+		case *parser.NodeConditional:
+			value, returned, err := w.conditional(statement, frame)
+			if err != nil || returned {
+				return value, returned, err
+			}
+		default:
+			return nil, false, fmt.Errorf("the walker does not support: %T", statement)
+		}
+	}
+	return int64(0), false, nil
+}
+
+func (w *walker) forIn(forIn *parser.NodeForIn, frame locals) (any, bool, error) {
+	collection, err := w.expression(forIn.Collection, frame)
+	if err != nil {
+		return nil, false, err
+	}
+	// maps are visited in the order of their keys, like the VM does
+	var keys []any
+	switch collection := collection.(type) {
+	case []any:
+		for i := range collection {
+			keys = append(keys, int64(i))
+		}
+	case map[int64]any:
+		for key := range collection {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].(int64) < keys[j].(int64) })
+	}
+	scope := forIn.Scope()
+	for _, key := range keys {
+		if forIn.Key != "" {
+			frame[scope.Identifier(forIn.Key)] = key
+		}
+		if forIn.Value != "" {
+			switch collection := collection.(type) {
+			case []any:
+				frame[scope.Identifier(forIn.Value)] = collection[key.(int64)]
+			case map[int64]any:
+				frame[scope.Identifier(forIn.Value)] = collection[key.(int64)]
+			}
+		}
+		value, returned, err := w.scope(scope, frame)
+		if err != nil || returned {
+			return value, returned, err
+		}
+	}
+	return nil, false, nil
+}
+
+// conditional runs the body or the first matching arm, the value of an arm
+// is returned by its `return` or is its expression
+func (w *walker) conditional(conditional *parser.NodeConditional, frame locals) (any, bool, error) {
+	condition, err := w.expression(conditional.Condition, frame)
+	if err != nil {
+		return nil, false, err
+	}
+	if body := conditional.Scope(); body != nil {
+		if condition.(bool) {
+			return w.scope(body, frame)
+		}
+		return nil, false, nil
+	}
+	for _, arm := range conditional.Arms {
+		if arm.Value != nil {
+			value, err := w.expression(arm.Value, frame)
+			if err != nil {
+				return nil, false, err
+			}
+			if value != condition {
+				continue
+			}
+		}
+		if scope := arm.Scope(); scope != nil {
+			return w.scope(scope, frame)
+		}
+		value, err := w.expression(arm.Result, frame)
+		return value, false, err
+	}
+	return nil, false, nil
+}
+
+func (w *walker) expression(expression parser.NodeExpression, frame locals) (any, error) {
+	switch expression := expression.(type) {
+	case parser.NodeExpressionLiteral:
+		switch term := expression.Value.(type) {
+		case parser.NodeTermInt32:
+			return strconv.ParseInt(term.Value, 10, 64)
+		case parser.NodeTermBool:
+			return term.Value, nil
+		}
+		return int64(0), nil
+	case *parser.NodeExpressionIdentifier:
+		variable := expression.Identifier.(*parser.NodeTermIdentifier)
+		if value, ok := frame[variable]; ok {
+			return value, nil
+		}
+		function, ok := w.functions[variable.Identifier]
+		if !ok {
+			return nil, fmt.Errorf("the walker does not know: %s", variable.Identifier)
+		}
+		return function, nil
+	case *parser.NodeExpressionBinary:
+		return w.binary(expression, frame)
+	case *parser.NodeExpressionCall:
+		callee, err := w.expression(expression.Callee, frame)
+		if err != nil {
+			return nil, err
+		}
+		arguments, err := w.expressions(expression.Arguments, frame)
+		if err != nil {
+			return nil, err
+		}
+		return w.call(callee.(*parser.NodeFunction), arguments)
+	case *parser.NodeExpressionIndex:
+		collection, err := w.expression(expression.Collection, frame)
+		if err != nil {
+			return nil, err
+		}
+		index, err := w.expression(expression.Index, frame)
+		if err != nil {
+			return nil, err
+		}
+		switch collection := collection.(type) {
+		case []any:
+			return collection[index.(int64)], nil
+		case map[int64]any:
+			return collection[index.(int64)], nil
+		}
+	case *parser.NodeExpressionBuiltin:
+		arguments, err := w.expressions(expression.Arguments, frame)
+		if err != nil {
+			return nil, err
+		}
+		switch collection := arguments[0].(type) {
+		case []any:
+			if expression.Name == "append" {
+				return append(collection[:len(collection):len(collection)], arguments[1:]...), nil
+			}
+			return int64(len(collection)), nil
+		case map[int64]any:
+			return int64(len(collection)), nil
+		}
+	case *parser.NodeExpressionCollection:
+		elements, err := w.expressions(expression.Elements, frame)
+		if err != nil {
+			return nil, err
+		}
+		if expression.Keys != nil {
+			keys, err := w.expressions(expression.Keys, frame)
+			if err != nil {
+				return nil, err
+			}
+			m := make(map[int64]any, len(keys))
+			for i, key := range keys {
+				m[key.(int64)] = elements[i]
+			}
+			return m, nil
+		}
+		for len(elements) < expression.Type.Len() {
+			var zero any = int64(0)
+			if expression.Type.Elem() == types.TypeBool {
+				zero = false
+			}
+			elements = append(elements, zero)
+		}
+		return elements, nil
+	case *parser.NodeConditional:
+		value, _, err := w.conditional(expression, frame)
+		return value, err
+	}
+	return nil, fmt.Errorf("the walker does not support: %T", expression)
+}
+
+func (w *walker) expressions(expressions []parser.NodeExpression, frame locals) ([]any, error) {
+	values := make([]any, len(expressions))
+	for i, expression := range expressions {
+		value, err := w.expression(expression, frame)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (w *walker) binary(binary *parser.NodeExpressionBinary, frame locals) (any, error) {
+	left, err := w.expression(binary.Left, frame)
+	if err != nil {
+		return nil, err
+	}
+	right, err := w.expression(binary.Right, frame)
+	if err != nil {
+		return nil, err
+	}
+	switch binary.Operation {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+
+	l, r := left.(int64), right.(int64)
+	var result int64
+	switch binary.Operation {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "+":
+		result = l + r
+	case "-":
+		result = l - r
+	case "*":
+		result = l * r
+	case "/":
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = l / r
+	}
+	if binary.Type == types.TypeInt32 {
+		result = int64(int32(result))
+	}
+	return result, nil
+}
@@ -0,0 +1,732 @@
+package bytecode
+
+import (
+	"fmt"
+	"math"
+	"shake/parser"
+	"shake/trace"
+	"shake/types"
+	"strconv"
+
+	"github.com/fatih/color"
+)
+
+type compiler struct {
+	program *Program
+	// top level functions by name
+	globals   map[string]int
+	constants map[any]int
+	// variables kept in the globals of the VM instead of a frame, by index
+	variables map[*parser.NodeTermIdentifier]int
+}
+
+type functionCompiler struct {
+	*compiler
+	node     *parser.NodeFunction
+	function *Function
+	slots    map[*parser.NodeTermIdentifier]int
+	captures map[*parser.NodeTermIdentifier]int
+	// line of the node being compiled
+	line uint64
+	// jumps of returns that give a conditional its value, innermost last
+	yields [][]int
+}
+
+// Compile lowers a parsed program to bytecode
+func Compile(program *parser.NodeProgram) (*Program, error) {
+	return CompileGlobals(program, nil)
+}
+
+// CompileGlobals is Compile with the given variables kept in the globals of
+// the VM by index, so their values outlive the call that assigned them
+func CompileGlobals(program *parser.NodeProgram, variables map[*parser.NodeTermIdentifier]int) (*Program, error) {
+	c := &compiler{
+		program: &Program{
+			Constants: []any{},
+			Functions: []*Function{},
+			Methods:   make(map[types.Type]map[string]int),
+			Entry:     -1,
+		},
+		globals:   make(map[string]int),
+		constants: make(map[any]int),
+		variables: variables,
+	}
+	for variable, index := range variables {
+		if index > math.MaxUint16 {
+			return nil, Error(fmt.Sprintf("Too many globals for variable: %s", variable.Identifier), 0)
+		}
+		for len(c.program.Globals) <= index {
+			c.program.Globals = append(c.program.Globals, "")
+		}
+		c.program.Globals[index] = variable.Identifier
+	}
+
+	for _, host := range program.Hosts() {
+		c.globals[host.Identifier] = len(c.program.Functions)
+		c.program.Functions = append(c.program.Functions, &Function{
+			Name:  host.Identifier,
+			Arity: len(host.Type.Params()),
+			Host:  true,
+		})
+	}
+
+	// declare every top level function first so they can call each other,
+	// methods take their receiver as the first argument
+	functions := []*parser.NodeFunction{}
+	for _, statement := range program.Statements() {
+		function, ok := statement.(*parser.NodeFunction)
+		if !ok {
+			continue
+		}
+		index := len(c.program.Functions)
+		arity := len(function.Parameters())
+		if receiver := function.Receiver(); receiver != nil {
+			if c.program.Methods[receiver.Type] == nil {
+				c.program.Methods[receiver.Type] = make(map[string]int)
+			}
+			c.program.Methods[receiver.Type][function.Name()] = index
+			arity++
+		}
+		c.globals[function.QualifiedName()] = index
+		c.program.Functions = append(c.program.Functions, &Function{
+			Name:  function.QualifiedName(),
+			Arity: arity,
+		})
+		functions = append(functions, function)
+	}
+
+	for _, function := range functions {
+		err := c.compileFunction(function, c.program.Functions[c.globals[function.QualifiedName()]], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if entry := program.Entry(); entry != nil {
+		c.program.Entry = c.globals[entry.Name()]
+	} else if main, ok := c.globals["main"]; ok {
+		c.program.Entry = main
+	}
+	return c.program, nil
+}
+
+// Diagnostic is a problem that stops the program from compiling
+type Diagnostic struct {
+	Reason     string
+	LineNumber uint64
+}
+
+func (d *Diagnostic) Error() string {
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Sprintf("%s: %s at line: %d", c.Sprint("[Compiler Error]"), d.Reason, d.LineNumber)
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	return &Diagnostic{Reason: reason, LineNumber: line}
+}
+
+func (c *compiler) constant(value any, line uint64) (int, error) {
+	if index, ok := c.constants[value]; ok {
+		return index, nil
+	}
+	if len(c.program.Constants) > math.MaxUint16 {
+		return 0, Error("Too many constants", line)
+	}
+	index := len(c.program.Constants)
+	c.program.Constants = append(c.program.Constants, value)
+	c.constants[value] = index
+	return index, nil
+}
+
+// compileFunction compiles the body of node into function. enclosing is the
+// compiler of the function a function literal appears in.
+func (c *compiler) compileFunction(node *parser.NodeFunction, function *Function, enclosing *functionCompiler) error {
+	f := &functionCompiler{
+		compiler: c,
+		node:     node,
+		function: function,
+		slots:    make(map[*parser.NodeTermIdentifier]int),
+		captures: make(map[*parser.NodeTermIdentifier]int),
+		line:     node.Line(),
+	}
+
+	// parameters are the first slots of the frame, after the receiver of a method
+	parameters := node.Parameters()
+	if receiver := node.Receiver(); receiver != nil {
+		parameters = append([]*parser.NodeTermIdentifier{receiver}, parameters...)
+	}
+	for _, parameter := range parameters {
+		slot := f.newSlot()
+		f.slots[parameter] = slot
+		if parameter.Captured {
+			function.emit(f.line, OpBoxLocal, slot)
+		}
+	}
+
+	if enclosing != nil {
+		for _, captured := range node.Captures() {
+			// globals are never captured
+			if _, ok := c.variables[captured]; ok {
+				continue
+			}
+			f.captures[captured] = len(function.Captures)
+			if slot, ok := enclosing.slots[captured]; ok {
+				function.Captures = append(function.Captures, Capture{Local: true, Index: slot})
+				continue
+			}
+			index, ok := enclosing.captures[captured]
+			if !ok {
+				return Error(fmt.Sprintf("Captured identifier: %s is not in scope", captured.Identifier), node.Line())
+			}
+			function.Captures = append(function.Captures, Capture{Local: false, Index: index})
+		}
+	}
+
+	err := f.compileScope(node.Scope())
+	if err != nil {
+		return err
+	}
+
+	// functions that return nothing can fall through
+	if node.ReturnType() == types.TypeEmpty {
+		index, err := c.constant(int64(0), f.line)
+		if err != nil {
+			return err
+		}
+		function.emit(f.line, OpConstant, index)
+		function.emit(f.line, OpReturn)
+	}
+	if function.Locals > math.MaxUint16 {
+		return Error(fmt.Sprintf("Too many locals in function: %s", function.Name), node.Line())
+	}
+	return function.err
+}
+
+func (f *functionCompiler) newSlot() int {
+	slot := f.function.Locals
+	f.function.Locals++
+	return slot
+}
+
+func (f *functionCompiler) emit(op Opcode, operands ...int) int {
+	return f.function.emit(f.line, op, operands...)
+}
+
+func (f *functionCompiler) compileScope(scope *parser.NodeScope) error {
+	for _, statement := range scope.Statements() {
+		err := f.compileStatement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *functionCompiler) compileStatement(statement parser.NodeScopedStatement) error {
+	switch statement := statement.(type) {
+	case *parser.NodeAssignment:
+		f.line = statement.LineNumber
+		err := f.compileExpression(*statement.Expression)
+		if err != nil {
+			return err
+		}
+		return f.store(statement.Variable)
+	case *parser.NodeIndexAssignment:
+		f.line = statement.LineNumber
+		err := f.compileExpression(statement.Target.Collection)
+		if err != nil {
+			return err
+		}
+		err = f.compileExpression(statement.Target.Index)
+		if err != nil {
+			return err
+		}
+		err = f.compileExpression(statement.Expression)
+		if err != nil {
+			return err
+		}
+		f.line = statement.LineNumber
+		f.emit(OpSetIndex)
+		return nil
+	case *parser.NodeExpressionStatement:
+		f.line = statement.LineNumber
+		err := f.compileExpression(statement.Expression)
+		if err != nil {
+			return err
+		}
+		f.emit(OpPop)
+		return nil
+	case *parser.NodeReturn:
+		f.line = statement.LineNumber
+		err := f.compileExpression(statement.Value())
+		if err != nil {
+			return err
+		}
+		// inside the arm of a conditional used as a value, return gives the conditional its value
+		if len(f.yields) > 0 {
+			innermost := len(f.yields) - 1
+			f.yields[innermost] = append(f.yields[innermost], f.emit(OpJump, 0))
+			return nil
+		}
+		f.emit(OpReturn)
+		return nil
+	case *parser.NodeForIn:
+		f.line = statement.LineNumber
+		return f.compileForIn(statement)
+	case *parser.NodeConditional:
+		f.line = statement.LineNumber
+		return f.compileConditional(statement, false)
+	}
+	return Error(fmt.Sprintf("Cannot compile statement: %T", statement), f.line)
+}
+
+// store pops the top of the stack into the variable, declaring it if needed
+func (f *functionCompiler) store(variable *parser.NodeTermIdentifier) error {
+	if index, ok := f.variables[variable]; ok {
+		f.emit(OpStoreGlobal, index)
+		return nil
+	}
+	if slot, ok := f.slots[variable]; ok {
+		if variable.Captured {
+			f.emit(OpStoreBoxed, slot)
+		} else {
+			f.emit(OpStoreLocal, slot)
+		}
+		return nil
+	}
+	if index, ok := f.captures[variable]; ok {
+		f.emit(OpStoreCaptured, index)
+		return nil
+	}
+
+	// first assignment declares the variable
+	slot := f.newSlot()
+	f.slots[variable] = slot
+	f.emit(OpStoreLocal, slot)
+	if variable.Captured {
+		f.emit(OpBoxLocal, slot)
+	}
+	return nil
+}
+
+func (f *functionCompiler) load(variable *parser.NodeTermIdentifier) error {
+	if index, ok := f.variables[variable]; ok {
+		f.emit(OpLoadGlobal, index)
+		return nil
+	}
+	if slot, ok := f.slots[variable]; ok {
+		if variable.Captured {
+			f.emit(OpLoadBoxed, slot)
+		} else {
+			f.emit(OpLoadLocal, slot)
+		}
+		return nil
+	}
+	if index, ok := f.captures[variable]; ok {
+		f.emit(OpLoadCaptured, index)
+		return nil
+	}
+	if index, ok := f.globals[variable.Identifier]; ok {
+		f.emit(OpLoadFunction, index)
+		return nil
+	}
+	return Error(fmt.Sprintf("Identifier: %s is not in scope", variable.Identifier), f.line)
+}
+
+func (f *functionCompiler) compileExpression(expression parser.NodeExpression) error {
+	switch expression := expression.(type) {
+	case parser.NodeExpressionLiteral:
+		return f.compileLiteral(expression)
+	case *parser.NodeExpressionIdentifier:
+		variable, ok := expression.Identifier.(*parser.NodeTermIdentifier)
+		if !ok {
+			return Error(fmt.Sprintf("Cannot compile identifier: %T", expression.Identifier), f.line)
+		}
+		return f.load(variable)
+	case *parser.NodeExpressionBinary:
+		return f.compileBinary(expression)
+	case *parser.NodeExpressionCollection:
+		return f.compileCollection(expression)
+	case *parser.NodeExpressionIndex:
+		err := f.compileExpression(expression.Collection)
+		if err != nil {
+			return err
+		}
+		err = f.compileExpression(expression.Index)
+		if err != nil {
+			return err
+		}
+		f.line = expression.LineNumber
+		f.emit(OpIndex)
+		return nil
+	case *parser.NodeExpressionBuiltin:
+		return f.compileBuiltin(expression)
+	case *parser.NodeExpressionCall:
+		err := f.compileExpression(expression.Callee)
+		if err != nil {
+			return err
+		}
+		for _, argument := range expression.Arguments {
+			err = f.compileExpression(argument)
+			if err != nil {
+				return err
+			}
+		}
+		f.line = expression.LineNumber
+		f.emit(OpCall, len(expression.Arguments))
+		return nil
+	case *parser.NodeExpressionMethodCall:
+		return f.compileMethodCall(expression)
+	case *parser.NodeExpressionConversion:
+		err := f.compileExpression(expression.Value)
+		if err != nil {
+			return err
+		}
+		// an interface value converted to another interface keeps the type of its value
+		if expression.Value.GetType().Kind() == types.KindInterface {
+			return nil
+		}
+		index, err := f.constant(expression.Value.GetType(), expression.LineNumber)
+		if err != nil {
+			return err
+		}
+		f.line = expression.LineNumber
+		f.emit(OpInterface, index)
+		return nil
+	case *parser.NodeFunction:
+		index := len(f.program.Functions)
+		if index > math.MaxUint16 {
+			return Error("Too many functions", expression.Line())
+		}
+		function := &Function{
+			Name:  fmt.Sprintf("%s$%d", f.function.Name, index),
+			Arity: len(expression.Parameters()),
+		}
+		f.program.Functions = append(f.program.Functions, function)
+		err := f.compileFunction(expression, function, f)
+		if err != nil {
+			return err
+		}
+		f.emit(OpClosure, index)
+		return nil
+	case *parser.NodeConditional:
+		return f.compileConditional(expression, true)
+	}
+	return Error(fmt.Sprintf("Cannot compile expression: %T", expression), f.line)
+}
+
+// compileMethodCall calls a method with the receiver as the first argument,
+// the method of an interface value is found by the type of its value
+func (f *functionCompiler) compileMethodCall(call *parser.NodeExpressionMethodCall) error {
+	f.line = call.LineNumber
+	if call.Function != nil {
+		f.emit(OpLoadFunction, f.globals[call.Function.QualifiedName()])
+		err := f.compileExpression(call.Receiver)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := f.compileExpression(call.Receiver)
+		if err != nil {
+			return err
+		}
+		name, err := f.constant(call.Method, call.LineNumber)
+		if err != nil {
+			return err
+		}
+		f.line = call.LineNumber
+		f.emit(OpLoadMethod, name)
+	}
+	for _, argument := range call.Arguments {
+		err := f.compileExpression(argument)
+		if err != nil {
+			return err
+		}
+	}
+	f.line = call.LineNumber
+	f.emit(OpCall, len(call.Arguments)+1)
+	return nil
+}
+
+func (f *functionCompiler) compileLiteral(literal parser.NodeExpressionLiteral) error {
+	var value any
+	switch term := literal.Value.(type) {
+	case parser.NodeTermInt32:
+		number, err := strconv.ParseInt(term.Value, 10, 64)
+		if err != nil {
+			return Error(fmt.Sprintf("Invalid number: %s", term.Value), f.line)
+		}
+		value = number
+	case parser.NodeTermBool:
+		value = term.Value
+	case parser.NodeTermEmpty:
+		value = int64(0)
+	default:
+		return Error(fmt.Sprintf("Cannot compile literal: %T", literal.Value), f.line)
+	}
+	index, err := f.constant(value, f.line)
+	if err != nil {
+		return err
+	}
+	f.emit(OpConstant, index)
+	return nil
+}
+
+var binaryOpcodes = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSubtract,
+	"*":  OpMultiply,
+	"/":  OpDivide,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessEqual,
+	">":  OpGreater,
+	">=": OpGreaterEqual,
+}
+
+func (f *functionCompiler) compileBinary(binary *parser.NodeExpressionBinary) error {
+	err := f.compileExpression(binary.Left)
+	if err != nil {
+		return err
+	}
+	err = f.compileExpression(binary.Right)
+	if err != nil {
+		return err
+	}
+	op, ok := binaryOpcodes[binary.Operation]
+	if !ok {
+		return Error(fmt.Sprintf("Cannot compile operation: %s", binary.Operation), f.line)
+	}
+	switch op {
+	case OpAdd, OpSubtract, OpMultiply, OpDivide:
+		width := 32
+		if binary.Left.GetType() == types.TypeInt64 {
+			width = 64
+		}
+		f.emit(op, width)
+	default:
+		f.emit(op)
+	}
+	return nil
+}
+
+func (f *functionCompiler) compileCollection(collection *parser.NodeExpressionCollection) error {
+	for i, element := range collection.Elements {
+		if collection.Keys != nil {
+			err := f.compileExpression(collection.Keys[i])
+			if err != nil {
+				return err
+			}
+		}
+		err := f.compileExpression(element)
+		if err != nil {
+			return err
+		}
+	}
+
+	count := len(collection.Elements)
+	if count > math.MaxUint16 {
+		return Error("Too many elements in collection literal", f.line)
+	}
+	switch collection.Type.Kind() {
+	case types.KindArray:
+		f.emit(OpArray, count, collection.Type.Len())
+	case types.KindSlice:
+		f.emit(OpSlice, count)
+	case types.KindMap:
+		f.emit(OpMap, count)
+	default:
+		return Error(fmt.Sprintf("Cannot compile collection of type: %s", collection.Type), f.line)
+	}
+	return nil
+}
+
+func (f *functionCompiler) compileBuiltin(builtin *parser.NodeExpressionBuiltin) error {
+	for _, argument := range builtin.Arguments {
+		err := f.compileExpression(argument)
+		if err != nil {
+			return err
+		}
+	}
+	f.line = builtin.LineNumber
+	switch builtin.Name {
+	case "len":
+		f.emit(OpLength)
+	case "append":
+		f.emit(OpAppend, len(builtin.Arguments)-1)
+	default:
+		return Error(fmt.Sprintf("Unknown builtin: %s", builtin.Name), f.line)
+	}
+	return nil
+}
+
+// compileForIn lowers `for k, v in xs` to a loop over an index into xs,
+// or into the sorted keys of xs when it is a map
+func (f *functionCompiler) compileForIn(forIn *parser.NodeForIn) error {
+	err := f.compileExpression(forIn.Collection)
+	if err != nil {
+		return err
+	}
+	collection := f.newSlot()
+	f.emit(OpStoreLocal, collection)
+
+	isMap := forIn.Collection.GetType().Kind() == types.KindMap
+	keys := collection
+	if isMap {
+		keys = f.newSlot()
+		f.emit(OpLoadLocal, collection)
+		f.emit(OpKeys)
+		f.emit(OpStoreLocal, keys)
+	}
+
+	zero, err := f.constant(int64(0), f.line)
+	if err != nil {
+		return err
+	}
+	one, err := f.constant(int64(1), f.line)
+	if err != nil {
+		return err
+	}
+	index := f.newSlot()
+	f.emit(OpConstant, zero)
+	f.emit(OpStoreLocal, index)
+
+	// while index < len(keys)
+	loop := len(f.function.Code)
+	f.emit(OpLoadLocal, index)
+	f.emit(OpLoadLocal, keys)
+	f.emit(OpLength)
+	f.emit(OpLess)
+	exit := f.emit(OpJumpIfFalse, 0)
+
+	scope := forIn.Scope()
+	if forIn.Key != "" {
+		if isMap {
+			f.emit(OpLoadLocal, keys)
+			f.emit(OpLoadLocal, index)
+			f.emit(OpIndex)
+		} else {
+			f.emit(OpLoadLocal, index)
+		}
+		err = f.store(scope.Identifier(forIn.Key))
+		if err != nil {
+			return err
+		}
+	}
+	if forIn.Value != "" {
+		f.emit(OpLoadLocal, collection)
+		if isMap {
+			f.emit(OpLoadLocal, keys)
+			f.emit(OpLoadLocal, index)
+			f.emit(OpIndex)
+		} else {
+			f.emit(OpLoadLocal, index)
+		}
+		f.emit(OpIndex)
+		err = f.store(scope.Identifier(forIn.Value))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = f.compileScope(scope)
+	if err != nil {
+		return err
+	}
+
+	f.emit(OpLoadLocal, index)
+	f.emit(OpConstant, one)
+	f.emit(OpAdd, 64)
+	f.emit(OpStoreLocal, index)
+	f.emit(OpJump, loop)
+	f.function.patch(exit)
+	return nil
+}
+
+// compileConditional compiles a conditional, which leaves its value on the
+// stack when it is used as an expression
+func (f *functionCompiler) compileConditional(conditional *parser.NodeConditional, isExpression bool) error {
+	line := conditional.LineNumber
+
+	// `if condition { ... }`
+	if body := conditional.Scope(); body != nil {
+		err := f.compileExpression(conditional.Condition)
+		if err != nil {
+			return err
+		}
+		f.line = line
+		end := f.emit(OpJumpIfFalse, 0)
+		err = f.compileScope(body)
+		if err != nil {
+			return err
+		}
+		f.function.patch(end)
+		return nil
+	}
+
+	// arms are compared against the subject in order, the first match wins
+	err := f.compileExpression(conditional.Condition)
+	if err != nil {
+		return err
+	}
+	subject := f.newSlot()
+	f.emit(OpStoreLocal, subject)
+
+	ends := []int{}
+	for _, arm := range conditional.Arms {
+		f.line = arm.LineNumber
+		next := -1
+		if arm.Value != nil {
+			f.emit(OpLoadLocal, subject)
+			err = f.compileExpression(arm.Value)
+			if err != nil {
+				return err
+			}
+			f.emit(OpEqual)
+			next = f.emit(OpJumpIfFalse, 0)
+		}
+
+		if scope := arm.Scope(); scope != nil {
+			if isExpression {
+				f.yields = append(f.yields, []int{})
+			}
+			err = f.compileScope(scope)
+			if err != nil {
+				return err
+			}
+			if isExpression {
+				ends = append(ends, f.yields[len(f.yields)-1]...)
+				f.yields = f.yields[:len(f.yields)-1]
+			}
+		} else {
+			err = f.compileExpression(arm.Result)
+			if err != nil {
+				return err
+			}
+			if !isExpression {
+				f.emit(OpPop)
+			}
+		}
+		ends = append(ends, f.emit(OpJump, 0))
+
+		if next >= 0 {
+			f.function.patch(next)
+		}
+	}
+
+	// the parser makes sure a conditional used as a value always matches an arm
+	if isExpression {
+		f.line = line
+		message, err := f.constant(fmt.Sprintf("no arm of the if at line %d matched", line), line)
+		if err != nil {
+			return err
+		}
+		f.emit(OpFail, message)
+	}
+	for _, end := range ends {
+		f.function.patch(end)
+	}
+	return nil
+}
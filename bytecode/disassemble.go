@@ -0,0 +1,86 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Disassemble writes a readable listing of every function in the program
+func Disassemble(w io.Writer, program *Program) error {
+	for i, function := range program.Functions {
+		entry := ""
+		if i == program.Entry {
+			entry = " (entry)"
+		}
+		if function.Host {
+			entry = " (host)"
+		}
+		_, err := fmt.Fprintf(w, "fn %s%s: arity %d, locals %d\n", function.Name, entry, function.Arity, function.Locals)
+		if err != nil {
+			return err
+		}
+		for j, capture := range function.Captures {
+			from := "captured"
+			if capture.Local {
+				from = "local"
+			}
+			_, err = fmt.Fprintf(w, "  capture %d <- %s %d\n", j, from, capture.Index)
+			if err != nil {
+				return err
+			}
+		}
+
+		for offset := 0; offset < len(function.Code); {
+			line, size := DisassembleInstruction(program, function, offset)
+			_, err = fmt.Fprintf(w, "  %04d  line %-4d %s\n", offset, function.Lines[offset], line)
+			if err != nil {
+				return err
+			}
+			offset += size
+		}
+		_, err = fmt.Fprintln(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisassembleInstruction describes the instruction at offset and returns its size in bytes
+func DisassembleInstruction(program *Program, function *Function, offset int) (string, int) {
+	op := Opcode(function.Code[offset])
+	definition, ok := Lookup(op)
+	if !ok {
+		return fmt.Sprintf("UNKNOWN %d", op), 1
+	}
+
+	operands := []string{}
+	size := 1
+	for _, width := range definition.OperandWidths {
+		operand := 0
+		switch width {
+		case 1:
+			operand = int(function.Code[offset+size])
+		case 2:
+			operand = ReadUint16(function.Code, offset+size)
+		}
+		size += width
+		operands = append(operands, fmt.Sprint(operand))
+	}
+
+	// show what the operand refers to
+	comment := ""
+	switch op {
+	case OpConstant, OpFail, OpLoadMethod:
+		comment = fmt.Sprintf("  ; %#v", program.Constants[ReadUint16(function.Code, offset+1)])
+	case OpInterface:
+		comment = fmt.Sprintf("  ; %s", program.Constants[ReadUint16(function.Code, offset+1)])
+	case OpLoadFunction, OpClosure:
+		comment = "  ; " + program.Functions[ReadUint16(function.Code, offset+1)].Name
+	case OpLoadGlobal, OpStoreGlobal:
+		comment = "  ; " + program.Globals[ReadUint16(function.Code, offset+1)]
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%-14s %s", definition.Name, strings.Join(operands, " "))) + comment, size
+}
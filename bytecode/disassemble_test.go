@@ -0,0 +1,53 @@
+package bytecode_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"shake/bytecode"
+	"shake/codegen/testdata/fixture"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func compile(tb testing.TB, source string) *bytecode.Program {
+	tb.Helper()
+	return fixture.Compile(tb, fixture.ParseString(tb, source))
+}
+
+// TestDisassemble compares the listing of every program in testdata with its
+// .golden file, run with -update to accept a change of the instruction set
+func TestDisassemble(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.shk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			var listing bytes.Buffer
+			err := bytecode.Disassemble(&listing, fixture.Compile(t, fixture.ParseFile(t, path)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := strings.TrimSuffix(path, ".shk") + ".golden"
+			if *update {
+				err = os.WriteFile(golden, listing.Bytes(), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listing.String() != string(want) {
+				t.Errorf("disassembly of %s changed, run go test -update to accept it\ngot:\n%s\nwant:\n%s", path, listing.String(), want)
+			}
+		})
+	}
+}
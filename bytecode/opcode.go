@@ -0,0 +1,130 @@
+package bytecode
+
+type Opcode byte
+
+const (
+	// push Constants[u16]
+	OpConstant Opcode = iota
+	OpPop
+	// locals live in the slots of the current call frame
+	OpLoadLocal
+	OpStoreLocal
+	// captured locals are moved into a box so closures can share them
+	OpBoxLocal
+	OpLoadBoxed
+	OpStoreBoxed
+	// the boxes a closure captured from its enclosing functions
+	OpLoadCaptured
+	OpStoreCaptured
+	// variables kept in the globals of the VM, which outlive a call
+	OpLoadGlobal
+	OpStoreGlobal
+	// push Functions[u16] as a value, top level functions have no captures
+	OpLoadFunction
+	// create a closure of Functions[u16] from the current frame
+	OpClosure
+	// arithmetic takes the operand width (32 or 64) as a u8 operand
+	OpAdd
+	OpSubtract
+	OpMultiply
+	OpDivide
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	// jumps take an absolute offset into the code
+	OpJump
+	OpJumpIfFalse
+	// call the function below u8 arguments
+	OpCall
+	// wrap the value in an interface value that remembers its type, Constants[u16]
+	OpInterface
+	// replace an interface value with its method named Constants[u16] followed
+	// by the value the interface holds, ready to be called
+	OpLoadMethod
+	OpReturn
+	// u16 elements followed by the u16 length of the array
+	OpArray
+	// u16 elements
+	OpSlice
+	// u16 key value pairs
+	OpMap
+	OpIndex
+	// set the element of the collection below the index and the value
+	OpSetIndex
+	OpLength
+	// append u8 elements to a slice
+	OpAppend
+	// the sorted keys of a map as a slice
+	OpKeys
+	// stop with the message in Constants[u16]
+	OpFail
+)
+
+type Definition struct {
+	Name string
+	// the width in bytes of each operand
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]Definition{
+	OpConstant:      {"CONSTANT", []int{2}},
+	OpPop:           {"POP", []int{}},
+	OpLoadLocal:     {"LOAD_LOCAL", []int{2}},
+	OpStoreLocal:    {"STORE_LOCAL", []int{2}},
+	OpBoxLocal:      {"BOX_LOCAL", []int{2}},
+	OpLoadBoxed:     {"LOAD_BOXED", []int{2}},
+	OpStoreBoxed:    {"STORE_BOXED", []int{2}},
+	OpLoadCaptured:  {"LOAD_CAPTURED", []int{2}},
+	OpStoreCaptured: {"STORE_CAPTURED", []int{2}},
+	OpLoadGlobal:    {"LOAD_GLOBAL", []int{2}},
+	OpStoreGlobal:   {"STORE_GLOBAL", []int{2}},
+	OpLoadFunction:  {"LOAD_FUNCTION", []int{2}},
+	OpClosure:       {"CLOSURE", []int{2}},
+	OpAdd:           {"ADD", []int{1}},
+	OpSubtract:      {"SUBTRACT", []int{1}},
+	OpMultiply:      {"MULTIPLY", []int{1}},
+	OpDivide:        {"DIVIDE", []int{1}},
+	OpEqual:         {"EQUAL", []int{}},
+	OpNotEqual:      {"NOT_EQUAL", []int{}},
+	OpLess:          {"LESS", []int{}},
+	OpLessEqual:     {"LESS_EQUAL", []int{}},
+	OpGreater:       {"GREATER", []int{}},
+	OpGreaterEqual:  {"GREATER_EQUAL", []int{}},
+	OpJump:          {"JUMP", []int{2}},
+	OpJumpIfFalse:   {"JUMP_IF_FALSE", []int{2}},
+	OpCall:          {"CALL", []int{1}},
+	OpInterface:     {"INTERFACE", []int{2}},
+	OpLoadMethod:    {"LOAD_METHOD", []int{2}},
+	OpReturn:        {"RETURN", []int{}},
+	OpArray:         {"ARRAY", []int{2, 2}},
+	OpSlice:         {"SLICE", []int{2}},
+	OpMap:           {"MAP", []int{2}},
+	OpIndex:         {"INDEX", []int{}},
+	OpSetIndex:      {"SET_INDEX", []int{}},
+	OpLength:        {"LENGTH", []int{}},
+	OpAppend:        {"APPEND", []int{1}},
+	OpKeys:          {"KEYS", []int{}},
+	OpFail:          {"FAIL", []int{2}},
+}
+
+// Lookup returns the definition of an opcode
+func Lookup(op Opcode) (Definition, bool) {
+	definition, ok := definitions[op]
+	return definition, ok
+}
+
+func (op Opcode) String() string {
+	definition, ok := definitions[op]
+	if !ok {
+		return "UNKNOWN"
+	}
+	return definition.Name
+}
+
+// ReadUint16 reads a two byte operand
+func ReadUint16(code []byte, offset int) int {
+	return int(code[offset])<<8 | int(code[offset+1])
+}
@@ -0,0 +1,96 @@
+package bytecode
+
+import (
+	"fmt"
+	"shake/types"
+)
+
+type Program struct {
+	// int64, bool, string (failure messages and method names) or types.Type
+	// (the types of values converted to interfaces)
+	Constants []any
+	Functions []*Function
+	// the methods of each type as indexes of Functions, by name
+	Methods map[types.Type]map[string]int
+	// index of the function to run, -1 when the program has none
+	Entry int
+	// the names of the variables kept in globals, by index
+	Globals []string
+}
+
+// Capture describes where a closure takes a captured variable from when it is created
+type Capture struct {
+	// set when the variable is a local of the enclosing function,
+	// otherwise it is one of the enclosing function's own captures
+	Local bool
+	Index int
+}
+
+type Function struct {
+	Name   string
+	Arity  int
+	Locals int
+	// set for functions the host provides, which have no code
+	Host bool
+	// only set for function literals
+	Captures []Capture
+	Code     []byte
+	// the source line of every byte of Code
+	Lines []uint64
+	// the first operand that did not fit in its width, the compiler reports
+	// it once the function is compiled
+	err error
+}
+
+// FunctionIndex returns the index of the function with the given name
+func (p *Program) FunctionIndex(name string) (int, bool) {
+	for i, function := range p.Functions {
+		if function.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (f *Function) emit(line uint64, op Opcode, operands ...int) int {
+	offset := len(f.Code)
+	f.Code = append(f.Code, byte(op))
+	for i, width := range definitions[op].OperandWidths {
+		f.fits(line, op, operands[i], width)
+		switch width {
+		case 1:
+			f.Code = append(f.Code, byte(operands[i]))
+		case 2:
+			f.Code = append(f.Code, byte(operands[i]>>8), byte(operands[i]))
+		}
+	}
+	for len(f.Lines) < len(f.Code) {
+		f.Lines = append(f.Lines, line)
+	}
+	return offset
+}
+
+// patch points the jump at offset to the current end of the code
+func (f *Function) patch(offset int) {
+	target := len(f.Code)
+	f.fits(f.Lines[offset], Opcode(f.Code[offset]), target, 2)
+	f.Code[offset+1] = byte(target >> 8)
+	f.Code[offset+2] = byte(target)
+}
+
+// fits records an error when operand does not fit in width bytes
+func (f *Function) fits(line uint64, op Opcode, operand int, width int) {
+	if f.err != nil || (operand >= 0 && operand < 1<<(8*width)) {
+		return
+	}
+	switch op {
+	case OpJump, OpJumpIfFalse:
+		f.err = Error(fmt.Sprintf("Function: %s is too large, jumps reach at most %d bytes of code", f.Name, 1<<(8*width)-1), line)
+	case OpCall:
+		f.err = Error(fmt.Sprintf("Too many arguments: %d, at most %d can be passed", operand, 1<<(8*width)-1), line)
+	case OpAppend:
+		f.err = Error(fmt.Sprintf("Too many elements: %d to append, at most %d can be appended at once", operand, 1<<(8*width)-1), line)
+	default:
+		f.err = Error(fmt.Sprintf("Operand: %d of %s does not fit in %d bytes", operand, op, width), line)
+	}
+}
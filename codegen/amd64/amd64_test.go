@@ -0,0 +1,52 @@
+package amd64_test
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"shake/codegen/amd64"
+	"shake/codegen/testdata/fixture"
+	"testing"
+)
+
+// TestBuild assembles and links every program of the codegen corpus at every
+// optimization level and checks the executable exits with the result of the VM
+func TestBuild(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skipf("the executables run on linux/amd64, not %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	for _, tool := range []string{"as", "ld"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("no %s: %v", tool, err)
+		}
+	}
+
+	for _, path := range fixture.Corpus(t) {
+		for level := range 3 {
+			t.Run(fmt.Sprintf("%s/O%d", filepath.Base(path), level), func(t *testing.T) {
+				program := fixture.ParseFile(t, path)
+				want := fixture.Result(t, program)
+
+				executable := filepath.Join(t.TempDir(), "program")
+				err := amd64.Build(fixture.Module(t, program, level), executable)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = exec.Command(executable).Run()
+				code := 0
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					code = exit.ExitCode()
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				// the exit status keeps the low byte of the result
+				if code != int(uint8(want)) {
+					t.Errorf("executable exited with %d, the VM returned %d", code, want)
+				}
+			})
+		}
+	}
+}
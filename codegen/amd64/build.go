@@ -0,0 +1,44 @@
+package amd64
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"shake/ir"
+)
+
+// Build assembles and links the module with the host as and ld into a static
+// executable at output
+func Build(module *ir.Module, output string) error {
+	source, err := Generate(module)
+	if err != nil {
+		return err
+	}
+
+	directory, err := os.MkdirTemp("", "shake")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(directory)
+	assembly := filepath.Join(directory, "main.s")
+	object := filepath.Join(directory, "main.o")
+	err = os.WriteFile(assembly, []byte(source), 0o644)
+	if err != nil {
+		return err
+	}
+
+	for _, arguments := range [][]string{
+		{"as", "-o", object, assembly},
+		{"ld", "-static", "-o", output, object},
+	} {
+		command := exec.Command(arguments[0], arguments[1:]...)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+		err = command.Run()
+		if err != nil {
+			return fmt.Errorf("%s failed: %w", arguments[0], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package amd64
+
+import (
+	"fmt"
+	"math"
+	"shake/ir"
+	"shake/types"
+	"strconv"
+	"strings"
+)
+
+// argumentRegisters are the System V registers of the first six integer arguments
+var argumentRegisters = []string{"%rdi", "%rsi", "%rdx", "%rcx", "%r8", "%r9"}
+
+// runtime is the entry stub and the failure routine. shk.fail writes the
+// message in %rdi with length %rsi and the line in %rdx to stderr and exits with 3.
+const runtime = `	.text
+	.globl _start
+_start:
+	call %[1]s
+	movl %%eax, %%edi
+	movl $60, %%eax
+	syscall
+
+shk.fail:
+	movq %%rdx, %%r12
+	movq %%rsi, %%rdx
+	movq %%rdi, %%rsi
+	movl $2, %%edi
+	movl $1, %%eax
+	syscall
+	subq $32, %%rsp
+	leaq 31(%%rsp), %%rsi
+	movb $10, (%%rsi)
+	movq %%r12, %%rax
+	movl $10, %%ecx
+1:
+	decq %%rsi
+	xorl %%edx, %%edx
+	divq %%rcx
+	addb $48, %%dl
+	movb %%dl, (%%rsi)
+	testq %%rax, %%rax
+	jnz 1b
+	leaq 32(%%rsp), %%rdx
+	subq %%rsi, %%rdx
+	movl $2, %%edi
+	movl $1, %%eax
+	syscall
+	movl $3, %%edi
+	movl $60, %%eax
+	syscall
+`
+
+var mnemonics = map[opcode]string{
+	opAdd:      "add",
+	opSubtract: "sub",
+	opMultiply: "imul",
+}
+
+type emitter struct {
+	out        strings.Builder
+	allocation allocation
+}
+
+// Generate translates a module to GNU assembler source for a static
+// executable that does not need libc
+func Generate(module *ir.Module) (string, error) {
+	entry := module.Function(module.Entry)
+	if entry == nil {
+		return "", Error("Program has no entry function, mark one with (entry) or call it main", 0)
+	}
+	if len(entry.Params) > 0 {
+		return "", Error(fmt.Sprintf("Entry function: %s cannot take parameters", entry.Name), entry.Line)
+	}
+	if entry.Result != types.TypeInt32 && entry.Result != types.TypeEmpty {
+		return "", Error(fmt.Sprintf("Entry function: %s must return int32", entry.Name), entry.Line)
+	}
+
+	messages := []string{}
+	l := &lowerer{messages: &messages}
+	functions := []*function{}
+	for _, f := range module.Functions {
+		lowered, err := l.lowerFunction(f)
+		if err != nil {
+			return "", err
+		}
+		functions = append(functions, lowered)
+	}
+
+	e := &emitter{}
+	fmt.Fprintf(&e.out, runtime, name(entry.Name))
+	for _, f := range functions {
+		e.emitFunction(f)
+	}
+
+	e.out.WriteString("\n\t.section .rodata\n")
+	for i, message := range messages {
+		fmt.Fprintf(&e.out, "shk.message%d:\n\t.ascii %s\n", i, strconv.Quote("[Runtime Error]: "+message+" at line: "))
+		fmt.Fprintf(&e.out, "\t.set shk.message%d.length, . - shk.message%d\n", i, i)
+	}
+	return e.out.String(), nil
+}
+
+// name prefixes functions so they never clash with the runtime
+func name(identifier string) string {
+	return "shk." + identifier
+}
+
+func (e *emitter) line(format string, arguments ...any) {
+	e.out.WriteString("\t")
+	fmt.Fprintf(&e.out, format, arguments...)
+	e.out.WriteString("\n")
+}
+
+// load puts an operand in a machine register
+func (e *emitter) load(o operand, register string) {
+	switch {
+	case !o.immediate:
+		e.line("movq %s, %s", e.allocation.locations[o.register], register)
+	case o.value >= math.MinInt32 && o.value <= math.MaxInt32:
+		e.line("movq $%d, %s", o.value, register)
+	default:
+		e.line("movabsq $%d, %s", o.value, register)
+	}
+}
+
+// store writes a machine register to the location of a virtual register
+func (e *emitter) store(register string, dst int) {
+	e.line("movq %s, %s", register, e.allocation.locations[dst])
+}
+
+func (e *emitter) emitFunction(f *function) {
+	e.allocation = allocate(f)
+
+	// keep %rsp 16 byte aligned at calls, the return address and %rbp cancel out
+	frame := 8 * e.allocation.spills
+	if (len(e.allocation.saved)+e.allocation.spills)%2 == 1 {
+		frame += 8
+	}
+
+	fmt.Fprintf(&e.out, "\n%s:\n", name(f.name))
+	e.line("pushq %%rbp")
+	e.line("movq %%rsp, %%rbp")
+	for _, register := range e.allocation.saved {
+		e.line("pushq %s", register)
+	}
+	if frame > 0 {
+		e.line("subq $%d, %%rsp", frame)
+	}
+
+	for _, i := range f.instructions {
+		e.emitInstruction(i)
+	}
+}
+
+func (e *emitter) epilogue() {
+	e.line("leaq -%d(%%rbp), %%rsp", 8*len(e.allocation.saved))
+	for i := len(e.allocation.saved) - 1; i >= 0; i-- {
+		e.line("popq %s", e.allocation.saved[i])
+	}
+	e.line("popq %%rbp")
+	e.line("ret")
+}
+
+func (e *emitter) fail(message int, line uint64) {
+	e.line("leaq shk.message%d(%%rip), %%rdi", message)
+	e.line("movq $shk.message%d.length, %%rsi", message)
+	e.line("movq $%d, %%rdx", line)
+	e.line("call shk.fail")
+}
+
+func (e *emitter) emitInstruction(i instruction) {
+	switch i.op {
+	case opMove:
+		e.load(i.args[0], "%rax")
+		e.store("%rax", i.dst)
+	case opParameter:
+		if i.index < len(argumentRegisters) {
+			e.store(argumentRegisters[i.index], i.dst)
+			return
+		}
+		// the rest are on the stack above the return address
+		e.line("movq %d(%%rbp), %%rax", 16+8*(i.index-len(argumentRegisters)))
+		e.store("%rax", i.dst)
+	case opAdd, opSubtract, opMultiply:
+		e.load(i.args[0], "%rax")
+		e.load(i.args[1], "%rcx")
+		mnemonic := mnemonics[i.op]
+		if i.bits == 32 {
+			// int32 wraps, then stays sign extended in the 64 bit register
+			e.line("%sl %%ecx, %%eax", mnemonic)
+			e.line("movslq %%eax, %%rax")
+		} else {
+			e.line("%sq %%rcx, %%rax", mnemonic)
+		}
+		e.store("%rax", i.dst)
+	case opDivide:
+		e.load(i.args[0], "%rax")
+		e.load(i.args[1], "%rcx")
+		e.line("testq %%rcx, %%rcx")
+		e.line("jnz 1f")
+		e.fail(i.index, i.line)
+		e.out.WriteString("1:\n")
+		// dividing the minimum by -1 traps, negating wraps like the other backends
+		e.line("cmpq $-1, %%rcx")
+		e.line("jne 2f")
+		if i.bits == 32 {
+			e.line("negl %%eax")
+			e.line("movslq %%eax, %%rax")
+		} else {
+			e.line("negq %%rax")
+		}
+		e.line("jmp 3f")
+		e.out.WriteString("2:\n")
+		if i.bits == 32 {
+			e.line("cltd")
+			e.line("idivl %%ecx")
+			e.line("movslq %%eax, %%rax")
+		} else {
+			e.line("cqto")
+			e.line("idivq %%rcx")
+		}
+		e.out.WriteString("3:\n")
+		e.store("%rax", i.dst)
+	case opCompare:
+		e.load(i.args[0], "%rax")
+		e.load(i.args[1], "%rcx")
+		e.line("cmpq %%rcx, %%rax")
+		e.line("set%s %%al", i.condition)
+		e.line("movzbq %%al, %%rax")
+		e.store("%rax", i.dst)
+	case opCall:
+		stackArguments := 0
+		if len(i.args) > len(argumentRegisters) {
+			stackArguments = len(i.args) - len(argumentRegisters)
+		}
+		if stackArguments%2 == 1 {
+			e.line("subq $8, %%rsp")
+		}
+		for j := len(i.args) - 1; j >= len(argumentRegisters); j-- {
+			e.load(i.args[j], "%rax")
+			e.line("pushq %%rax")
+		}
+		// allocated locations are never argument registers, so loading cannot clobber them
+		for j := 0; j < len(i.args) && j < len(argumentRegisters); j++ {
+			e.load(i.args[j], argumentRegisters[j])
+		}
+		e.line("call %s", name(i.target))
+		if stackArguments > 0 {
+			e.line("addq $%d, %%rsp", 8*(stackArguments+stackArguments%2))
+		}
+		e.store("%rax", i.dst)
+	case opReturn:
+		e.load(i.args[0], "%rax")
+		e.epilogue()
+	case opJump:
+		e.line("jmp %s", i.target)
+	case opJumpIfFalse:
+		e.load(i.args[0], "%rax")
+		e.line("testq %%rax, %%rax")
+		e.line("jz %s", i.target)
+	case opLabel:
+		fmt.Fprintf(&e.out, "%s:\n", i.target)
+	case opFail:
+		e.fail(i.index, i.line)
+	}
+}
@@ -0,0 +1,231 @@
+package amd64
+
+import (
+	"fmt"
+	"shake/ir"
+	"shake/trace"
+	"shake/types"
+
+	"github.com/fatih/color"
+)
+
+type opcode int
+
+const (
+	// dst = args[0]
+	opMove opcode = iota
+	// dst = args[0] op args[1], arithmetic uses the width in bits
+	opAdd
+	opSubtract
+	opMultiply
+	opDivide
+	// dst = args[0] condition args[1]
+	opCompare
+	// dst = the parameter at index
+	opParameter
+	// dst = target(args...)
+	opCall
+	opReturn
+	opJump
+	// jump to target when args[0] is zero
+	opJumpIfFalse
+	opLabel
+	// stop the program with the message at index
+	opFail
+)
+
+// operand is a virtual register or an immediate
+type operand struct {
+	immediate bool
+	register  int
+	value     int64
+}
+
+func reg(register int) operand {
+	return operand{register: register}
+}
+
+func imm(value int64) operand {
+	return operand{immediate: true, value: value}
+}
+
+// instruction is a three address instruction over an unlimited number of
+// virtual registers, which the register allocator maps to machine locations
+type instruction struct {
+	op opcode
+	// virtual register the result is written to
+	dst  int
+	args []operand
+	bits int
+	// condition of a compare
+	condition string
+	// label of a jump or name of the called function
+	target string
+	index  int
+	line   uint64
+}
+
+// defines reports whether the instruction writes dst
+func (i instruction) defines() bool {
+	switch i.op {
+	case opMove, opAdd, opSubtract, opMultiply, opDivide, opCompare, opParameter, opCall:
+		return true
+	}
+	return false
+}
+
+type function struct {
+	name         string
+	parameters   int
+	returnsValue bool
+	instructions []instruction
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[Assembly Error]"), reason, line)
+}
+
+// lowerer translates SSA functions to instructions. Every SSA value gets the
+// virtual register of its id, and constants become immediates.
+type lowerer struct {
+	function *function
+	// messages of runtime failures, shared by every function
+	messages *[]string
+}
+
+func (l *lowerer) message(text string) int {
+	*l.messages = append(*l.messages, text)
+	return len(*l.messages) - 1
+}
+
+func (l *lowerer) emit(i instruction) {
+	l.function.instructions = append(l.function.instructions, i)
+}
+
+func operandOf(v *ir.Value) operand {
+	if v.Op == ir.OpConst {
+		return imm(v.Const)
+	}
+	return reg(v.ID)
+}
+
+func bits(t types.Type) int {
+	if t == types.TypeInt64 {
+		return 64
+	}
+	return 32
+}
+
+func label(f *ir.Function, block *ir.Block) string {
+	return fmt.Sprintf(".L%s.%s", f.Name, block)
+}
+
+var arithmetic = map[ir.Op]opcode{
+	ir.OpAdd: opAdd,
+	ir.OpSub: opSubtract,
+	ir.OpMul: opMultiply,
+	ir.OpDiv: opDivide,
+}
+
+// conditions maps comparisons to the suffix of the matching setcc instruction
+var conditions = map[ir.Op]string{
+	ir.OpEq: "e",
+	ir.OpNe: "ne",
+	ir.OpLt: "l",
+	ir.OpLe: "le",
+	ir.OpGt: "g",
+	ir.OpGe: "ge",
+}
+
+func (l *lowerer) lowerFunction(f *ir.Function) (*function, error) {
+	for _, t := range append([]types.Type{f.Result}, f.Params...) {
+		if t.Kind() != types.KindBasic {
+			return nil, Error(fmt.Sprintf("Type: %s is not supported by the amd64 backend", t), f.Line)
+		}
+	}
+	l.function = &function{
+		name:         f.Name,
+		parameters:   len(f.Params),
+		returnsValue: f.Result != types.TypeEmpty,
+	}
+
+	for i, block := range f.Blocks {
+		if i > 0 {
+			l.emit(instruction{op: opLabel, target: label(f, block)})
+		}
+		for _, v := range block.Values {
+			l.lowerValue(v)
+		}
+
+		switch block.Kind {
+		case ir.BlockPlain:
+			l.phiMoves(block, block.Succs[0])
+			// blocks that follow each other need no jump
+			if i+1 >= len(f.Blocks) || f.Blocks[i+1] != block.Succs[0] {
+				l.emit(instruction{op: opJump, target: label(f, block.Succs[0]), line: block.Line})
+			}
+		case ir.BlockIf:
+			// the moves into the phis of each successor happen on its own edge
+			otherwise := label(f, block) + ".false"
+			l.emit(instruction{op: opJumpIfFalse, args: []operand{operandOf(block.Control)}, target: otherwise})
+			l.phiMoves(block, block.Succs[0])
+			l.emit(instruction{op: opJump, target: label(f, block.Succs[0])})
+			l.emit(instruction{op: opLabel, target: otherwise})
+			l.phiMoves(block, block.Succs[1])
+			l.emit(instruction{op: opJump, target: label(f, block.Succs[1])})
+		case ir.BlockReturn:
+			result := imm(0)
+			if block.Control != nil {
+				result = operandOf(block.Control)
+			}
+			l.emit(instruction{op: opReturn, args: []operand{result}})
+		case ir.BlockFail:
+			l.emit(instruction{op: opFail, index: l.message(block.Message), line: block.Line})
+		}
+	}
+	return l.function, nil
+}
+
+// phiMoves moves the values flowing from block into the phis of succ. Without
+// loops no phi reads another phi of the same block, so the moves can be sequential.
+func (l *lowerer) phiMoves(block, succ *ir.Block) {
+	index := 0
+	for i, pred := range succ.Preds {
+		if pred == block {
+			index = i
+		}
+	}
+	for _, v := range succ.Values {
+		if v.Op == ir.OpPhi {
+			l.emit(instruction{op: opMove, dst: v.ID, args: []operand{operandOf(v.Args[index])}})
+		}
+	}
+}
+
+func (l *lowerer) lowerValue(v *ir.Value) {
+	args := make([]operand, len(v.Args))
+	for i, arg := range v.Args {
+		args[i] = operandOf(arg)
+	}
+
+	switch {
+	case v.Op == ir.OpConst, v.Op == ir.OpPhi:
+		// constants are immediates, phis are written by their predecessors
+	case v.Op == ir.OpParam:
+		l.emit(instruction{op: opParameter, dst: v.ID, index: v.Index})
+	case v.Op == ir.OpCopy:
+		l.emit(instruction{op: opMove, dst: v.ID, args: args})
+	case v.Op == ir.OpCall:
+		l.emit(instruction{op: opCall, dst: v.ID, args: args, target: v.Callee})
+	case v.Op.IsCompare():
+		l.emit(instruction{op: opCompare, dst: v.ID, args: args, condition: conditions[v.Op]})
+	case v.Op.IsArithmetic():
+		i := instruction{op: arithmetic[v.Op], dst: v.ID, args: args, bits: bits(v.Args[0].Type), line: v.Line}
+		if v.Op == ir.OpDiv {
+			i.index = l.message("division by zero")
+		}
+		l.emit(i)
+	}
+}
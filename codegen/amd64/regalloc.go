@@ -0,0 +1,119 @@
+package amd64
+
+import (
+	"fmt"
+	"sort"
+)
+
+// allocatable registers are callee saved, so values survive calls without
+// saving them around every call
+var allocatable = []string{"%rbx", "%r12", "%r13", "%r14", "%r15"}
+
+// allocation maps every virtual register to a machine register or a stack slot
+type allocation struct {
+	locations map[int]string
+	// callee saved registers the function uses, in the order they are pushed
+	saved  []string
+	spills int
+}
+
+type interval struct {
+	register   int
+	start, end int
+}
+
+// intervals returns the live interval of every virtual register. Lowered code
+// only jumps forward, so a value is live from its first to its last mention.
+func intervals(f *function) []interval {
+	byRegister := make(map[int]*interval)
+	mention := func(register, position int) {
+		if current, ok := byRegister[register]; ok {
+			current.end = position
+			return
+		}
+		byRegister[register] = &interval{register: register, start: position, end: position}
+	}
+	for position, i := range f.instructions {
+		for _, arg := range i.args {
+			if !arg.immediate {
+				mention(arg.register, position)
+			}
+		}
+		if i.defines() {
+			mention(i.dst, position)
+		}
+	}
+
+	result := make([]interval, 0, len(byRegister))
+	for _, current := range byRegister {
+		result = append(result, *current)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].start != result[j].start {
+			return result[i].start < result[j].start
+		}
+		return result[i].register < result[j].register
+	})
+	return result
+}
+
+// allocate assigns locations with linear scan. When every register is taken the
+// interval that ends last is spilled to the stack.
+func allocate(f *function) allocation {
+	a := allocation{locations: make(map[int]string)}
+	free := append([]string{}, allocatable...)
+	active := []interval{}
+	used := make(map[string]bool)
+	spills := []interval{}
+
+	for _, current := range intervals(f) {
+		// expire intervals that ended before this one starts
+		remaining := active[:0]
+		for _, other := range active {
+			if other.end < current.start {
+				free = append(free, a.locations[other.register])
+				continue
+			}
+			remaining = append(remaining, other)
+		}
+		active = remaining
+
+		if len(free) > 0 {
+			a.locations[current.register] = free[0]
+			used[free[0]] = true
+			free = free[1:]
+			active = append(active, current)
+			continue
+		}
+
+		// spill whichever of the active intervals and current lives longest
+		longest := -1
+		for i, other := range active {
+			if longest < 0 || other.end > active[longest].end {
+				longest = i
+			}
+		}
+		if active[longest].end > current.end {
+			spilled := active[longest]
+			a.locations[current.register] = a.locations[spilled.register]
+			delete(a.locations, spilled.register)
+			spills = append(spills, spilled)
+			active[longest] = current
+		} else {
+			spills = append(spills, current)
+		}
+	}
+
+	for _, register := range allocatable {
+		if used[register] {
+			a.saved = append(a.saved, register)
+		}
+	}
+	// spill slots sit below the saved registers
+	base := 8 * len(a.saved)
+	for i, spilled := range spills {
+		a.locations[spilled.register] = fmt.Sprintf("-%d(%%rbp)", base+8*(i+1))
+	}
+	a.spills = len(spills)
+	return a
+}
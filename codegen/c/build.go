@@ -0,0 +1,42 @@
+package c
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"shake/ir"
+)
+
+// Build generates C for the module and compiles it to a native executable at
+// output with the system C compiler, which is $CC or cc
+func Build(module *ir.Module, output string) error {
+	source, err := Generate(module)
+	if err != nil {
+		return err
+	}
+
+	directory, err := os.MkdirTemp("", "shake")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(directory)
+	file := filepath.Join(directory, "main.c")
+	err = os.WriteFile(file, []byte(source), 0o644)
+	if err != nil {
+		return err
+	}
+
+	compiler := os.Getenv("CC")
+	if compiler == "" {
+		compiler = "cc"
+	}
+	command := exec.Command(compiler, "-std=c99", "-O2", "-o", output, file)
+	command.Stdout = os.Stderr
+	command.Stderr = os.Stderr
+	err = command.Run()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", compiler, err)
+	}
+	return nil
+}
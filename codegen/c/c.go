@@ -0,0 +1,295 @@
+package c
+
+import (
+	"fmt"
+	"math"
+	"shake/ir"
+	"shake/trace"
+	"shake/types"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// prelude defines the runtime helpers every generated program uses. Integer
+// arithmetic wraps like the VM instead of overflowing into undefined behaviour.
+const prelude = `#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+
+static void shk_fail(const char *reason, int line) {
+	fprintf(stderr, "[Runtime Error]: %s at line: %d\n", reason, line);
+	exit(3);
+}
+
+#define SHK_ARITHMETIC(bits) \
+	static int##bits##_t shk_add##bits(int##bits##_t a, int##bits##_t b) { return (int##bits##_t)((uint##bits##_t)a + (uint##bits##_t)b); } \
+	static int##bits##_t shk_sub##bits(int##bits##_t a, int##bits##_t b) { return (int##bits##_t)((uint##bits##_t)a - (uint##bits##_t)b); } \
+	static int##bits##_t shk_mul##bits(int##bits##_t a, int##bits##_t b) { return (int##bits##_t)((uint##bits##_t)a * (uint##bits##_t)b); } \
+	static int##bits##_t shk_div##bits(int##bits##_t a, int##bits##_t b, int line) { \
+		if (b == 0) shk_fail("division by zero", line); \
+		if (b == -1) return (int##bits##_t)(0 - (uint##bits##_t)a); \
+		return a / b; \
+	}
+SHK_ARITHMETIC(32)
+SHK_ARITHMETIC(64)
+`
+
+type generator struct {
+	module *ir.Module
+	out    strings.Builder
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[C Error]"), reason, line)
+}
+
+// Generate translates a module to a C99 translation unit. Every SSA value
+// becomes a local variable and every block a label.
+func Generate(module *ir.Module) (string, error) {
+	g := &generator{module: module}
+	g.out.WriteString(prelude)
+
+	// declare every function first so they can call each other
+	g.out.WriteString("\n")
+	for _, f := range module.Functions {
+		signature, err := signature(f)
+		if err != nil {
+			return "", err
+		}
+		g.out.WriteString(signature + ";\n")
+	}
+
+	for _, f := range module.Functions {
+		err := g.generateFunction(f)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := g.entry()
+	if err != nil {
+		return "", err
+	}
+	return g.out.String(), nil
+}
+
+// cType returns the C type a shake type is stored in
+func cType(t types.Type, line uint64) (string, error) {
+	switch t {
+	case types.TypeInt32, types.TypeBool, types.TypeError:
+		return "int32_t", nil
+	case types.TypeInt64:
+		return "int64_t", nil
+	case types.TypeEmpty:
+		return "void", nil
+	}
+	return "", Error(fmt.Sprintf("Type: %s is not supported by the C backend", t), line)
+}
+
+// name prefixes identifiers so they never clash with C keywords or the C main
+func name(identifier string) string {
+	return "shk_" + identifier
+}
+
+func signature(f *ir.Function) (string, error) {
+	returnType, err := cType(f.Result, f.Line)
+	if err != nil {
+		return "", err
+	}
+	parameters := []string{}
+	for i, parameter := range f.Params {
+		parameterType, err := cType(parameter, f.Line)
+		if err != nil {
+			return "", err
+		}
+		parameters = append(parameters, fmt.Sprintf("%s p%d", parameterType, i))
+	}
+	if len(parameters) == 0 {
+		parameters = append(parameters, "void")
+	}
+	return fmt.Sprintf("static %s %s(%s)", returnType, name(f.Name), strings.Join(parameters, ", ")), nil
+}
+
+func (g *generator) writeLine(format string, arguments ...any) {
+	g.out.WriteString("\t")
+	fmt.Fprintf(&g.out, format, arguments...)
+	g.out.WriteString("\n")
+}
+
+// operand returns the C expression of a value. Constants are literals and
+// parameters are read where they are used.
+func operand(v *ir.Value) string {
+	switch v.Op {
+	case ir.OpConst:
+		switch v.Type {
+		case types.TypeInt64:
+			if v.Const == math.MinInt64 {
+				return "INT64_MIN"
+			}
+			return fmt.Sprintf("INT64_C(%d)", v.Const)
+		case types.TypeInt32:
+			if v.Const == math.MinInt32 {
+				return "INT32_MIN"
+			}
+			return fmt.Sprintf("(int32_t)%d", v.Const)
+		}
+		return fmt.Sprint(v.Const)
+	case ir.OpParam:
+		return fmt.Sprintf("p%d", v.Index)
+	}
+	return v.String()
+}
+
+// defined reports whether a value is stored in a variable of its own
+func defined(v *ir.Value) bool {
+	return v.Op != ir.OpConst && v.Op != ir.OpParam && v.Type != types.TypeEmpty
+}
+
+func (g *generator) generateFunction(f *ir.Function) error {
+	signature, err := signature(f)
+	if err != nil {
+		return err
+	}
+	g.out.WriteString("\n" + signature + " {\n")
+
+	// values are declared up front so the gotos never jump past a declaration
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			if !defined(v) {
+				continue
+			}
+			valueType, err := cType(v.Type, v.Line)
+			if err != nil {
+				return err
+			}
+			g.writeLine("%s %s;", valueType, v)
+		}
+	}
+
+	for i, block := range f.Blocks {
+		if i > 0 {
+			fmt.Fprintf(&g.out, "%s:;\n", block)
+		}
+		for _, v := range block.Values {
+			g.value(v)
+		}
+
+		switch block.Kind {
+		case ir.BlockPlain:
+			g.phiCopies(block, block.Succs[0], "")
+			// blocks that follow each other need no goto
+			if i+1 >= len(f.Blocks) || f.Blocks[i+1] != block.Succs[0] {
+				g.writeLine("goto %s;", block.Succs[0])
+			}
+		case ir.BlockIf:
+			// the copies into the phis of each successor happen on its own edge
+			g.writeLine("if (%s) {", operand(block.Control))
+			g.phiCopies(block, block.Succs[0], "\t")
+			g.writeLine("\tgoto %s;", block.Succs[0])
+			g.writeLine("}")
+			g.phiCopies(block, block.Succs[1], "")
+			if i+1 >= len(f.Blocks) || f.Blocks[i+1] != block.Succs[1] {
+				g.writeLine("goto %s;", block.Succs[1])
+			}
+		case ir.BlockReturn:
+			if block.Control == nil {
+				g.writeLine("return;")
+			} else {
+				g.writeLine("return %s;", operand(block.Control))
+			}
+		case ir.BlockFail:
+			g.writeLine("shk_fail(%s, %d);", strconv.Quote(block.Message), block.Line)
+		}
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
+
+// phiCopies assigns the values flowing from block to the phis of succ. Without
+// loops no phi reads another phi of the same block, so the copies can be sequential.
+func (g *generator) phiCopies(block, succ *ir.Block, indent string) {
+	index := 0
+	for i, pred := range succ.Preds {
+		if pred == block {
+			index = i
+		}
+	}
+	for _, v := range succ.Values {
+		if v.Op == ir.OpPhi && defined(v) {
+			g.writeLine("%s%s = %s;", indent, v, operand(v.Args[index]))
+		}
+	}
+}
+
+var arithmetic = map[ir.Op]string{
+	ir.OpAdd: "add",
+	ir.OpSub: "sub",
+	ir.OpMul: "mul",
+	ir.OpDiv: "div",
+}
+
+var comparisons = map[ir.Op]string{
+	ir.OpEq: "==",
+	ir.OpNe: "!=",
+	ir.OpLt: "<",
+	ir.OpLe: "<=",
+	ir.OpGt: ">",
+	ir.OpGe: ">=",
+}
+
+func (g *generator) value(v *ir.Value) {
+	args := make([]string, len(v.Args))
+	for i, arg := range v.Args {
+		args[i] = operand(arg)
+	}
+
+	switch {
+	case v.Op == ir.OpCall:
+		call := fmt.Sprintf("%s(%s)", name(v.Callee), strings.Join(args, ", "))
+		if v.Type == types.TypeEmpty {
+			g.writeLine("%s;", call)
+			return
+		}
+		g.writeLine("%s = %s;", v, call)
+	case !defined(v), v.Op == ir.OpPhi:
+		// phis are assigned by their predecessors
+	case v.Op == ir.OpCopy:
+		g.writeLine("%s = %s;", v, args[0])
+	case v.Op.IsCompare():
+		g.writeLine("%s = (%s %s %s);", v, args[0], comparisons[v.Op], args[1])
+	case v.Op.IsArithmetic():
+		bits := 32
+		if v.Args[0].Type == types.TypeInt64 {
+			bits = 64
+		}
+		if v.Op == ir.OpDiv {
+			g.writeLine("%s = shk_div%d(%s, %s, %d);", v, bits, args[0], args[1], v.Line)
+			return
+		}
+		g.writeLine("%s = shk_%s%d(%s, %s);", v, arithmetic[v.Op], bits, args[0], args[1])
+	}
+}
+
+// entry generates the C main, which calls the entry function of the module
+func (g *generator) entry() error {
+	entry := g.module.Function(g.module.Entry)
+	if entry == nil {
+		return Error("Program has no entry function, mark one with (entry) or call it main", 0)
+	}
+	if len(entry.Params) > 0 {
+		return Error(fmt.Sprintf("Entry function: %s cannot take parameters", entry.Name), entry.Line)
+	}
+
+	g.out.WriteString("\nint main(void) {\n")
+	if entry.Result == types.TypeEmpty {
+		fmt.Fprintf(&g.out, "\t%s();\n\treturn 0;\n", name(entry.Name))
+	} else {
+		fmt.Fprintf(&g.out, "\treturn (int)%s();\n", name(entry.Name))
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
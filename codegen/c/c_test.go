@@ -0,0 +1,52 @@
+package c_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"shake/codegen/c"
+	"shake/codegen/testdata/fixture"
+	"testing"
+)
+
+// TestBuild builds every program of the codegen corpus at every optimization
+// level with the system C compiler and checks the executable exits with the
+// result of the VM
+func TestBuild(t *testing.T) {
+	compiler := os.Getenv("CC")
+	if compiler == "" {
+		compiler = "cc"
+	}
+	if _, err := exec.LookPath(compiler); err != nil {
+		t.Skipf("no C compiler: %v", err)
+	}
+
+	for _, path := range fixture.Corpus(t) {
+		for level := range 3 {
+			t.Run(fmt.Sprintf("%s/O%d", filepath.Base(path), level), func(t *testing.T) {
+				program := fixture.ParseFile(t, path)
+				want := fixture.Result(t, program)
+
+				executable := filepath.Join(t.TempDir(), "program")
+				err := c.Build(fixture.Module(t, program, level), executable)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = exec.Command(executable).Run()
+				code := 0
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					code = exit.ExitCode()
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				// the exit status keeps the low byte of the result
+				if code != int(uint8(want)) {
+					t.Errorf("executable exited with %d, the VM returned %d", code, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package golang
+
+import (
+	"fmt"
+	"go/format"
+	"go/token"
+	"shake/ir"
+	"shake/trace"
+	"shake/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// prelude defines the runtime helpers every generated package uses. Failures
+// that stop a program in the other backends are returned as a *RuntimeError.
+const prelude = `
+// RuntimeError is returned when the program fails while running
+type RuntimeError struct {
+	Reason     string
+	Function   string
+	LineNumber int
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s in function: %s at line: %d", e.Reason, e.Function, e.LineNumber)
+}
+
+func divide[T int32 | int64](a, b T, function string, line int) (T, error) {
+	if b == 0 {
+		return 0, &RuntimeError{"division by zero", function, line}
+	}
+	return a / b, nil
+}
+`
+
+type generator struct {
+	out *strings.Builder
+	// exported names of the functions, by their shake name
+	functions map[string]string
+	// the function being generated
+	function *ir.Function
+	// values that are read, only they are stored in a variable as Go does not
+	// allow unread variables
+	live map[*ir.Value]bool
+	// blocks a goto jumps to, Go does not allow unused labels either
+	targets map[*ir.Block]bool
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[Go Error]"), reason, line)
+}
+
+// Generate translates a module to the source of a Go package. Every function
+// is exported and returns an error as its last result, which is set when the
+// program fails. Functions returning a shake error return only that error.
+// From -O1 on dead code elimination drops the functions the entry never calls.
+func Generate(module *ir.Module, packageName string) (string, error) {
+	if !token.IsIdentifier(packageName) {
+		return "", Error(fmt.Sprintf("Invalid package name: %s", packageName), 0)
+	}
+	g := &generator{out: &strings.Builder{}, functions: map[string]string{}}
+
+	exported := map[string]string{"RuntimeError": "the runtime error type"}
+	for _, f := range module.Functions {
+		name, err := export(f)
+		if err != nil {
+			return "", err
+		}
+		if other, ok := exported[name]; ok {
+			return "", Error(fmt.Sprintf("Function: %s is exported as %s, which clashes with %s", f.Name, name, other), f.Line)
+		}
+		exported[name] = "function: " + f.Name
+		g.functions[f.Name] = name
+	}
+
+	fmt.Fprintf(g.out, "// Code generated by shake. DO NOT EDIT.\n\npackage %s\n\nimport \"fmt\"\n", packageName)
+	g.out.WriteString(prelude)
+	for _, f := range module.Functions {
+		err := g.generateFunction(f)
+		if err != nil {
+			return "", err
+		}
+	}
+	source, err := format.Source([]byte(g.out.String()))
+	if err != nil {
+		return "", Error(fmt.Sprintf("Generated invalid Go: %s", err), 0)
+	}
+	return string(source), nil
+}
+
+// export returns the exported Go name of a function
+func export(f *ir.Function) (string, error) {
+	first, size := utf8.DecodeRuneInString(f.Name)
+	if !unicode.IsLetter(first) {
+		return "", Error(fmt.Sprintf("Function: %s cannot be exported, its name must start with a letter", f.Name), f.Line)
+	}
+	return string(unicode.ToUpper(first)) + f.Name[size:], nil
+}
+
+// goType returns the Go type a shake type is stored in
+func goType(t types.Type, line uint64) (string, error) {
+	switch t {
+	case types.TypeInt32:
+		return "int32", nil
+	case types.TypeInt64:
+		return "int64", nil
+	case types.TypeBool:
+		return "bool", nil
+	case types.TypeError:
+		return "error", nil
+	}
+	return "", Error(fmt.Sprintf("Type: %s is not supported by the Go backend", t), line)
+}
+
+// results returns the result list of a function returning t
+func results(t types.Type, line uint64) (string, error) {
+	if t == types.TypeEmpty || t == types.TypeError {
+		return "error", nil
+	}
+	resultType, err := goType(t, line)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s, error)", resultType), nil
+}
+
+// zero returns the zero value of a type
+func zero(t types.Type) string {
+	switch t {
+	case types.TypeBool:
+		return "false"
+	case types.TypeError:
+		return "nil"
+	}
+	return "0"
+}
+
+// operand returns the Go expression of a value. Constants are converted to
+// their type so arithmetic on them wraps while running.
+func operand(v *ir.Value) string {
+	switch v.Op {
+	case ir.OpConst:
+		switch v.Type {
+		case types.TypeBool:
+			return fmt.Sprint(v.Const != 0)
+		case types.TypeError:
+			return "nil"
+		case types.TypeInt64:
+			return fmt.Sprintf("int64(%d)", v.Const)
+		}
+		return fmt.Sprintf("int32(%d)", v.Const)
+	case ir.OpParam:
+		return fmt.Sprintf("p%d", v.Index)
+	}
+	return v.String()
+}
+
+// effect reports whether a value has to be computed even when nothing reads it
+func effect(v *ir.Value) bool {
+	return v.Op == ir.OpCall || v.Op == ir.OpDiv
+}
+
+// stored reports whether a value is kept in a variable of its own
+func (g *generator) stored(v *ir.Value) bool {
+	return g.live[v] && v.Op != ir.OpConst && v.Op != ir.OpParam && v.Type != types.TypeEmpty
+}
+
+func (g *generator) writeLine(format string, arguments ...any) {
+	g.out.WriteString("\t")
+	fmt.Fprintf(g.out, format, arguments...)
+	g.out.WriteString("\n")
+}
+
+// returnError returns err from the function
+func (g *generator) returnError(err string) {
+	if g.function.Result == types.TypeEmpty || g.function.Result == types.TypeError {
+		g.writeLine("return %s", err)
+		return
+	}
+	g.writeLine("return %s, %s", zero(g.function.Result), err)
+}
+
+// check returns the error of the last call when it failed
+func (g *generator) check() {
+	g.writeLine("if err != nil {")
+	g.returnError("err")
+	g.writeLine("}")
+}
+
+// analyze finds the live values and the blocks that need a label
+func (g *generator) analyze(f *ir.Function) {
+	g.live = map[*ir.Value]bool{}
+	work := []*ir.Value{}
+	for i, block := range f.Blocks {
+		for _, v := range block.Values {
+			if effect(v) {
+				work = append(work, v.Args...)
+			}
+		}
+		if block.Control != nil {
+			work = append(work, block.Control)
+		}
+
+		// blocks that follow each other need no goto, except for the true edge of an if
+		for j, succ := range block.Succs {
+			if (block.Kind == ir.BlockIf && j == 0) || i+1 >= len(f.Blocks) || f.Blocks[i+1] != succ {
+				g.targets[succ] = true
+			}
+		}
+	}
+	for len(work) > 0 {
+		v := work[len(work)-1]
+		work = work[:len(work)-1]
+		if g.live[v] {
+			continue
+		}
+		g.live[v] = true
+		work = append(work, v.Args...)
+	}
+}
+
+func (g *generator) generateFunction(f *ir.Function) error {
+	result, err := results(f.Result, f.Line)
+	if err != nil {
+		return err
+	}
+	parameters := []string{}
+	for i, parameter := range f.Params {
+		parameterType, err := goType(parameter, f.Line)
+		if err != nil {
+			return err
+		}
+		parameters = append(parameters, fmt.Sprintf("p%d %s", i, parameterType))
+	}
+	g.function = f
+	g.targets = map[*ir.Block]bool{}
+	g.analyze(f)
+
+	fmt.Fprintf(g.out, "\nfunc %s(%s) %s {\n", g.functions[f.Name], strings.Join(parameters, ", "), result)
+	// variables are declared up front so the gotos never jump over a declaration
+	fails := false
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			fails = fails || (effect(v) && v.Type != types.TypeError)
+			if !g.stored(v) {
+				continue
+			}
+			variableType, err := goType(v.Type, v.Line)
+			if err != nil {
+				return err
+			}
+			g.writeLine("var %s %s", v, variableType)
+		}
+	}
+	if fails {
+		g.writeLine("var err error")
+	}
+
+	for _, block := range f.Blocks {
+		if g.targets[block] {
+			fmt.Fprintf(g.out, "%s:\n", block)
+		}
+		for _, v := range block.Values {
+			g.value(v)
+		}
+
+		switch block.Kind {
+		case ir.BlockPlain:
+			g.phiCopies(block, block.Succs[0])
+			if g.targets[block.Succs[0]] {
+				g.writeLine("goto %s", block.Succs[0])
+			}
+		case ir.BlockIf:
+			// the copies into the phis of each successor happen on its own edge
+			g.writeLine("if %s {", operand(block.Control))
+			g.phiCopies(block, block.Succs[0])
+			g.writeLine("goto %s", block.Succs[0])
+			g.writeLine("}")
+			g.phiCopies(block, block.Succs[1])
+			if g.targets[block.Succs[1]] {
+				g.writeLine("goto %s", block.Succs[1])
+			}
+		case ir.BlockReturn:
+			switch {
+			case f.Result == types.TypeEmpty:
+				g.writeLine("return nil")
+			case f.Result == types.TypeError:
+				g.writeLine("return %s", operand(block.Control))
+			default:
+				g.writeLine("return %s, nil", operand(block.Control))
+			}
+		case ir.BlockFail:
+			g.returnError(fmt.Sprintf("&RuntimeError{%q, %q, %d}", block.Message, f.Name, block.Line))
+		}
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
+
+// phiCopies assigns the values flowing from block to the phis of succ. Without
+// loops no phi reads another phi of the same block, so the copies can be sequential.
+func (g *generator) phiCopies(block, succ *ir.Block) {
+	index := 0
+	for i, pred := range succ.Preds {
+		if pred == block {
+			index = i
+		}
+	}
+	for _, v := range succ.Values {
+		if v.Op == ir.OpPhi && g.stored(v) {
+			g.writeLine("%s = %s", v, operand(v.Args[index]))
+		}
+	}
+}
+
+var operators = map[ir.Op]string{
+	ir.OpAdd: "+",
+	ir.OpSub: "-",
+	ir.OpMul: "*",
+	ir.OpEq:  "==",
+	ir.OpNe:  "!=",
+	ir.OpLt:  "<",
+	ir.OpLe:  "<=",
+	ir.OpGt:  ">",
+	ir.OpGe:  ">=",
+}
+
+func (g *generator) value(v *ir.Value) {
+	args := make([]string, len(v.Args))
+	for i, arg := range v.Args {
+		args[i] = operand(arg)
+	}
+	// values nothing reads are only computed for their effect
+	target := "_"
+	if g.stored(v) {
+		target = v.String()
+	}
+
+	switch {
+	case v.Op == ir.OpCall:
+		called := fmt.Sprintf("%s(%s)", g.functions[v.Callee], strings.Join(args, ", "))
+		switch v.Type {
+		case types.TypeEmpty:
+			g.writeLine("err = %s", called)
+		case types.TypeError:
+			// the error is the value, a failure of the callee is indistinguishable from it
+			g.writeLine("%s = %s", target, called)
+			return
+		default:
+			g.writeLine("%s, err = %s", target, called)
+		}
+		g.check()
+	case v.Op == ir.OpDiv:
+		// integer arithmetic in Go wraps like the VM, only division can fail
+		operandType, _ := goType(v.Type, v.Line)
+		g.writeLine("%s, err = divide[%s](%s, %s, %q, %d)", target, operandType, args[0], args[1], g.function.Name, v.Line)
+		g.check()
+	case !g.stored(v), v.Op == ir.OpPhi:
+		// phis are assigned by their predecessors
+	case v.Op == ir.OpCopy:
+		g.writeLine("%s = %s", v, args[0])
+	default:
+		g.writeLine("%s = %s %s %s", v, args[0], operators[v.Op], args[1])
+	}
+}
@@ -0,0 +1,118 @@
+package golang_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"shake/codegen/golang"
+	"shake/codegen/testdata/fixture"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// command runs the main package that calls the generated one
+const command = `package main
+
+import (
+	"fmt"
+	"os"
+
+	"example/program"
+)
+
+func main() {
+	result, err := program.ENTRY()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(255)
+	}
+	os.Exit(int(uint8(result)))
+}
+`
+
+// TestBuild builds every program of the codegen corpus at every optimization
+// level with go build and checks the executable exits with the result of the VM
+func TestBuild(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skipf("no go command: %v", err)
+	}
+
+	for _, path := range fixture.Corpus(t) {
+		for level := range 3 {
+			t.Run(fmt.Sprintf("%s/O%d", filepath.Base(path), level), func(t *testing.T) {
+				program := fixture.ParseFile(t, path)
+				want := fixture.Result(t, program)
+
+				module := fixture.Module(t, program, level)
+				source, err := golang.Generate(module, "program")
+				if err != nil {
+					t.Fatal(err)
+				}
+				entry := string(unicode.ToUpper(rune(module.Entry[0]))) + module.Entry[1:]
+
+				directory := t.TempDir()
+				files := map[string]string{
+					"go.mod":             "module example\n\ngo 1.23\n",
+					"main.go":            strings.Replace(command, "ENTRY", entry, 1),
+					"program/program.go": source,
+				}
+				for name, content := range files {
+					name = filepath.Join(directory, name)
+					err = os.MkdirAll(filepath.Dir(name), 0o755)
+					if err == nil {
+						err = os.WriteFile(name, []byte(content), 0o644)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				executable := filepath.Join(directory, "program.exe")
+				build := exec.Command(goTool, "build", "-o", executable, ".")
+				build.Dir = directory
+				output, err := build.CombinedOutput()
+				if err != nil {
+					t.Fatalf("go build failed: %v\n%s", err, output)
+				}
+				err = exec.Command(executable).Run()
+				code := 0
+				var exit *exec.ExitError
+				if errors.As(err, &exit) {
+					code = exit.ExitCode()
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				// the exit status keeps the low byte of the result
+				if code != int(uint8(want)) {
+					t.Errorf("executable exited with %d, the VM returned %d", code, want)
+				}
+			})
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		packageName string
+		want        string
+	}{
+		{"clash", "fn value(): int32 { return 1; }\nfn Value(): int32 { return 2; }", "program", "Function: Value is exported as Value, which clashes with function: value"},
+		{"runtime error", "fn runtimeError(): int32 { return 1; }", "program", "Function: runtimeError is exported as RuntimeError, which clashes with the runtime error type"},
+		{"export", "fn _hidden(): int32 { return 1; }", "program", "Function: _hidden cannot be exported, its name must start with a letter"},
+		{"package", "fn main(): int32 { return 1; }", "1program", "Invalid package name: 1program"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := golang.Generate(fixture.Module(t, fixture.ParseString(t, test.source), 0), test.packageName)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package llvm
+
+import (
+	"fmt"
+	"shake/ir"
+	"shake/trace"
+	"shake/types"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// prelude declares the runtime helpers every module uses. Integer arithmetic
+// wraps like the VM, and division checks for zero before dividing.
+const prelude = `@.division = private unnamed_addr constant [47 x i8] c"[Runtime Error]: division by zero at line: %d\0A\00"
+
+declare i32 @dprintf(i32, i8*, ...)
+declare void @exit(i32)
+
+define private void @shk.fail(i8* %format, i32 %line) noreturn {
+  call i32 (i32, i8*, ...) @dprintf(i32 2, i8* %format, i32 %line)
+  call void @exit(i32 3)
+  unreachable
+}
+`
+
+const division = `
+define private %[1]s @shk.div%[2]d(%[1]s %%a, %[1]s %%b, i32 %%line) {
+  %%zero = icmp eq %[1]s %%b, 0
+  br i1 %%zero, label %%fail, label %%check
+fail:
+  call void @shk.fail(i8* getelementptr inbounds ([47 x i8], [47 x i8]* @.division, i64 0, i64 0), i32 %%line)
+  unreachable
+check:
+  %%negate = icmp eq %[1]s %%b, -1
+  br i1 %%negate, label %%negative, label %%divide
+negative:
+  %%negated = sub %[1]s 0, %%a
+  ret %[1]s %%negated
+divide:
+  %%result = sdiv %[1]s %%a, %%b
+  ret %[1]s %%result
+}
+`
+
+type generator struct {
+	module *ir.Module
+	out    strings.Builder
+	// the texts runtime failures print, which become constants after the functions
+	messages []string
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[LLVM Error]"), reason, line)
+}
+
+// Generate translates a module to an LLVM IR module in textual form. SSA
+// values map to registers and blocks to basic blocks, phis included.
+func Generate(module *ir.Module) (string, error) {
+	g := &generator{module: module}
+	g.out.WriteString(prelude)
+	fmt.Fprintf(&g.out, division, "i32", 32)
+	fmt.Fprintf(&g.out, division, "i64", 64)
+
+	for _, f := range module.Functions {
+		err := g.generateFunction(f)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err := g.entry()
+	if err != nil {
+		return "", err
+	}
+	if len(g.messages) > 0 {
+		g.out.WriteString("\n")
+	}
+	for i, text := range g.messages {
+		fmt.Fprintf(&g.out, "@.fail%d = private unnamed_addr constant [%d x i8] c\"%s\"\n", i, len(text), escape(text))
+	}
+	return g.out.String(), nil
+}
+
+// escape writes bytes LLVM strings cannot hold as \XX
+func escape(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// llvmType returns the LLVM type a shake type is stored in
+func llvmType(t types.Type, line uint64) (string, error) {
+	switch t {
+	case types.TypeInt32, types.TypeError:
+		return "i32", nil
+	case types.TypeInt64:
+		return "i64", nil
+	case types.TypeBool:
+		return "i1", nil
+	case types.TypeEmpty:
+		return "void", nil
+	}
+	return "", Error(fmt.Sprintf("Type: %s is not supported by the LLVM backend", t), line)
+}
+
+// name prefixes functions so they never clash with the C main or libc
+func name(identifier string) string {
+	return "@shk." + identifier
+}
+
+// operand returns the LLVM operand of a value. Constants are immediates,
+// parameters are arguments and copies are the value they copy.
+func operand(v *ir.Value) string {
+	switch v.Op {
+	case ir.OpConst:
+		if v.Type == types.TypeBool {
+			return fmt.Sprint(v.Const != 0)
+		}
+		return fmt.Sprint(v.Const)
+	case ir.OpParam:
+		return fmt.Sprintf("%%p%d", v.Index)
+	case ir.OpCopy:
+		return operand(v.Args[0])
+	}
+	return "%" + v.String()
+}
+
+// typed returns the operand of a value preceded by its type
+func typed(v *ir.Value) (string, error) {
+	t, err := llvmType(v.Type, v.Line)
+	if err != nil {
+		return "", err
+	}
+	return t + " " + operand(v), nil
+}
+
+func (g *generator) instruction(format string, arguments ...any) {
+	g.out.WriteString("  ")
+	fmt.Fprintf(&g.out, format, arguments...)
+	g.out.WriteString("\n")
+}
+
+func (g *generator) generateFunction(f *ir.Function) error {
+	returnType, err := llvmType(f.Result, f.Line)
+	if err != nil {
+		return err
+	}
+	parameters := []string{}
+	for i, parameter := range f.Params {
+		parameterType, err := llvmType(parameter, f.Line)
+		if err != nil {
+			return err
+		}
+		parameters = append(parameters, fmt.Sprintf("%s %%p%d", parameterType, i))
+	}
+
+	fmt.Fprintf(&g.out, "\ndefine %s %s(%s) {\n", returnType, name(f.Name), strings.Join(parameters, ", "))
+	for _, block := range f.Blocks {
+		fmt.Fprintf(&g.out, "%s:\n", block)
+		for _, v := range block.Values {
+			err = g.value(v)
+			if err != nil {
+				return err
+			}
+		}
+
+		switch block.Kind {
+		case ir.BlockPlain:
+			g.instruction("br label %%%s", block.Succs[0])
+		case ir.BlockIf:
+			g.instruction("br i1 %s, label %%%s, label %%%s", operand(block.Control), block.Succs[0], block.Succs[1])
+		case ir.BlockReturn:
+			if block.Control == nil {
+				g.instruction("ret void")
+				break
+			}
+			result, err := typed(block.Control)
+			if err != nil {
+				return err
+			}
+			g.instruction("ret %s", result)
+		case ir.BlockFail:
+			// the text is the format @shk.fail prints the line with
+			text := "[Runtime Error]: " + block.Message + " at line: %d\n\x00"
+			g.messages = append(g.messages, text)
+			g.instruction("call void @shk.fail(i8* getelementptr inbounds ([%[1]d x i8], [%[1]d x i8]* @.fail%[2]d, i64 0, i64 0), i32 %[3]d)", len(text), len(g.messages)-1, block.Line)
+			g.instruction("unreachable")
+		}
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
+
+var instructions = map[ir.Op]string{
+	ir.OpAdd: "add",
+	ir.OpSub: "sub",
+	ir.OpMul: "mul",
+	ir.OpEq:  "icmp eq",
+	ir.OpNe:  "icmp ne",
+	ir.OpLt:  "icmp slt",
+	ir.OpLe:  "icmp sle",
+	ir.OpGt:  "icmp sgt",
+	ir.OpGe:  "icmp sge",
+}
+
+func (g *generator) value(v *ir.Value) error {
+	args := make([]string, len(v.Args))
+	for i, arg := range v.Args {
+		typedArg, err := typed(arg)
+		if err != nil {
+			return err
+		}
+		args[i] = typedArg
+	}
+	t, err := llvmType(v.Type, v.Line)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Op == ir.OpCall:
+		if t == "void" {
+			g.instruction("call void %s(%s)", name(v.Callee), strings.Join(args, ", "))
+			return nil
+		}
+		g.instruction("%%%s = call %s %s(%s)", v, t, name(v.Callee), strings.Join(args, ", "))
+	case v.Op == ir.OpConst, v.Op == ir.OpParam, v.Op == ir.OpCopy, t == "void":
+		// operands of the values that use them
+	case v.Op == ir.OpPhi:
+		incoming := make([]string, len(v.Args))
+		for i, arg := range v.Args {
+			incoming[i] = fmt.Sprintf("[ %s, %%%s ]", operand(arg), v.Block.Preds[i])
+		}
+		g.instruction("%%%s = phi %s %s", v, t, strings.Join(incoming, ", "))
+	case v.Op == ir.OpDiv:
+		g.instruction("%%%s = call %s @shk.div%s(%s, %s, i32 %d)", v, t, t[1:], args[0], args[1], v.Line)
+	default:
+		g.instruction("%%%s = %s %s, %s", v, instructions[v.Op], args[0], operand(v.Args[1]))
+	}
+	return nil
+}
+
+// entry generates @main, which calls the entry function of the module
+func (g *generator) entry() error {
+	entry := g.module.Function(g.module.Entry)
+	if entry == nil {
+		return Error("Program has no entry function, mark one with (entry) or call it main", 0)
+	}
+	if len(entry.Params) > 0 {
+		return Error(fmt.Sprintf("Entry function: %s cannot take parameters", entry.Name), entry.Line)
+	}
+
+	g.out.WriteString("\ndefine i32 @main() {\n")
+	switch entry.Result {
+	case types.TypeEmpty:
+		fmt.Fprintf(&g.out, "  call void %s()\n  ret i32 0\n", name(entry.Name))
+	case types.TypeInt64:
+		fmt.Fprintf(&g.out, "  %%result = call i64 %s()\n  %%code = trunc i64 %%result to i32\n  ret i32 %%code\n", name(entry.Name))
+	default:
+		fmt.Fprintf(&g.out, "  %%result = call i32 %s()\n  ret i32 %%result\n", name(entry.Name))
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
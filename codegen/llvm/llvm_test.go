@@ -0,0 +1,92 @@
+package llvm_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"shake/codegen/llvm"
+	"shake/codegen/testdata/fixture"
+	"strings"
+	"testing"
+)
+
+// require skips the test when a tool is not installed
+func require(t *testing.T, tools ...string) {
+	t.Helper()
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed: %v", tool, err)
+		}
+	}
+}
+
+// exitCode runs the command and returns the status it exited with
+func exitCode(t *testing.T, command *exec.Cmd) int {
+	t.Helper()
+	err := command.Run()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+// corpus generates the .ll file of every program of the codegen corpus at
+// every optimization level and calls run with it and the result of the VM
+func corpus(t *testing.T, run func(t *testing.T, file string, want int64)) {
+	for _, path := range fixture.Corpus(t) {
+		for level := range 3 {
+			t.Run(fmt.Sprintf("%s/O%d", filepath.Base(path), level), func(t *testing.T) {
+				program := fixture.ParseFile(t, path)
+				want := fixture.Result(t, program)
+
+				source, err := llvm.Generate(fixture.Module(t, program, level))
+				if err != nil {
+					t.Fatal(err)
+				}
+				file := filepath.Join(t.TempDir(), "program.ll")
+				err = os.WriteFile(file, []byte(source), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				run(t, file, want)
+			})
+		}
+	}
+}
+
+// TestInterpret runs the generated IR with lli
+func TestInterpret(t *testing.T) {
+	require(t, "lli")
+	corpus(t, func(t *testing.T, file string, want int64) {
+		// the exit status keeps the low byte of the result
+		if code := exitCode(t, exec.Command("lli", file)); code != int(uint8(want)) {
+			t.Errorf("lli exited with %d, the VM returned %d", code, want)
+		}
+	})
+}
+
+// TestCompile compiles the generated IR with llc and links it with cc
+func TestCompile(t *testing.T) {
+	require(t, "llc", "cc")
+	corpus(t, func(t *testing.T, file string, want int64) {
+		object := strings.TrimSuffix(file, ".ll") + ".o"
+		output, err := exec.Command("llc", "-filetype=obj", "-relocation-model=pic", "-o", object, file).CombinedOutput()
+		if err != nil {
+			t.Fatalf("llc failed: %v\n%s", err, output)
+		}
+		executable := strings.TrimSuffix(file, ".ll")
+		output, err = exec.Command("cc", "-o", executable, object).CombinedOutput()
+		if err != nil {
+			t.Fatalf("cc failed: %v\n%s", err, output)
+		}
+		if code := exitCode(t, exec.Command(executable)); code != int(uint8(want)) {
+			t.Errorf("executable exited with %d, the VM returned %d", code, want)
+		}
+	})
+}
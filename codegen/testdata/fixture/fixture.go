@@ -0,0 +1,102 @@
+// Package fixture parses, compiles and runs the programs the tests of the
+// compiler share. It lives in testdata so only tests import it.
+package fixture
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"shake/bytecode"
+	"shake/ir"
+	"shake/lexer"
+	"shake/parser"
+	"shake/vm"
+	"strings"
+	"testing"
+)
+
+// Parse lexes and parses a program as the lexer reads it, and checks it
+func Parse(tb testing.TB, source io.Reader) *parser.NodeProgram {
+	tb.Helper()
+	l := lexer.NewLexer(source)
+	program, err := parser.NewParser(l.All()).ParseProgram()
+	// the parser sees the tokens end when the lexer fails
+	if l.Err() != nil {
+		err = l.Err()
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return program
+}
+
+// ParseFile parses the program of a file
+func ParseFile(tb testing.TB, path string) *parser.NodeProgram {
+	tb.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+	return Parse(tb, file)
+}
+
+// ParseString parses the program of a source written in the test
+func ParseString(tb testing.TB, source string) *parser.NodeProgram {
+	tb.Helper()
+	return Parse(tb, strings.NewReader(source))
+}
+
+// Compile compiles a program to bytecode
+func Compile(tb testing.TB, program *parser.NodeProgram) *bytecode.Program {
+	tb.Helper()
+	compiled, err := bytecode.Compile(program)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return compiled
+}
+
+// Module lowers a program to the IR and optimizes it at level, which is what
+// the native backends generate code from
+func Module(tb testing.TB, program *parser.NodeProgram, level int) *ir.Module {
+	tb.Helper()
+	module, err := ir.Build(program)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = ir.Optimize(module, level, "", io.Discard)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return module
+}
+
+// Result runs a program on the VM, which every backend is compared with
+func Result(tb testing.TB, program *parser.NodeProgram) int64 {
+	tb.Helper()
+	result, err := vm.New(Compile(tb, program)).Run()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return result
+}
+
+// Corpus returns the paths of the programs in codegen/testdata, which every
+// backend supports
+func Corpus(tb testing.TB) []string {
+	tb.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		tb.Fatal("the fixture package does not know where it is")
+	}
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(file), "..", "*.shk"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(paths) == 0 {
+		tb.Fatal("the corpus is empty")
+	}
+	return paths
+}
@@ -0,0 +1,96 @@
+package wasm
+
+// section ids
+const (
+	sectionType     byte = 1
+	sectionFunction byte = 3
+	sectionExport   byte = 7
+	sectionCode     byte = 10
+)
+
+// value types
+const (
+	typeI32 byte = 0x7f
+	typeI64 byte = 0x7e
+)
+
+const (
+	exportFunction byte = 0x00
+	functionType   byte = 0x60
+	// the block type of blocks that leave nothing on the stack
+	blockEmpty byte = 0x40
+)
+
+// opcodes
+const (
+	opUnreachable byte = 0x00
+	opBlock       byte = 0x02
+	opIf          byte = 0x04
+	opElse        byte = 0x05
+	opEnd         byte = 0x0b
+	opBr          byte = 0x0c
+	opReturn      byte = 0x0f
+	opCall        byte = 0x10
+	opDrop        byte = 0x1a
+	opLocalGet    byte = 0x20
+	opLocalSet    byte = 0x21
+	opLocalTee    byte = 0x22
+	opI32Const    byte = 0x41
+	opI64Const    byte = 0x42
+	opI32Eqz      byte = 0x45
+	opI32Eq       byte = 0x46
+	opI32Ne       byte = 0x47
+	opI32LtS      byte = 0x48
+	opI32GtS      byte = 0x4a
+	opI32LeS      byte = 0x4c
+	opI32GeS      byte = 0x4e
+	opI64Eq       byte = 0x51
+	opI64Ne       byte = 0x52
+	opI64LtS      byte = 0x53
+	opI64GtS      byte = 0x55
+	opI64LeS      byte = 0x57
+	opI64GeS      byte = 0x59
+	opI32Add      byte = 0x6a
+	opI32Sub      byte = 0x6b
+	opI32Mul      byte = 0x6c
+	opI32DivS     byte = 0x6d
+	opI64Add      byte = 0x7c
+	opI64Sub      byte = 0x7d
+	opI64Mul      byte = 0x7e
+	opI64DivS     byte = 0x7f
+)
+
+func appendUnsigned(b []byte, value uint64) []byte {
+	for {
+		next := byte(value & 0x7f)
+		value >>= 7
+		if value == 0 {
+			return append(b, next)
+		}
+		b = append(b, next|0x80)
+	}
+}
+
+func appendSigned(b []byte, value int64) []byte {
+	for {
+		next := byte(value & 0x7f)
+		value >>= 7
+		// done once the rest is only the sign, and the sign bit of next agrees
+		if (value == 0 && next&0x40 == 0) || (value == -1 && next&0x40 != 0) {
+			return append(b, next)
+		}
+		b = append(b, next|0x80)
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendUnsigned(b, uint64(len(name)))
+	return append(b, name...)
+}
+
+// appendSection writes a section with its id and size
+func appendSection(b []byte, id byte, contents []byte) []byte {
+	b = append(b, id)
+	b = appendUnsigned(b, uint64(len(contents)))
+	return append(b, contents...)
+}
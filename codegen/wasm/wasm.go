@@ -0,0 +1,296 @@
+package wasm
+
+import (
+	"fmt"
+	"shake/ir"
+	"shake/trace"
+	"shake/types"
+
+	"github.com/fatih/color"
+)
+
+type generator struct {
+	module *ir.Module
+	// index of every function
+	indexes  map[string]int
+	function *ir.Function
+	code     []byte
+	// types of the locals after the parameters
+	locals []byte
+	// local of every value that is stored in one
+	values map[*ir.Value]int
+	// position of every block of the current function
+	positions map[*ir.Block]int
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[Wasm Error]"), reason, line)
+}
+
+// Generate translates a module to a binary WebAssembly module, which exports
+// the entry function as main
+func Generate(module *ir.Module) ([]byte, error) {
+	g := &generator{module: module, indexes: make(map[string]int)}
+	for i, f := range module.Functions {
+		g.indexes[f.Name] = i
+	}
+	entry, ok := g.indexes[module.Entry]
+	if !ok {
+		return nil, Error("Program has no entry function, mark one with (entry) or call it main", 0)
+	}
+
+	// identical signatures share a type
+	signatures := []string{}
+	signatureIndexes := make(map[string]int)
+	functionSection := appendUnsigned(nil, uint64(len(module.Functions)))
+	codeSection := appendUnsigned(nil, uint64(len(module.Functions)))
+	for _, f := range module.Functions {
+		signature, err := signature(f)
+		if err != nil {
+			return nil, err
+		}
+		index, ok := signatureIndexes[string(signature)]
+		if !ok {
+			index = len(signatures)
+			signatureIndexes[string(signature)] = index
+			signatures = append(signatures, string(signature))
+		}
+		functionSection = appendUnsigned(functionSection, uint64(index))
+
+		body, err := g.generateFunction(f)
+		if err != nil {
+			return nil, err
+		}
+		codeSection = appendUnsigned(codeSection, uint64(len(body)))
+		codeSection = append(codeSection, body...)
+	}
+
+	typeSection := appendUnsigned(nil, uint64(len(signatures)))
+	for _, signature := range signatures {
+		typeSection = append(typeSection, signature...)
+	}
+
+	exportSection := appendUnsigned(nil, 1)
+	exportSection = appendName(exportSection, "main")
+	exportSection = append(exportSection, exportFunction)
+	exportSection = appendUnsigned(exportSection, uint64(entry))
+
+	binary := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	binary = appendSection(binary, sectionType, typeSection)
+	binary = appendSection(binary, sectionFunction, functionSection)
+	binary = appendSection(binary, sectionExport, exportSection)
+	binary = appendSection(binary, sectionCode, codeSection)
+	return binary, nil
+}
+
+// valueType returns the Wasm type a shake type is stored in
+func valueType(t types.Type, line uint64) (byte, error) {
+	switch t {
+	case types.TypeInt32, types.TypeBool, types.TypeError:
+		return typeI32, nil
+	case types.TypeInt64:
+		return typeI64, nil
+	}
+	return 0, Error(fmt.Sprintf("Type: %s is not supported by the Wasm backend", t), line)
+}
+
+func signature(f *ir.Function) ([]byte, error) {
+	signature := []byte{functionType}
+	signature = appendUnsigned(signature, uint64(len(f.Params)))
+	for _, parameter := range f.Params {
+		parameterType, err := valueType(parameter, f.Line)
+		if err != nil {
+			return nil, err
+		}
+		signature = append(signature, parameterType)
+	}
+	if f.Result == types.TypeEmpty {
+		return append(signature, 0), nil
+	}
+	returnType, err := valueType(f.Result, f.Line)
+	if err != nil {
+		return nil, err
+	}
+	return append(signature, 1, returnType), nil
+}
+
+func (g *generator) emit(code ...byte) {
+	g.code = append(g.code, code...)
+}
+
+func (g *generator) emitIndex(op byte, index int) {
+	g.code = append(g.code, op)
+	g.code = appendUnsigned(g.code, uint64(index))
+}
+
+func (g *generator) newLocal(t byte) int {
+	g.locals = append(g.locals, t)
+	return len(g.function.Params) + len(g.locals) - 1
+}
+
+// generateFunction returns the body of a function for the code section. Every
+// block but the last ends a `block` opened at the start of the function, so a
+// jump to a later block is a br out of the blocks in between.
+func (g *generator) generateFunction(f *ir.Function) ([]byte, error) {
+	g.function = f
+	g.code = nil
+	g.locals = nil
+	g.values = make(map[*ir.Value]int)
+	g.positions = make(map[*ir.Block]int)
+
+	for i, block := range f.Blocks {
+		g.positions[block] = i
+		for _, v := range block.Values {
+			switch {
+			case v.Op == ir.OpParam:
+				g.values[v] = v.Index
+			case v.Op == ir.OpConst, v.Type == types.TypeEmpty:
+			default:
+				localType, err := valueType(v.Type, v.Line)
+				if err != nil {
+					return nil, err
+				}
+				g.values[v] = g.newLocal(localType)
+			}
+		}
+	}
+
+	for range len(f.Blocks) - 1 {
+		g.emit(opBlock, blockEmpty)
+	}
+	for i, block := range f.Blocks {
+		if i > 0 {
+			g.emit(opEnd)
+		}
+		for _, v := range block.Values {
+			g.value(v)
+		}
+
+		switch block.Kind {
+		case ir.BlockPlain:
+			g.jump(block, block.Succs[0], 0)
+		case ir.BlockIf:
+			// the copies into the phis of each successor happen on its own edge
+			g.push(block.Control)
+			g.emit(opIf, blockEmpty)
+			g.jump(block, block.Succs[0], 1)
+			g.emit(opEnd)
+			g.jump(block, block.Succs[1], 0)
+		case ir.BlockReturn:
+			if block.Control != nil {
+				g.push(block.Control)
+			}
+			g.emit(opReturn)
+		case ir.BlockFail:
+			g.emit(opUnreachable)
+		}
+	}
+	g.emit(opEnd)
+
+	body := appendUnsigned(nil, uint64(len(g.locals)))
+	for _, local := range g.locals {
+		body = append(body, 1, local)
+	}
+	return append(body, g.code...), nil
+}
+
+// jump assigns the phis of succ and branches to it from the end of block, with
+// depth more blocks or ifs open than the ones the blocks of the function open
+func (g *generator) jump(block, succ *ir.Block, depth int) {
+	index := 0
+	for i, pred := range succ.Preds {
+		if pred == block {
+			index = i
+		}
+	}
+	for _, v := range succ.Values {
+		if local, ok := g.values[v]; ok && v.Op == ir.OpPhi {
+			g.push(v.Args[index])
+			g.emitIndex(opLocalSet, local)
+		}
+	}
+	// the next block follows the end of the innermost block
+	distance := g.positions[succ] - g.positions[block] - 1 + depth
+	if distance > 0 {
+		g.emitIndex(opBr, distance)
+	}
+}
+
+// push pushes a value on the stack
+func (g *generator) push(v *ir.Value) {
+	if v.Op != ir.OpConst {
+		g.emitIndex(opLocalGet, g.values[v])
+		return
+	}
+	if v.Type == types.TypeInt64 {
+		g.emit(opI64Const)
+	} else {
+		g.emit(opI32Const)
+	}
+	g.code = appendSigned(g.code, v.Const)
+}
+
+// instructions of every operation, for i32 and i64 operands
+var instructions = map[ir.Op][2]byte{
+	ir.OpAdd: {opI32Add, opI64Add},
+	ir.OpSub: {opI32Sub, opI64Sub},
+	ir.OpMul: {opI32Mul, opI64Mul},
+	ir.OpEq:  {opI32Eq, opI64Eq},
+	ir.OpNe:  {opI32Ne, opI64Ne},
+	ir.OpLt:  {opI32LtS, opI64LtS},
+	ir.OpLe:  {opI32LeS, opI64LeS},
+	ir.OpGt:  {opI32GtS, opI64GtS},
+	ir.OpGe:  {opI32GeS, opI64GeS},
+}
+
+func (g *generator) value(v *ir.Value) {
+	local, stored := g.values[v]
+	switch {
+	case v.Op == ir.OpCall:
+		for _, arg := range v.Args {
+			g.push(arg)
+		}
+		g.emitIndex(opCall, g.indexes[v.Callee])
+	case !stored, v.Op == ir.OpParam, v.Op == ir.OpPhi:
+		// phis are assigned by their predecessors
+		return
+	case v.Op == ir.OpCopy:
+		g.push(v.Args[0])
+	case v.Op == ir.OpDiv:
+		g.divide(v.Args[0], v.Args[1])
+	default:
+		g.push(v.Args[0])
+		g.push(v.Args[1])
+		wide := 0
+		if v.Args[0].Type == types.TypeInt64 {
+			wide = 1
+		}
+		g.emit(instructions[v.Op][wide])
+	}
+	if stored {
+		g.emitIndex(opLocalSet, local)
+	}
+}
+
+// divide pushes the quotient of left and right. Division by zero traps, but
+// dividing by -1 negates so the minimum wraps like the other backends.
+func (g *generator) divide(left, right *ir.Value) {
+	t, constant, subtract, divide, equal := typeI32, opI32Const, opI32Sub, opI32DivS, opI32Eq
+	if left.Type == types.TypeInt64 {
+		t, constant, subtract, divide, equal = typeI64, opI64Const, opI64Sub, opI64DivS, opI64Eq
+	}
+	g.push(right)
+	g.emit(constant, 0x7f) // -1
+	g.emit(equal)
+	g.emit(opIf, t)
+	g.emit(constant, 0)
+	g.push(left)
+	g.emit(subtract)
+	g.emit(opElse)
+	g.push(left)
+	g.push(right)
+	g.emit(divide)
+	g.emit(opEnd)
+}
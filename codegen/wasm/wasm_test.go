@@ -0,0 +1,84 @@
+package wasm_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"shake/codegen/testdata/fixture"
+	"shake/codegen/wasm"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// run instantiates the module with wazero, which validates it, and calls main
+func run(t *testing.T, module []byte) (int64, error) {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	defer runtime.Close(ctx)
+
+	instance, err := runtime.Instantiate(ctx, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := instance.ExportedFunction("main")
+	if main == nil {
+		t.Fatal("module does not export main")
+	}
+	results, err := main.Call(ctx)
+	if err != nil || len(results) == 0 {
+		return 0, err
+	}
+	if main.Definition().ResultTypes()[0] == api.ValueTypeI32 {
+		return int64(api.DecodeI32(results[0])), nil
+	}
+	return int64(results[0]), nil
+}
+
+// TestRun runs every program of the codegen corpus at every optimization level
+// and compares the result of main with the result of the VM
+func TestRun(t *testing.T) {
+	for _, path := range fixture.Corpus(t) {
+		for level := range 3 {
+			t.Run(fmt.Sprintf("%s/O%d", filepath.Base(path), level), func(t *testing.T) {
+				program := fixture.ParseFile(t, path)
+				want := fixture.Result(t, program)
+
+				module, err := wasm.Generate(fixture.Module(t, program, level))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := run(t, module)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("main returned %d, the VM returned %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+// TestTrap checks that a division by zero stops the module like it stops the VM
+func TestTrap(t *testing.T) {
+	program := fixture.ParseString(t, `
+fn divide(a: int32, b: int32): int32 {
+    return a / b;
+}
+
+fn main(): int32 {
+    return divide(1, 0);
+}
+`)
+	module, err := wasm.Generate(fixture.Module(t, program, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = run(t, module)
+	if err == nil {
+		t.Fatal("division by zero did not trap")
+	}
+}
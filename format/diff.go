@@ -0,0 +1,131 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// context is how many unchanged lines are shown around every change
+const context = 3
+
+type edit struct {
+	// ' ', '-' or '+'
+	kind byte
+	text string
+}
+
+// Diff returns the changes from before to after as a unified diff, empty when they are the same
+func Diff(name string, before, after []byte) string {
+	if string(before) == string(after) {
+		return ""
+	}
+	edits := lineEdits(splitLines(string(before)), splitLines(string(after)))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s (formatted)\n", name, name)
+	// line numbers in before and after of the next edit
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(edits); {
+		if edits[start].kind == ' ' {
+			oldLine++
+			newLine++
+			start++
+			continue
+		}
+		// a hunk starts with the context before the change and ends once there
+		// are more unchanged lines than the context of two changes
+		from := max(start-context, 0)
+		oldStart, newStart := oldLine-(start-from), newLine-(start-from)
+		end := start
+		for unchanged := 0; end < len(edits) && unchanged <= 2*context; end++ {
+			if edits[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		// drop the unchanged lines past the context after the last change
+		last := end - 1
+		for last >= start && edits[last].kind == ' ' {
+			last--
+		}
+		end = min(last+1+context, len(edits))
+
+		oldCount, newCount := 0, 0
+		var hunk strings.Builder
+		for _, e := range edits[from:end] {
+			hunk.WriteByte(e.kind)
+			hunk.WriteString(e.text)
+			hunk.WriteByte('\n')
+			if e.kind != '+' {
+				oldCount++
+			}
+			if e.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n%s", oldStart, oldCount, newStart, newCount, hunk.String())
+
+		for _, e := range edits[start:end] {
+			if e.kind != '+' {
+				oldLine++
+			}
+			if e.kind != '-' {
+				newLine++
+			}
+		}
+		start = end
+	}
+	return b.String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// lineEdits turns before into after with the fewest removed and added lines,
+// from their longest common subsequence
+func lineEdits(before, after []string) []edit {
+	// common[i][j] is the length of the longest common subsequence of before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			edits = append(edits, edit{' ', before[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			edits = append(edits, edit{'-', before[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', after[j]})
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		edits = append(edits, edit{'-', before[i]})
+	}
+	for ; j < len(after); j++ {
+		edits = append(edits, edit{'+', after[j]})
+	}
+	return edits
+}
@@ -0,0 +1,561 @@
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"shake/lexer"
+	"shake/parser"
+	"shake/trace"
+	"shake/types"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[Format Error]"), reason, line)
+}
+
+// indentation of every level of nesting
+const indentation = "  "
+
+// Source formats a program. It only formats programs that parse, and
+// formatting its result again gives the same source.
+//
+// Comments are kept on the line they were on, or above the statement that
+// follows them when they were inside an expression that spans several lines.
+// At most one blank line is kept between statements.
+func Source(source []byte) ([]byte, error) {
+	tokens, comments, err := lexer.LexComments(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	occupied := map[uint64]bool{}
+	starts := map[uint64]uint64{}
+	for i := 0; i < tokens.Size(); i++ {
+		token, _ := tokens.Peek(i)
+		if !occupied[token.LineNumber] {
+			starts[token.LineNumber] = token.Column
+		}
+		occupied[token.LineNumber] = true
+	}
+	for _, comment := range comments {
+		for line := comment.LineNumber; line <= lastLine(comment); line++ {
+			occupied[line] = true
+		}
+	}
+	program, err := parser.NewParser(tokens.All()).ParseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &printer{comments: comments, occupied: occupied, starts: starts}
+	err = p.program(program)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(p.b.String()), nil
+}
+
+type printer struct {
+	b      strings.Builder
+	indent int
+	// comments that are not printed yet, in source order
+	comments []lexer.Token
+	// the source lines that are not blank
+	occupied map[uint64]bool
+	// the column of the first token of every source line with tokens
+	starts map[uint64]uint64
+	// source line of the output line being written, 0 before anything is written to it
+	line uint64
+	// the last source line that was printed
+	last uint64
+	// set at the start of a scope, where blank lines are dropped
+	first bool
+	// set between top level declarations, which are always separated by a blank line
+	separate    bool
+	atLineStart bool
+	// for every open block, the source line whose comments wait for its `}`,
+	// or 0 when the block spans several source lines
+	held []uint64
+}
+
+func (p *printer) write(s string) {
+	if p.atLineStart {
+		p.b.WriteString(strings.Repeat(indentation, p.indent))
+		p.atLineStart = false
+	}
+	p.b.WriteString(s)
+}
+
+// lastLine returns the line a comment ends at, block comments can span lines
+func lastLine(comment lexer.Token) uint64 {
+	return comment.LineNumber + uint64(strings.Count(comment.Value, "\n"))
+}
+
+// writeComment writes a comment, the lines of a block comment are kept as they are
+func (p *printer) writeComment(comment lexer.Token) {
+	p.write(strings.ReplaceAll(comment.Value, "\r\n", "\n"))
+	p.last = max(p.last, lastLine(comment))
+}
+
+// newline ends the output line with the comments that were on its source line
+func (p *printer) newline() {
+	for len(p.comments) > 0 && p.line != 0 && p.comments[0].LineNumber == p.line && !slices.Contains(p.held, p.line) {
+		p.write(" ")
+		p.writeComment(p.comments[0])
+		p.comments = p.comments[1:]
+	}
+	p.b.WriteString("\n")
+	p.atLineStart = true
+	p.line = 0
+}
+
+// mark records that something from a source line is printed on the current output line
+func (p *printer) mark(line uint64) {
+	if line == 0 {
+		return
+	}
+	if p.line == 0 {
+		p.line = line
+	}
+	p.last = max(p.last, line)
+}
+
+// gap writes a blank line before something at line when the source had one there
+func (p *printer) gap(line uint64) {
+	if p.separate || (!p.first && p.last != 0 && line-1 > p.last && !p.occupied[line-1]) {
+		p.b.WriteString("\n")
+	}
+	p.first = false
+	p.separate = false
+}
+
+// leading writes the comments that come before line and then separates line
+// from them like the source did
+func (p *printer) leading(line uint64) {
+	p.flush(line)
+	p.gap(line)
+}
+
+// flush writes the comments that come before line, the ones from the same
+// source line together on their own line
+func (p *printer) flush(line uint64) {
+	for p.hasComments(line) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		p.gap(comment.LineNumber)
+		p.writeComment(comment)
+		for p.hasComments(line) && p.comments[0].LineNumber == lastLine(comment) {
+			comment = p.comments[0]
+			p.comments = p.comments[1:]
+			p.write(" ")
+			p.writeComment(comment)
+		}
+		p.b.WriteString("\n")
+		p.atLineStart = true
+	}
+}
+
+// hasComments reports whether a comment comes before line, or on it before its first token
+func (p *printer) hasComments(line uint64) bool {
+	if len(p.comments) == 0 {
+		return false
+	}
+	comment := p.comments[0]
+	return comment.LineNumber < line || (comment.LineNumber == line && comment.Column < p.starts[line])
+}
+
+func (p *printer) program(program *parser.NodeProgram) error {
+	for i, statement := range program.Statements() {
+		p.separate = i > 0
+		switch statement := statement.(type) {
+		case *parser.NodeFunction:
+			p.leading(statement.Line())
+			if statement.IsEntry() {
+				p.write("(entry)")
+				p.newline()
+			}
+			err := p.function(statement)
+			if err != nil {
+				return err
+			}
+		case *parser.NodeInterface:
+			p.leading(statement.Line())
+			p.declaredInterface(statement)
+		default:
+			return Error(fmt.Sprintf("Cannot format top level statement: %T", statement), p.last)
+		}
+		p.newline()
+	}
+	// comments after the last declaration
+	p.separate = false
+	if len(p.comments) > 0 {
+		p.flush(p.comments[len(p.comments)-1].LineNumber + 1)
+	}
+	return nil
+}
+
+func (p *printer) function(function *parser.NodeFunction) error {
+	p.mark(function.Line())
+	p.write("fn")
+	if receiver := function.Receiver(); receiver != nil {
+		p.write(" (" + receiver.Identifier + ": " + receiver.Type.String() + ")")
+	}
+	if function.Name() != "" {
+		p.write(" " + function.Name())
+	}
+	p.parameters(function.Parameters())
+	p.write(": " + function.ReturnType().String())
+	if function.Inline() {
+		p.write(": ")
+		err := p.expression(function.Scope().Statements()[0].(*parser.NodeReturn).Value())
+		if err != nil {
+			return err
+		}
+		p.write(";")
+		return nil
+	}
+	p.write(" ")
+	return p.scope(function.Scope())
+}
+
+// parameters writes `(x: int32, y: int32)`
+func (p *printer) parameters(parameters []*parser.NodeTermIdentifier) {
+	p.write("(")
+	for i, parameter := range parameters {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(parameter.Identifier + ": " + parameter.Type.String())
+	}
+	p.write(")")
+}
+
+func (p *printer) declaredInterface(declared *parser.NodeInterface) {
+	p.mark(declared.Line())
+	p.write("interface " + declared.Name() + " {")
+	if len(declared.Methods()) == 0 && !p.hasComments(declared.End()) {
+		p.write("}")
+		p.mark(declared.End())
+		return
+	}
+	p.open(declared.End())
+	for _, method := range declared.Methods() {
+		p.leading(method.LineNumber)
+		p.mark(method.LineNumber)
+		p.write(method.Name)
+		p.parameters(method.Parameters)
+		p.write(": " + method.ReturnType.String() + ";")
+		p.newline()
+	}
+	p.closing(declared.End())
+}
+
+// scope writes `{`, the statements and `}`, without ending the line of the `}`
+func (p *printer) scope(scope *parser.NodeScope) error {
+	p.write("{")
+	if len(scope.Statements()) == 0 && !p.hasComments(scope.End()) {
+		p.write("}")
+		p.mark(scope.End())
+		return nil
+	}
+	p.open(scope.End())
+	for _, statement := range scope.Statements() {
+		err := p.statement(statement)
+		if err != nil {
+			return err
+		}
+		p.newline()
+	}
+	p.closing(scope.End())
+	return nil
+}
+
+// open ends the line of a `{` and indents the block that ends at line end.
+// A block written on a single source line is printed on several, the
+// comments after it stay after its `}`.
+func (p *printer) open(end uint64) {
+	held := uint64(0)
+	if end == p.line {
+		held = end
+	}
+	p.held = append(p.held, held)
+	p.newline()
+	p.indent++
+	p.first = true
+}
+
+// closing writes the comments left in a block and its `}`
+func (p *printer) closing(end uint64) {
+	p.flush(end)
+	p.first = false
+	p.indent--
+	p.held = p.held[:len(p.held)-1]
+	p.write("}")
+	p.mark(end)
+}
+
+func (p *printer) statement(statement parser.NodeScopedStatement) error {
+	switch statement := statement.(type) {
+	case *parser.NodeAssignment:
+		p.leading(statement.LineNumber)
+		p.mark(statement.LineNumber)
+		p.write(statement.Identifier)
+		if statement.Annotated {
+			p.write(": " + statement.Type.String())
+		}
+		p.write(" = ")
+		err := p.expression(*statement.Expression)
+		if err != nil {
+			return err
+		}
+		p.write(";")
+		return nil
+	case *parser.NodeIndexAssignment:
+		p.leading(statement.LineNumber)
+		p.mark(statement.LineNumber)
+		err := p.expression(statement.Target)
+		if err != nil {
+			return err
+		}
+		p.write(" = ")
+		err = p.expression(statement.Expression)
+		if err != nil {
+			return err
+		}
+		p.write(";")
+		return nil
+	case *parser.NodeExpressionStatement:
+		p.leading(statement.LineNumber)
+		p.mark(statement.LineNumber)
+		err := p.expression(statement.Expression)
+		if err != nil {
+			return err
+		}
+		p.write(";")
+		return nil
+	case *parser.NodeReturn:
+		p.leading(statement.LineNumber)
+		p.mark(statement.LineNumber)
+		p.write("return ")
+		err := p.expression(statement.Value())
+		if err != nil {
+			return err
+		}
+		p.write(";")
+		return nil
+	case *parser.NodeConditional:
+		p.leading(statement.LineNumber)
+		return p.conditional(statement)
+	case *parser.NodeForIn:
+		p.leading(statement.LineNumber)
+		p.mark(statement.LineNumber)
+		p.write("for ")
+		switch {
+		case statement.Key != "" && statement.Value != "":
+			p.write(statement.Key + ", " + statement.Value)
+		case statement.Key != "":
+			p.write(statement.Key)
+		default:
+			p.write(statement.Value)
+		}
+		p.write(" in ")
+		err := p.expression(statement.Collection)
+		if err != nil {
+			return err
+		}
+		p.write(" ")
+		return p.scope(statement.Scope())
+	}
+	return Error(fmt.Sprintf("Cannot format statement: %T", statement), p.last)
+}
+
+// matchesBool reports whether the arms of a conditional can follow its
+// condition directly, as in `if x == 1 { true {} false {} }`. The parser only
+// takes the arms for a body when the first is `true`, `false` or `else`.
+func matchesBool(conditional *parser.NodeConditional) bool {
+	if conditional.Condition.GetType() != types.TypeBool {
+		return false
+	}
+	first := conditional.Arms[0]
+	if first.Value == nil {
+		return true
+	}
+	literal, ok := first.Value.(parser.NodeExpressionLiteral)
+	if !ok {
+		return false
+	}
+	_, ok = literal.Value.(parser.NodeTermBool)
+	return ok
+}
+
+func (p *printer) conditional(conditional *parser.NodeConditional) error {
+	p.mark(conditional.LineNumber)
+	p.write("if ")
+	err := p.expression(conditional.Condition)
+	if err != nil {
+		return err
+	}
+	if conditional.Scope() != nil {
+		p.write(" ")
+		return p.scope(conditional.Scope())
+	}
+
+	if len(conditional.Arms) > 0 && matchesBool(conditional) {
+		p.write(" {")
+	} else {
+		p.write(" == {")
+	}
+	p.open(conditional.End())
+	for _, arm := range conditional.Arms {
+		p.leading(arm.LineNumber)
+		p.mark(arm.LineNumber)
+		if arm.Value == nil {
+			p.write("else")
+		} else {
+			err = p.expression(arm.Value)
+			if err != nil {
+				return err
+			}
+		}
+		if arm.Scope() != nil {
+			p.write(" ")
+			err = p.scope(arm.Scope())
+		} else {
+			p.write(": ")
+			err = p.expression(arm.Result)
+		}
+		if err != nil {
+			return err
+		}
+		p.newline()
+	}
+	p.closing(conditional.End())
+	return nil
+}
+
+func (p *printer) expressions(expressions []parser.NodeExpression) error {
+	for i, expression := range expressions {
+		if i > 0 {
+			p.write(", ")
+		}
+		err := p.expression(expression)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *printer) expression(expression parser.NodeExpression) error {
+	switch expression := expression.(type) {
+	case parser.NodeExpressionLiteral:
+		switch term := expression.Value.(type) {
+		case parser.NodeTermInt32:
+			p.write(term.Value)
+		case parser.NodeTermBool:
+			p.write(fmt.Sprint(term.Value))
+		case parser.NodeTermEmpty:
+			p.write("empty")
+		default:
+			return Error(fmt.Sprintf("Cannot format literal: %T", expression.Value), p.last)
+		}
+		return nil
+	case *parser.NodeExpressionIdentifier:
+		identifier, ok := expression.Identifier.(*parser.NodeTermIdentifier)
+		if !ok {
+			return Error(fmt.Sprintf("Cannot format identifier: %T", expression.Identifier), p.last)
+		}
+		p.write(identifier.Identifier)
+		return nil
+	case *parser.NodeExpressionBinary:
+		// the right side always binds tighter, so no parentheses are needed
+		err := p.expression(expression.Left)
+		if err != nil {
+			return err
+		}
+		p.write(" " + expression.Operation + " ")
+		return p.expression(expression.Right)
+	case *parser.NodeExpressionCall:
+		err := p.expression(expression.Callee)
+		if err != nil {
+			return err
+		}
+		p.mark(expression.LineNumber)
+		p.write("(")
+		err = p.expressions(expression.Arguments)
+		if err != nil {
+			return err
+		}
+		p.write(")")
+		return nil
+	case *parser.NodeExpressionMethodCall:
+		err := p.expression(expression.Receiver)
+		if err != nil {
+			return err
+		}
+		p.mark(expression.LineNumber)
+		p.write("." + expression.Method + "(")
+		err = p.expressions(expression.Arguments)
+		if err != nil {
+			return err
+		}
+		p.write(")")
+		return nil
+	case *parser.NodeExpressionConversion:
+		// conversions to interfaces are implicit
+		return p.expression(expression.Value)
+	case *parser.NodeExpressionIndex:
+		err := p.expression(expression.Collection)
+		if err != nil {
+			return err
+		}
+		p.mark(expression.LineNumber)
+		p.write("[")
+		err = p.expression(expression.Index)
+		if err != nil {
+			return err
+		}
+		p.write("]")
+		return nil
+	case *parser.NodeExpressionBuiltin:
+		p.mark(expression.LineNumber)
+		p.write(expression.Name + "(")
+		err := p.expressions(expression.Arguments)
+		if err != nil {
+			return err
+		}
+		p.write(")")
+		return nil
+	case *parser.NodeExpressionCollection:
+		p.write(expression.Type.String() + "{")
+		for i, element := range expression.Elements {
+			if i > 0 {
+				p.write(", ")
+			}
+			if expression.Keys != nil {
+				err := p.expression(expression.Keys[i])
+				if err != nil {
+					return err
+				}
+				p.write(": ")
+			}
+			err := p.expression(element)
+			if err != nil {
+				return err
+			}
+		}
+		p.write("}")
+		return nil
+	case *parser.NodeConditional:
+		return p.conditional(expression)
+	case *parser.NodeFunction:
+		p.mark(expression.Line())
+		return p.function(expression)
+	}
+	return Error(fmt.Sprintf("Cannot format expression: %T", expression), p.last)
+}
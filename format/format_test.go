@@ -0,0 +1,135 @@
+package format_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"shake/format"
+	"shake/lexer"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the formatted inputs to the golden files")
+
+// corpus is every example program of the repository, testdata holds badly
+// formatted inputs and what formatting them gives
+var corpus = []string{
+	"testdata/*.input",
+	"testdata/*.golden",
+	"../main.shk",
+	"../bytecode/testdata/*.shk",
+	"../codegen/testdata/*.shk",
+}
+
+// TestIdempotent checks that formatting formatted source changes nothing
+func TestIdempotent(t *testing.T) {
+	paths := []string{}
+	for _, pattern := range corpus {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no programs to format")
+	}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once, err := format.Source(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			twice, err := format.Source(once)
+			if err != nil {
+				t.Fatalf("formatted source does not parse: %v\n%s", err, once)
+			}
+			if string(twice) != string(once) {
+				t.Errorf("formatting again changed the source\nonce:\n%s\ntwice:\n%s", once, twice)
+			}
+		})
+	}
+}
+
+// inputs returns the badly formatted programs of testdata
+func inputs(t *testing.T) []string {
+	paths, err := filepath.Glob("testdata/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no inputs to format")
+	}
+	return paths
+}
+
+// TestGolden checks every input formats to its golden file, run with -update
+// to write them
+func TestGolden(t *testing.T) {
+	for _, path := range inputs(t) {
+		t.Run(path, func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := format.Source(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := strings.TrimSuffix(path, ".input") + ".golden"
+			if *update {
+				err = os.WriteFile(golden, got, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestComments checks formatting keeps every comment and their order
+func TestComments(t *testing.T) {
+	for _, path := range inputs(t) {
+		t.Run(path, func(t *testing.T) {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatted, err := format.Source(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := comments(t, source)
+			got := comments(t, formatted)
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got comments:\n%q\nwant:\n%q", got, want)
+			}
+		})
+	}
+}
+
+func comments(t *testing.T, source []byte) []string {
+	_, tokens, err := lexer.LexComments(bytes.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{}
+	for _, token := range tokens {
+		values = append(values, token.Value)
+	}
+	return values
+}
@@ -5,10 +5,11 @@ go 1.23.3
 require (
 	github.com/fatih/color v1.18.0
 	github.com/jessevdk/go-flags v1.6.1
+	github.com/tetratelabs/wazero v1.10.1
+	golang.org/x/sys v0.25.0
 )
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	golang.org/x/sys v0.25.0 // indirect
 )
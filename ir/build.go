@@ -0,0 +1,514 @@
+package ir
+
+import (
+	"fmt"
+	"shake/parser"
+	"shake/trace"
+	"shake/types"
+	"strconv"
+
+	"github.com/fatih/color"
+)
+
+// builder constructs SSA form straight from the AST, following "Simple and
+// Efficient Construction of Static Single Assignment Form" by Braun et al.
+type builder struct {
+	program  *parser.NodeProgram
+	function *Function
+	// the block instructions are added to, nil after a terminator
+	block *Block
+	// the value of every variable at the end of each block. Variables are
+	// identifiers, or conditionals used as values for the value of their arms
+	definitions map[any]map[*Block]*Value
+	types       map[any]types.Type
+	sealed      map[*Block]bool
+	// phis created in blocks whose predecessors were not all known yet
+	incomplete map[*Block]map[any]*Value
+	// where a return inside the arm of a conditional used as a value goes, innermost last
+	yields []yield
+	line   uint64
+}
+
+type yield struct {
+	conditional *parser.NodeConditional
+	end         *Block
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[IR Error]"), reason, line)
+}
+
+// Build lowers a parsed program to SSA form
+func Build(program *parser.NodeProgram) (*Module, error) {
+	module := &Module{}
+	for _, statement := range program.Statements() {
+		node, ok := statement.(*parser.NodeFunction)
+		if !ok {
+			continue
+		}
+		if node.Receiver() != nil {
+			return nil, Error("Methods are not supported by the IR", node.Line())
+		}
+		if node.IsEntry() || (module.Entry == "" && node.Name() == "main") {
+			module.Entry = node.Name()
+		}
+		b := &builder{program: program}
+		f, err := b.buildFunction(node)
+		if err != nil {
+			return nil, err
+		}
+		module.Functions = append(module.Functions, f)
+	}
+	return module, nil
+}
+
+func (b *builder) buildFunction(node *parser.NodeFunction) (*Function, error) {
+	f := &Function{Name: node.Name(), Result: node.ReturnType(), Line: node.Line()}
+	b.function = f
+	b.definitions = make(map[any]map[*Block]*Value)
+	b.types = make(map[any]types.Type)
+	b.sealed = make(map[*Block]bool)
+	b.incomplete = make(map[*Block]map[any]*Value)
+	b.line = node.Line()
+
+	entry := f.NewBlock()
+	b.startBlock(entry)
+	for i, parameter := range node.Parameters() {
+		if parameter.Type.Kind() != types.KindBasic {
+			return nil, Error(fmt.Sprintf("Parameter: %s of type: %s is not supported by the IR", parameter.Identifier, parameter.Type), node.Line())
+		}
+		f.Params = append(f.Params, parameter.Type)
+		v := b.newValue(OpParam, parameter.Type)
+		v.Index = i
+		b.writeVariable(parameter, parameter.Type, entry, v)
+	}
+
+	err := b.scope(node.Scope())
+	if err != nil {
+		return nil, err
+	}
+	if b.block != nil {
+		// functions that return nothing can fall through
+		if f.Result == types.TypeEmpty {
+			b.block.Kind = BlockReturn
+		} else {
+			// the parser makes sure every reachable path returns
+			b.block.Kind = BlockFail
+			b.block.Message = "missing return in function: " + f.Name
+			b.block.Line = f.Line
+		}
+		b.block = nil
+	}
+
+	removeUnreachable(f)
+	return f, nil
+}
+
+// current returns the block to add instructions to. Code after a terminator is
+// unreachable, it goes to a block without predecessors that is removed later.
+func (b *builder) current() *Block {
+	if b.block == nil {
+		b.startBlock(b.function.NewBlock())
+	}
+	return b.block
+}
+
+// startBlock appends a block whose predecessors are all known to the function
+func (b *builder) startBlock(block *Block) {
+	b.function.Blocks = append(b.function.Blocks, block)
+	b.block = block
+	b.sealBlock(block)
+}
+
+func (b *builder) newValue(op Op, t types.Type, args ...*Value) *Value {
+	v := b.function.NewValue(b.current(), op, t, args...)
+	v.Line = b.line
+	return v
+}
+
+func (b *builder) constant(t types.Type, value int64) *Value {
+	v := b.newValue(OpConst, t)
+	v.Const = value
+	return v
+}
+
+// jump ends the current block with a jump to target
+func (b *builder) jump(target *Block) {
+	block := b.current()
+	block.Kind = BlockPlain
+	AddEdge(block, target)
+	b.block = nil
+}
+
+// branch ends the current block with a jump on a condition
+func (b *builder) branch(condition *Value, then, otherwise *Block) {
+	block := b.current()
+	block.Kind = BlockIf
+	block.Control = condition
+	AddEdge(block, then)
+	AddEdge(block, otherwise)
+	b.block = nil
+}
+
+func (b *builder) writeVariable(variable any, t types.Type, block *Block, v *Value) {
+	if b.definitions[variable] == nil {
+		b.definitions[variable] = make(map[*Block]*Value)
+	}
+	b.definitions[variable][block] = v
+	b.types[variable] = t
+}
+
+func (b *builder) readVariable(variable any, block *Block) *Value {
+	if v, ok := b.definitions[variable][block]; ok {
+		return v
+	}
+
+	var v *Value
+	switch {
+	case !b.sealed[block]:
+		v = b.newPhi(variable, block)
+		if b.incomplete[block] == nil {
+			b.incomplete[block] = make(map[any]*Value)
+		}
+		b.incomplete[block][variable] = v
+	case len(block.Preds) == 0:
+		// only unreachable blocks read variables nothing defined
+		v = b.undefined(b.types[variable], block)
+	case len(block.Preds) == 1:
+		v = b.readVariable(variable, block.Preds[0])
+	default:
+		phi := b.newPhi(variable, block)
+		b.writeVariable(variable, b.types[variable], block, phi)
+		v = b.addPhiOperands(variable, phi)
+	}
+	b.writeVariable(variable, b.types[variable], block, v)
+	return v
+}
+
+// undefined is the value of a variable nothing defined, a zero at the start of block
+func (b *builder) undefined(t types.Type, block *Block) *Value {
+	v := &Value{ID: b.function.nextValue, Op: OpConst, Type: t, Block: block, Line: b.line}
+	b.function.nextValue++
+	block.Values = append([]*Value{v}, block.Values...)
+	return v
+}
+
+// newPhi creates a phi without arguments at the start of the block
+func (b *builder) newPhi(variable any, block *Block) *Value {
+	phi := &Value{ID: b.function.nextValue, Op: OpPhi, Type: b.types[variable], Block: block, Line: b.line}
+	b.function.nextValue++
+	block.Values = append([]*Value{phi}, block.Values...)
+	return phi
+}
+
+func (b *builder) addPhiOperands(variable any, phi *Value) *Value {
+	for _, pred := range phi.Block.Preds {
+		phi.Args = append(phi.Args, b.readVariable(variable, pred))
+	}
+	return b.tryRemoveTrivialPhi(phi)
+}
+
+// tryRemoveTrivialPhi replaces a phi whose arguments are all the same value,
+// or the phi itself, with that value
+func (b *builder) tryRemoveTrivialPhi(phi *Value) *Value {
+	var same *Value
+	for _, arg := range phi.Args {
+		if arg == same || arg == phi {
+			continue
+		}
+		if same != nil {
+			return phi
+		}
+		same = arg
+	}
+	if same == nil {
+		same = b.undefined(phi.Type, phi.Block)
+	}
+
+	users := ReplaceUses(b.function, phi, same)
+	for _, definitions := range b.definitions {
+		for block, v := range definitions {
+			if v == phi {
+				definitions[block] = same
+			}
+		}
+	}
+	RemoveValue(phi)
+
+	// removing this phi may make the phis that used it trivial
+	for _, user := range users {
+		if user.Op == OpPhi && user != phi {
+			b.tryRemoveTrivialPhi(user)
+		}
+	}
+	return same
+}
+
+func (b *builder) sealBlock(block *Block) {
+	for variable, phi := range b.incomplete[block] {
+		b.addPhiOperands(variable, phi)
+	}
+	delete(b.incomplete, block)
+	b.sealed[block] = true
+}
+
+func (b *builder) scope(scope *parser.NodeScope) error {
+	for _, statement := range scope.Statements() {
+		err := b.statement(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *builder) statement(statement parser.NodeScopedStatement) error {
+	switch statement := statement.(type) {
+	case *parser.NodeAssignment:
+		b.line = statement.LineNumber
+		v, err := b.expression(*statement.Expression)
+		if err != nil {
+			return err
+		}
+		if statement.Variable.Type.Kind() != types.KindBasic {
+			return Error(fmt.Sprintf("Variable: %s of type: %s is not supported by the IR", statement.Identifier, statement.Variable.Type), b.line)
+		}
+		copied := b.newValue(OpCopy, statement.Variable.Type, v)
+		copied.Name = statement.Identifier
+		b.writeVariable(statement.Variable, statement.Variable.Type, b.current(), copied)
+		return nil
+	case *parser.NodeExpressionStatement:
+		b.line = statement.LineNumber
+		_, err := b.expression(statement.Expression)
+		return err
+	case *parser.NodeReturn:
+		b.line = statement.LineNumber
+		v, err := b.expression(statement.Value())
+		if err != nil {
+			return err
+		}
+		// inside the arm of a conditional used as a value, return gives the conditional its value
+		if len(b.yields) > 0 {
+			innermost := b.yields[len(b.yields)-1]
+			b.writeVariable(innermost.conditional, v.Type, b.current(), v)
+			b.jump(innermost.end)
+			return nil
+		}
+		block := b.current()
+		block.Kind = BlockReturn
+		if b.function.Result != types.TypeEmpty {
+			block.Control = v
+		}
+		b.block = nil
+		return nil
+	case *parser.NodeConditional:
+		b.line = statement.LineNumber
+		_, err := b.conditional(statement, false)
+		return err
+	case *parser.NodeIndexAssignment:
+		return Error("Collections are not supported by the IR", statement.LineNumber)
+	case *parser.NodeForIn:
+		return Error("Loops over collections are not supported by the IR", statement.LineNumber)
+	}
+	return Error(fmt.Sprintf("Cannot lower statement: %T", statement), b.line)
+}
+
+func (b *builder) expression(expression parser.NodeExpression) (*Value, error) {
+	switch expression := expression.(type) {
+	case parser.NodeExpressionLiteral:
+		switch term := expression.Value.(type) {
+		case parser.NodeTermInt32:
+			value, err := strconv.ParseInt(term.Value, 10, 64)
+			if err != nil {
+				return nil, Error(fmt.Sprintf("Invalid number: %s", term.Value), b.line)
+			}
+			return b.constant(expression.Type, value), nil
+		case parser.NodeTermBool:
+			if term.Value {
+				return b.constant(types.TypeBool, 1), nil
+			}
+			return b.constant(types.TypeBool, 0), nil
+		case parser.NodeTermEmpty:
+			return b.constant(expression.Type, 0), nil
+		}
+		return nil, Error(fmt.Sprintf("Cannot lower literal: %T", expression.Value), b.line)
+	case *parser.NodeExpressionIdentifier:
+		variable, _ := expression.Identifier.(*parser.NodeTermIdentifier)
+		if _, ok := b.types[variable]; !ok {
+			return nil, Error(fmt.Sprintf("Functions as values are not supported by the IR: %s", expression.Identifier), b.line)
+		}
+		return b.readVariable(variable, b.current()), nil
+	case *parser.NodeExpressionBinary:
+		return b.binary(expression)
+	case *parser.NodeExpressionCall:
+		return b.call(expression)
+	case *parser.NodeConditional:
+		return b.conditional(expression, true)
+	case *parser.NodeExpressionCollection, *parser.NodeExpressionIndex, *parser.NodeExpressionBuiltin:
+		return nil, Error("Collections are not supported by the IR", b.line)
+	case *parser.NodeExpressionMethodCall, *parser.NodeExpressionConversion:
+		return nil, Error("Interfaces are not supported by the IR", b.line)
+	case *parser.NodeFunction:
+		return nil, Error("Function literals are not supported by the IR", expression.Line())
+	}
+	return nil, Error(fmt.Sprintf("Cannot lower expression: %T", expression), b.line)
+}
+
+var binaryOps = map[string]Op{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"==": OpEq,
+	"!=": OpNe,
+	"<":  OpLt,
+	"<=": OpLe,
+	">":  OpGt,
+	">=": OpGe,
+}
+
+func (b *builder) binary(binary *parser.NodeExpressionBinary) (*Value, error) {
+	left, err := b.expression(binary.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := b.expression(binary.Right)
+	if err != nil {
+		return nil, err
+	}
+	op, ok := binaryOps[binary.Operation]
+	if !ok {
+		return nil, Error(fmt.Sprintf("Cannot lower operation: %s", binary.Operation), b.line)
+	}
+	if op.IsCompare() {
+		return b.newValue(op, types.TypeBool, left, right), nil
+	}
+	return b.newValue(op, left.Type, left, right), nil
+}
+
+// call only supports calling top level functions by name
+func (b *builder) call(call *parser.NodeExpressionCall) (*Value, error) {
+	callee, ok := call.Callee.(*parser.NodeExpressionIdentifier)
+	if !ok {
+		return nil, Error("Calling a function value is not supported by the IR", call.LineNumber)
+	}
+	function, ok := callee.Identifier.(*parser.NodeTermIdentifier)
+	if _, local := b.types[function]; !ok || local || b.program.Identifier(function.Identifier) != function {
+		return nil, Error("Calling a function value is not supported by the IR", call.LineNumber)
+	}
+
+	arguments := []*Value{}
+	for _, argument := range call.Arguments {
+		v, err := b.expression(argument)
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, v)
+	}
+	b.line = call.LineNumber
+	v := b.newValue(OpCall, call.Type, arguments...)
+	v.Callee = function.Identifier
+	return v, nil
+}
+
+// conditional lowers a conditional to blocks. When it is used as a value its
+// arms define it like a variable, which gives a phi where they join.
+func (b *builder) conditional(conditional *parser.NodeConditional, isExpression bool) (*Value, error) {
+	line := conditional.LineNumber
+	condition, err := b.expression(conditional.Condition)
+	if err != nil {
+		return nil, err
+	}
+	end := b.function.NewBlock()
+
+	// `if condition { ... }`
+	if body := conditional.Scope(); body != nil {
+		then := b.function.NewBlock()
+		// the false edge gets a block of its own so no edge is critical
+		otherwise := b.function.NewBlock()
+		b.branch(condition, then, otherwise)
+		b.startBlock(then)
+		err = b.scope(body)
+		if err != nil {
+			return nil, err
+		}
+		b.jump(end)
+		b.startBlock(otherwise)
+		b.jump(end)
+		b.startBlock(end)
+		return nil, nil
+	}
+
+	if isExpression {
+		b.types[conditional] = conditional.Type
+	}
+
+	// arms are compared against the subject in order, the first match wins
+	hasElse := false
+	for _, arm := range conditional.Arms {
+		b.line = arm.LineNumber
+		var next *Block
+		if arm.Value != nil {
+			value, err := b.expression(arm.Value)
+			if err != nil {
+				return nil, err
+			}
+			matches := b.newValue(OpEq, types.TypeBool, condition, value)
+			body := b.function.NewBlock()
+			next = b.function.NewBlock()
+			b.branch(matches, body, next)
+			b.startBlock(body)
+		}
+
+		if scope := arm.Scope(); scope != nil {
+			if isExpression {
+				b.yields = append(b.yields, yield{conditional: conditional, end: end})
+			}
+			err = b.scope(scope)
+			if err != nil {
+				return nil, err
+			}
+			if isExpression {
+				b.yields = b.yields[:len(b.yields)-1]
+			}
+		} else {
+			result, err := b.expression(arm.Result)
+			if err != nil {
+				return nil, err
+			}
+			if isExpression {
+				b.writeVariable(conditional, conditional.Type, b.current(), result)
+			}
+		}
+		if b.block != nil {
+			b.jump(end)
+		}
+
+		// arms after an else are unreachable
+		if next == nil {
+			hasElse = true
+			break
+		}
+		b.startBlock(next)
+	}
+
+	if !hasElse {
+		if isExpression {
+			// the parser makes sure a conditional used as a value always matches an arm
+			block := b.current()
+			block.Kind = BlockFail
+			block.Message = fmt.Sprintf("no arm of the if at line %d matched", line)
+			block.Line = line
+			b.block = nil
+		} else {
+			b.jump(end)
+		}
+	}
+	b.startBlock(end)
+	if !isExpression {
+		return nil, nil
+	}
+	return b.readVariable(conditional, end), nil
+}
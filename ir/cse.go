@@ -0,0 +1,91 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// dominators returns the immediate dominator of every block but the entry.
+// Blocks are in an order where every jump goes forward, so the predecessors of
+// a block always come before it.
+func dominators(f *Function) map[*Block]*Block {
+	idom := map[*Block]*Block{}
+	depth := map[*Block]int{f.Blocks[0]: 0}
+	for _, block := range f.Blocks[1:] {
+		var dominator *Block
+		for _, pred := range block.Preds {
+			if dominator == nil {
+				dominator = pred
+				continue
+			}
+			dominator = intersect(idom, depth, dominator, pred)
+		}
+		idom[block] = dominator
+		depth[block] = depth[dominator] + 1
+	}
+	return idom
+}
+
+// intersect returns the nearest block that dominates both a and b
+func intersect(idom map[*Block]*Block, depth map[*Block]int, a, b *Block) *Block {
+	for a != b {
+		for depth[a] > depth[b] {
+			a = idom[a]
+		}
+		for depth[b] > depth[a] {
+			b = idom[b]
+		}
+		if a != b {
+			a = idom[a]
+			b = idom[b]
+		}
+	}
+	return a
+}
+
+// key identifies the computation of a value, values with the same key are equal
+func key(v *Value) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s %d %d", v.Op, v.Type, v.Const, v.Index)
+	for _, arg := range v.Args {
+		fmt.Fprintf(&b, " %d", arg.ID)
+	}
+	return b.String()
+}
+
+// eliminateCommonSubexpressions replaces a value with an equal value computed
+// in a block that dominates it
+func eliminateCommonSubexpressions(f *Function) {
+	idom := dominators(f)
+	children := map[*Block][]*Block{}
+	for _, block := range f.Blocks[1:] {
+		children[idom[block]] = append(children[idom[block]], block)
+	}
+
+	available := map[string]*Value{}
+	var visit func(block *Block)
+	visit = func(block *Block) {
+		added := []string{}
+		for _, v := range block.Values {
+			// calls may have side effects, phis depend on where control came from
+			if v.Op == OpCall || v.Op == OpPhi || v.Op == OpParam {
+				continue
+			}
+			k := key(v)
+			if existing, ok := available[k]; ok {
+				ReplaceUses(f, v, existing)
+				continue
+			}
+			available[k] = v
+			added = append(added, k)
+		}
+		for _, child := range children[block] {
+			visit(child)
+		}
+		// values of this block do not dominate its siblings
+		for _, k := range added {
+			delete(available, k)
+		}
+	}
+	visit(f.Blocks[0])
+}
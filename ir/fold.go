@@ -0,0 +1,202 @@
+package ir
+
+import "shake/types"
+
+// propagateCopies makes every use of a copy use the copied value instead. The
+// copies themselves are left for dead code elimination.
+func propagateCopies(f *Function) {
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			switch {
+			case v.Op == OpCopy:
+				ReplaceUses(f, v, v.Args[0])
+			case v.Op == OpPhi && allSame(v.Args):
+				ReplaceUses(f, v, v.Args[0])
+			}
+		}
+	}
+}
+
+func allSame(values []*Value) bool {
+	for _, v := range values {
+		if v != values[0] {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+// wrap truncates a result to the width of its type, like the backends do
+func wrap(t types.Type, value int64) int64 {
+	if t == types.TypeInt64 {
+		return value
+	}
+	return int64(int32(value))
+}
+
+// evaluate computes an operation on constants. Division by zero is not
+// folded, it has to fail when the program runs.
+func evaluate(op Op, t types.Type, left, right int64) (int64, bool) {
+	boolean := func(b bool) (int64, bool) {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+	switch op {
+	case OpAdd:
+		return wrap(t, left+right), true
+	case OpSub:
+		return wrap(t, left-right), true
+	case OpMul:
+		return wrap(t, left*right), true
+	case OpDiv:
+		if right == 0 {
+			return 0, false
+		}
+		if right == -1 {
+			return wrap(t, -left), true
+		}
+		return wrap(t, left/right), true
+	case OpEq:
+		return boolean(left == right)
+	case OpNe:
+		return boolean(left != right)
+	case OpLt:
+		return boolean(left < right)
+	case OpLe:
+		return boolean(left <= right)
+	case OpGt:
+		return boolean(left > right)
+	case OpGe:
+		return boolean(left >= right)
+	}
+	return 0, false
+}
+
+// foldConstants computes operations on constants at compile time and turns
+// branches on constants into jumps
+func foldConstants(f *Function) {
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			if !v.Op.IsArithmetic() && !v.Op.IsCompare() {
+				continue
+			}
+			if v.Args[0].Op != OpConst || v.Args[1].Op != OpConst {
+				continue
+			}
+			value, ok := evaluate(v.Op, v.Args[0].Type, v.Args[0].Const, v.Args[1].Const)
+			if !ok {
+				continue
+			}
+			v.Op = OpConst
+			v.Const = value
+			v.Args = nil
+		}
+	}
+
+	branches := false
+	for _, block := range f.Blocks {
+		if block.Kind != BlockIf || block.Control.Op != OpConst {
+			continue
+		}
+		taken, skipped := block.Succs[0], block.Succs[1]
+		if block.Control.Const == 0 {
+			taken, skipped = skipped, taken
+		}
+		removeEdge(block, skipped)
+		block.Kind = BlockPlain
+		block.Control = nil
+		block.Succs = []*Block{taken}
+		branches = true
+	}
+	if branches {
+		removeUnreachable(f)
+	}
+}
+
+// hasSideEffects reports whether a value does more than compute its result
+func hasSideEffects(v *Value) bool {
+	switch v.Op {
+	case OpCall:
+		return true
+	case OpDiv:
+		// dividing by zero fails
+		return v.Args[1].Op != OpConst || v.Args[1].Const == 0
+	}
+	return false
+}
+
+// eliminateDeadCode removes the functions the entry never calls and the values nothing uses
+func eliminateDeadCode(m *Module) {
+	removeUncalled(m)
+	for _, f := range m.Functions {
+		eliminateDeadValues(f)
+	}
+}
+
+// removeUncalled removes the functions no call reachable from the entry calls,
+// which inlining leaves behind. Modules without an entry keep every function.
+func removeUncalled(m *Module) {
+	if m.Function(m.Entry) == nil {
+		return
+	}
+	called := map[string]bool{}
+	work := []string{m.Entry}
+	for len(work) > 0 {
+		name := work[len(work)-1]
+		work = work[:len(work)-1]
+		if called[name] {
+			continue
+		}
+		called[name] = true
+		for _, block := range m.Function(name).Blocks {
+			for _, v := range block.Values {
+				if v.Op == OpCall {
+					work = append(work, v.Callee)
+				}
+			}
+		}
+	}
+
+	functions := []*Function{}
+	for _, f := range m.Functions {
+		if called[f.Name] {
+			functions = append(functions, f)
+		}
+	}
+	m.Functions = functions
+}
+
+// eliminateDeadValues removes the values nothing uses
+func eliminateDeadValues(f *Function) {
+	for {
+		uses := map[*Value]int{}
+		for _, block := range f.Blocks {
+			for _, v := range block.Values {
+				for _, arg := range v.Args {
+					uses[arg]++
+				}
+			}
+			if block.Control != nil {
+				uses[block.Control]++
+			}
+		}
+
+		removed := false
+		for _, block := range f.Blocks {
+			values := block.Values[:0]
+			for _, v := range block.Values {
+				if uses[v] == 0 && !hasSideEffects(v) {
+					removed = true
+					continue
+				}
+				values = append(values, v)
+			}
+			block.Values = values
+		}
+		if !removed {
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ir
+
+// inlineLimit is the most values a function can have to be inlined
+const inlineLimit = 8
+
+// inlinable reports whether calls to f can be replaced by its body. Only
+// small functions without branches are inlined.
+func inlinable(f *Function) bool {
+	if len(f.Blocks) != 1 {
+		return false
+	}
+	block := f.Blocks[0]
+	if block.Kind != BlockReturn || len(block.Values) > inlineLimit {
+		return false
+	}
+	for _, v := range block.Values {
+		if v.Op == OpCall && v.Callee == f.Name {
+			return false
+		}
+	}
+	return true
+}
+
+// inline replaces calls to small functions with a copy of their body
+func inline(m *Module) {
+	for _, f := range m.Functions {
+		for _, block := range f.Blocks {
+			values := []*Value{}
+			for _, v := range block.Values {
+				callee := (*Function)(nil)
+				if v.Op == OpCall {
+					callee = m.Function(v.Callee)
+				}
+				if callee == nil || callee == f || !inlinable(callee) {
+					values = append(values, v)
+					continue
+				}
+				values = append(values, inlineCall(f, block, v, callee)...)
+			}
+			block.Values = values
+		}
+	}
+}
+
+// inlineCall copies the body of callee into block in place of call, and
+// returns the copied values
+func inlineCall(f *Function, block *Block, call *Value, callee *Function) []*Value {
+	body := callee.Blocks[0]
+	copies := map[*Value]*Value{}
+	values := []*Value{}
+	for _, v := range body.Values {
+		if v.Op == OpParam {
+			copies[v] = call.Args[v.Index]
+			continue
+		}
+		args := make([]*Value, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = copies[arg]
+		}
+		c := &Value{ID: f.nextValue, Op: v.Op, Type: v.Type, Args: args, Const: v.Const, Index: v.Index, Callee: v.Callee, Name: v.Name, Block: block, Line: call.Line}
+		f.nextValue++
+		copies[v] = c
+		values = append(values, c)
+	}
+	if body.Control != nil {
+		result := &Value{ID: f.nextValue, Op: OpCopy, Type: call.Type, Args: []*Value{copies[body.Control]}, Block: block, Line: call.Line}
+		f.nextValue++
+		values = append(values, result)
+		ReplaceUses(f, call, result)
+	}
+	return values
+}
@@ -0,0 +1,250 @@
+// Package ir lowers a parsed program to SSA form, which the C, LLVM, Go, Wasm
+// and amd64 backends generate code from. The bytecode compiler stays on the
+// AST, as the VM runs the whole language. The IR covers the subset of the
+// language the native backends share: int32, int64 and bool values,
+// arithmetic, comparisons, conditionals and calls of top level functions. It
+// has no loops, so its blocks only ever jump forward, and no collections,
+// function values, closures, methods or interfaces; building a program that
+// uses them fails with an error naming the feature.
+package ir
+
+import (
+	"fmt"
+	"io"
+	"shake/types"
+	"strings"
+)
+
+type Op int
+
+const (
+	// Const is the integer constant in Value.Const, bools are 0 or 1
+	OpConst Op = iota
+	// Param is the parameter at Value.Index
+	OpParam
+	// Copy is its only argument, assignments produce copies named after the variable
+	OpCopy
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpEq
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+	// Call calls the top level function Value.Callee with the arguments
+	OpCall
+	// Phi picks the argument of the predecessor control came from
+	OpPhi
+)
+
+var opNames = map[Op]string{
+	OpConst: "const",
+	OpParam: "param",
+	OpCopy:  "copy",
+	OpAdd:   "add",
+	OpSub:   "sub",
+	OpMul:   "mul",
+	OpDiv:   "div",
+	OpEq:    "eq",
+	OpNe:    "ne",
+	OpLt:    "lt",
+	OpLe:    "le",
+	OpGt:    "gt",
+	OpGe:    "ge",
+	OpCall:  "call",
+	OpPhi:   "phi",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// IsCompare reports whether the op compares its two arguments into a bool
+func (op Op) IsCompare() bool {
+	return op >= OpEq && op <= OpGe
+}
+
+// IsArithmetic reports whether the op is integer arithmetic on its two arguments
+func (op Op) IsArithmetic() bool {
+	return op >= OpAdd && op <= OpDiv
+}
+
+type Value struct {
+	ID    int
+	Op    Op
+	Type  types.Type
+	Args  []*Value
+	Const int64
+	Index int
+	// Callee is the function a call calls
+	Callee string
+	// Name is the variable a copy assigns, it is only used in dumps
+	Name  string
+	Block *Block
+	Line  uint64
+}
+
+func (v *Value) String() string {
+	return fmt.Sprintf("v%d", v.ID)
+}
+
+type BlockKind int
+
+const (
+	// BlockPlain jumps to its only successor
+	BlockPlain BlockKind = iota
+	// BlockIf jumps to Succs[0] when Control is true, otherwise to Succs[1]
+	BlockIf
+	// BlockReturn returns Control, which is nil in functions without a value
+	BlockReturn
+	// BlockFail stops the program with Message
+	BlockFail
+)
+
+type Block struct {
+	ID      int
+	Kind    BlockKind
+	Values  []*Value
+	Control *Value
+	Message string
+	Succs   []*Block
+	Preds   []*Block
+	Line    uint64
+}
+
+func (b *Block) String() string {
+	return fmt.Sprintf("b%d", b.ID)
+}
+
+type Function struct {
+	Name   string
+	Params []types.Type
+	Result types.Type
+	// Blocks starts with the entry block, and every jump goes to a later block
+	Blocks []*Block
+	Line   uint64
+
+	nextValue int
+	nextBlock int
+}
+
+type Module struct {
+	Functions []*Function
+	// Entry is the name of the function the program starts at, empty when there is none
+	Entry string
+}
+
+// NewValue creates a value at the end of the block
+func (f *Function) NewValue(b *Block, op Op, t types.Type, args ...*Value) *Value {
+	v := &Value{ID: f.nextValue, Op: op, Type: t, Args: args, Block: b}
+	f.nextValue++
+	b.Values = append(b.Values, v)
+	return v
+}
+
+// NewBlock creates a block that is not yet part of the function
+func (f *Function) NewBlock() *Block {
+	b := &Block{ID: f.nextBlock}
+	f.nextBlock++
+	return b
+}
+
+// AddEdge makes to a successor of from
+func AddEdge(from, to *Block) {
+	from.Succs = append(from.Succs, to)
+	to.Preds = append(to.Preds, from)
+}
+
+// Function returns the function with the given name, or nil
+func (m *Module) Function(name string) *Function {
+	for _, f := range m.Functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Write dumps the module in its textual form
+func (m *Module) Write(w io.Writer) error {
+	for i, f := range m.Functions {
+		if i > 0 {
+			_, err := fmt.Fprintln(w)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := io.WriteString(w, f.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Function) String() string {
+	var b strings.Builder
+	params := make([]string, len(f.Params))
+	for i, param := range f.Params {
+		params[i] = param.String()
+	}
+	fmt.Fprintf(&b, "fn %s(%s): %s {\n", f.Name, strings.Join(params, ", "), f.Result)
+	for _, block := range f.Blocks {
+		b.WriteString(block.String() + ":")
+		if len(block.Preds) > 0 {
+			preds := make([]string, len(block.Preds))
+			for i, pred := range block.Preds {
+				preds[i] = pred.String()
+			}
+			b.WriteString(" <- " + strings.Join(preds, " "))
+		}
+		b.WriteString("\n")
+		for _, v := range block.Values {
+			b.WriteString("  " + v.LongString() + "\n")
+		}
+		b.WriteString("  " + block.controlString() + "\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// LongString describes the value and the instruction that produces it
+func (v *Value) LongString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s = %s", v, v.Type, v.Op)
+	switch v.Op {
+	case OpConst:
+		fmt.Fprintf(&b, " %d", v.Const)
+	case OpParam:
+		fmt.Fprintf(&b, " %d", v.Index)
+	case OpCall:
+		b.WriteString(" " + v.Callee)
+	}
+	for _, arg := range v.Args {
+		b.WriteString(" " + arg.String())
+	}
+	if v.Name != "" {
+		b.WriteString("  ; " + v.Name)
+	}
+	return b.String()
+}
+
+func (b *Block) controlString() string {
+	switch b.Kind {
+	case BlockPlain:
+		return "jump " + b.Succs[0].String()
+	case BlockIf:
+		return fmt.Sprintf("if %s -> %s %s", b.Control, b.Succs[0], b.Succs[1])
+	case BlockReturn:
+		if b.Control == nil {
+			return "return"
+		}
+		return "return " + b.Control.String()
+	case BlockFail:
+		return fmt.Sprintf("fail %q", b.Message)
+	}
+	return "?"
+}
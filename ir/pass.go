@@ -0,0 +1,63 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+)
+
+type Pass struct {
+	Name string
+	Run  func(m *Module)
+}
+
+var (
+	CopyPropagation      = Pass{Name: "copyprop", Run: forEachFunction(propagateCopies)}
+	ConstantFolding      = Pass{Name: "fold", Run: forEachFunction(foldConstants)}
+	DeadCode             = Pass{Name: "dce", Run: eliminateDeadCode}
+	CommonSubexpressions = Pass{Name: "cse", Run: forEachFunction(eliminateCommonSubexpressions)}
+	Inlining             = Pass{Name: "inline", Run: inline}
+)
+
+// Passes returns the pipeline of an optimization level, -O0 runs nothing
+func Passes(level int) []Pass {
+	switch {
+	case level <= 0:
+		return nil
+	case level == 1:
+		return []Pass{CopyPropagation, ConstantFolding, CopyPropagation, DeadCode}
+	}
+	return []Pass{Inlining, CopyPropagation, ConstantFolding, CopyPropagation, CommonSubexpressions, DeadCode}
+}
+
+// PassNames returns the names of every pass, for validating --print-after
+func PassNames() []string {
+	return []string{CopyPropagation.Name, ConstantFolding.Name, DeadCode.Name, CommonSubexpressions.Name, Inlining.Name}
+}
+
+// Optimize runs the passes of the level on the module. When printAfter names
+// a pass the module is written to w every time that pass ran.
+func Optimize(m *Module, level int, printAfter string, w io.Writer) error {
+	for _, pass := range Passes(level) {
+		pass.Run(m)
+		if pass.Name != printAfter {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "; after %s\n", pass.Name)
+		if err != nil {
+			return err
+		}
+		err = m.Write(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachFunction(run func(f *Function)) func(m *Module) {
+	return func(m *Module) {
+		for _, f := range m.Functions {
+			run(f)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ir_test
+
+import (
+	"path/filepath"
+	"shake/codegen/testdata/fixture"
+	"shake/ir"
+	"shake/parser"
+	"strings"
+	"testing"
+)
+
+const repeated = `fn square(a: int32, b: int32): int32 {
+    s = a + b;
+    t = a + b;
+    return s * t;
+}
+`
+
+const callee = `fn double(x: int32): int32 {
+    return x * 2;
+}
+
+fn main(): int32 {
+    return double(21);
+}
+`
+
+// TestPrintAfter optimizes programs and compares what --print-after prints
+// for a pass with what the pass should have done
+func TestPrintAfter(t *testing.T) {
+	main := fixture.ParseFile(t, filepath.Join("..", "main.shk"))
+	tests := []struct {
+		name    string
+		program *parser.NodeProgram
+		level   int
+		pass    string
+		want    string
+	}{
+		{
+			// the return reads the add instead of the copies, the second run sees the folded add
+			name:    "copyprop",
+			program: main,
+			level:   1,
+			pass:    "copyprop",
+			want: `; after copyprop
+fn main(): int32 {
+b0:
+  v0: int32 = const 1
+  v1: int32 = const 2
+  v2: int32 = add v0 v1
+  v3: int32 = copy v2  ; y
+  v4: int32 = copy v2  ; x
+  return v2
+}
+; after copyprop
+fn main(): int32 {
+b0:
+  v0: int32 = const 1
+  v1: int32 = const 2
+  v2: int32 = const 3
+  v3: int32 = copy v2  ; y
+  v4: int32 = copy v2  ; x
+  return v2
+}
+`,
+		},
+		{
+			name:    "fold",
+			program: main,
+			level:   2,
+			pass:    "fold",
+			want: `; after fold
+fn main(): int32 {
+b0:
+  v0: int32 = const 1
+  v1: int32 = const 2
+  v2: int32 = const 3
+  v3: int32 = copy v2  ; y
+  v4: int32 = copy v2  ; x
+  return v2
+}
+`,
+		},
+		{
+			name:    "dce",
+			program: main,
+			level:   2,
+			pass:    "dce",
+			want: `; after dce
+fn main(): int32 {
+b0:
+  v2: int32 = const 3
+  return v2
+}
+`,
+		},
+		{
+			// the second add computes the same as the first, so both copies read the first
+			name:    "cse",
+			program: fixture.ParseString(t, repeated),
+			level:   2,
+			pass:    "cse",
+			want: `; after cse
+fn square(int32, int32): int32 {
+b0:
+  v0: int32 = param 0
+  v1: int32 = param 1
+  v2: int32 = add v0 v1
+  v3: int32 = copy v2  ; s
+  v4: int32 = add v0 v1
+  v5: int32 = copy v2  ; t
+  v6: int32 = mul v2 v2
+  return v6
+}
+`,
+		},
+		{
+			name:    "inline",
+			program: fixture.ParseString(t, callee),
+			level:   2,
+			pass:    "inline",
+			want: `; after inline
+fn double(int32): int32 {
+b0:
+  v0: int32 = param 0
+  v1: int32 = const 2
+  v2: int32 = mul v0 v1
+  return v2
+}
+
+fn main(): int32 {
+b0:
+  v0: int32 = const 21
+  v2: int32 = const 2
+  v3: int32 = mul v0 v2
+  v4: int32 = copy v3
+  return v4
+}
+`,
+		},
+		{
+			// nothing calls the inlined callee anymore
+			name:    "inline and dce",
+			program: fixture.ParseString(t, callee),
+			level:   2,
+			pass:    "dce",
+			want: `; after dce
+fn main(): int32 {
+b0:
+  v3: int32 = const 42
+  return v3
+}
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			module, err := ir.Build(test.program)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var printed strings.Builder
+			err = ir.Optimize(module, test.level, test.pass, &printed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if printed.String() != test.want {
+				t.Errorf("--print-after %s at -O%d printed\n%s\nwant\n%s", test.pass, test.level, printed.String(), test.want)
+			}
+		})
+	}
+}
+
+// TestPrintAfterUnused checks that nothing is printed for a pass the level does not run
+func TestPrintAfterUnused(t *testing.T) {
+	module, err := ir.Build(fixture.ParseString(t, repeated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var printed strings.Builder
+	err = ir.Optimize(module, 1, "cse", &printed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed.Len() > 0 {
+		t.Errorf("-O1 does not run cse but printed\n%s", printed.String())
+	}
+}
@@ -0,0 +1,93 @@
+package ir
+
+// ReplaceUses makes every use of old use new instead and returns the values that used old
+func ReplaceUses(f *Function, old, new *Value) []*Value {
+	users := []*Value{}
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			used := false
+			for i, arg := range v.Args {
+				if arg == old {
+					v.Args[i] = new
+					used = true
+				}
+			}
+			if used {
+				users = append(users, v)
+			}
+		}
+		if block.Control == old {
+			block.Control = new
+		}
+	}
+	return users
+}
+
+// RemoveValue removes a value from its block
+func RemoveValue(v *Value) {
+	values := v.Block.Values
+	for i, other := range values {
+		if other == v {
+			v.Block.Values = append(values[:i:i], values[i+1:]...)
+			return
+		}
+	}
+}
+
+// removeEdge removes the edge from pred to succ along with the phi arguments for it
+func removeEdge(pred, succ *Block) {
+	for i, other := range succ.Preds {
+		if other != pred {
+			continue
+		}
+		succ.Preds = append(succ.Preds[:i:i], succ.Preds[i+1:]...)
+		for _, v := range succ.Values {
+			if v.Op == OpPhi {
+				v.Args = append(v.Args[:i:i], v.Args[i+1:]...)
+			}
+		}
+		break
+	}
+	for i, other := range pred.Succs {
+		if other == succ {
+			pred.Succs = append(pred.Succs[:i:i], pred.Succs[i+1:]...)
+			break
+		}
+	}
+}
+
+// removeUnreachable removes the blocks control never reaches. Phis left with a
+// single argument become copies.
+func removeUnreachable(f *Function) {
+	reachable := map[*Block]bool{}
+	work := []*Block{f.Blocks[0]}
+	for len(work) > 0 {
+		block := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reachable[block] {
+			continue
+		}
+		reachable[block] = true
+		work = append(work, block.Succs...)
+	}
+
+	blocks := []*Block{}
+	for _, block := range f.Blocks {
+		if reachable[block] {
+			blocks = append(blocks, block)
+			continue
+		}
+		for _, succ := range append([]*Block{}, block.Succs...) {
+			removeEdge(block, succ)
+		}
+	}
+	f.Blocks = blocks
+
+	for _, block := range f.Blocks {
+		for _, v := range block.Values {
+			if v.Op == OpPhi && len(v.Args) == 1 {
+				v.Op = OpCopy
+			}
+		}
+	}
+}
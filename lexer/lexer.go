@@ -1,13 +1,20 @@
 package lexer
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"regexp"
+	"iter"
 	"shake/queue"
+	"shake/trace"
+	"slices"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
 )
 
 type TokenType int
@@ -17,21 +24,22 @@ const (
 	TokenOperation
 	TokenKeyword
 	TokenIdentifier
-	TokenIdentifierType
 	TokenNumber
 	TokenPunctuation
 	TokenSemicolon
+	// only returned by LexComments, the parser sees comments as trivia
+	TokenComment
 )
 
 var tokenNames = map[TokenType]string{
-	TokenUnknown:        "Unknown",
-	TokenOperation:      "Operation",
-	TokenKeyword:        "Keyword",
-	TokenIdentifier:     "Identifier",
-	TokenIdentifierType: "Type",
-	TokenNumber:         "Number",
-	TokenPunctuation:    "Punctuation",
-	TokenSemicolon:      "Semicolon",
+	TokenUnknown:     "Unknown",
+	TokenOperation:   "Operation",
+	TokenKeyword:     "Keyword",
+	TokenIdentifier:  "Identifier",
+	TokenNumber:      "Number",
+	TokenPunctuation: "Punctuation",
+	TokenSemicolon:   "Semicolon",
+	TokenComment:     "Comment",
 }
 
 func (tt TokenType) String() string {
@@ -48,6 +56,13 @@ type Token struct {
 	Type       TokenType
 	Value      string
 	LineNumber uint64
+	// characters before the token in its line plus 1
+	Column uint64
+	// Column counted in UTF-16 code units, as editors speaking the LSP count
+	UTF16Column uint64
+	// the whitespace and comments between the previous token and this one,
+	// only kept by a lexer from NewTriviaLexer
+	Leading []Trivia `json:",omitempty"`
 }
 
 func (t Token) GetBinaryPrecedence() (int, error) {
@@ -55,216 +70,462 @@ func (t Token) GetBinaryPrecedence() (int, error) {
 		return 0, errors.New("Not an operation")
 	}
 	switch t.Value {
-	case "+":
-	case "-":
+	case "==", "!=", "<", "<=", ">", ">=":
 		return 0, nil
-	case "*":
-	case "/":
+	case "+", "-":
 		return 1, nil
+	case "*", "/":
+		return 2, nil
 	}
 	return 0, errors.New("Not a supported operation")
 }
 
 // Define the keywords
 var keywords = map[string]TokenType{
-	"if":     TokenKeyword,
-	"for":    TokenKeyword,
-	"fn":     TokenKeyword,
-	"return": TokenKeyword,
+	"if":        TokenKeyword,
+	"for":       TokenKeyword,
+	"fn":        TokenKeyword,
+	"return":    TokenKeyword,
+	"interface": TokenKeyword,
+	"map":       TokenKeyword,
+	"in":        TokenKeyword,
+	"true":      TokenKeyword,
+	"false":     TokenKeyword,
+	"else":      TokenKeyword,
+}
+
+// Diagnostic is a problem in the source that stops the lexer
+type Diagnostic struct {
+	Reason     string
+	LineNumber uint64
+	Column     uint64
+}
+
+func (d *Diagnostic) Error() string {
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Sprintf("%s: %s at line: %d column: %d", c.Sprint("[Lexer Error]"), d.Reason, d.LineNumber, d.Column)
+}
+
+func Error(reason string, line uint64, column uint64) error {
+	trace.Stack()
+	return &Diagnostic{Reason: reason, LineNumber: line, Column: column}
 }
 
-func Lex(reader *bytes.Reader) (*queue.Queue[Token], error) {
+// Lex returns the tokens of the source, comments and whitespace are dropped
+func Lex(reader io.Reader) (*queue.Queue[Token], error) {
 	var tokens []Token
+	l := NewLexer(reader)
+	for token := range l.All() {
+		tokens = append(tokens, token)
+	}
+	if l.Err() != nil {
+		return nil, l.Err()
+	}
+	return queue.NewQueueFromSlice(tokens), nil
+}
+
+// LexComments returns the tokens of the source and separately its line, doc
+// and block comments, which keep their text with the trailing whitespace removed
+func LexComments(reader io.Reader) (*queue.Queue[Token], []Token, error) {
+	var tokens []Token
+	var comments []Token
+	collect := func(trivia []Trivia) {
+		for _, t := range trivia {
+			if t.Comment() {
+				comments = append(comments, Token{Type: TokenComment, Value: t.Value, LineNumber: t.LineNumber, Column: t.Column})
+			}
+		}
+	}
+	l := NewTriviaLexer(reader)
+	for token := range l.All() {
+		collect(token.Leading)
+		tokens = append(tokens, token)
+	}
+	if l.Err() != nil {
+		return nil, nil, l.Err()
+	}
+	collect(l.Trailing())
+	return queue.NewQueueFromSlice(tokens), comments, nil
+}
 
-	// Define the regular expressions for different token types
-	identifierRegexp := regexp.MustCompile(`^[a-zA-Z_]`) // No colon in identifier regex
-	integerRegexp := regexp.MustCompile(`^[0-9]+`)
-	operationRegexp := regexp.MustCompile(`^[\+\-\*/=]`)
-	punctuationRegexp := regexp.MustCompile(`^[\(\)\{\},]`)
+// classes of the ASCII characters a token can start with, the others are
+// classified with the unicode package
+const (
+	classOther byte = iota
+	classSpace
+	// a-z, A-Z and _, which start identifiers
+	classLetter
+	classDigit
+	// + - * / = < > !
+	classOperation
+	// ( ) { } [ ] , : .
+	classPunctuation
+)
 
-	var lineNumber uint64 = 1
-	wasPreviousColon := false
-	for {
-		byteResult, err := reader.ReadByte()
+// classes maps every ASCII character to its class, so the scanner looks it
+// up instead of matching it
+var classes = func() [utf8.RuneSelf]byte {
+	var table [utf8.RuneSelf]byte
+	for _, b := range []byte(" \t\n\v\f\r") {
+		table[b] = classSpace
+	}
+	for b := 'a'; b <= 'z'; b++ {
+		table[b] = classLetter
+		table[b-'a'+'A'] = classLetter
+	}
+	table['_'] = classLetter
+	for b := '0'; b <= '9'; b++ {
+		table[b] = classDigit
+	}
+	for _, b := range []byte("+-*/=<>!") {
+		table[b] = classOperation
+	}
+	for _, b := range []byte("(){}[],:.") {
+		table[b] = classPunctuation
+	}
+	return table
+}()
+
+func class(r rune) byte {
+	if r < utf8.RuneSelf {
+		return classes[r]
+	}
+	if unicode.IsSpace(r) {
+		return classSpace
+	}
+	if isIdentifierStart(r) {
+		return classLetter
+	}
+	return classOther
+}
+
+// characters that are in ID_Start or ID_Continue but whose NFKC forms are not
+// identifiers, XID_Start and XID_Continue leave them out
+var (
+	notXIDStart = []rune{
+		0x037a, 0x0e33, 0x0eb3, 0x309b, 0x309c, 0xfc5e, 0xfc5f, 0xfc60, 0xfc61, 0xfc62, 0xfc63,
+		0xfdfa, 0xfdfb, 0xfe70, 0xfe72, 0xfe74, 0xfe76, 0xfe78, 0xfe7a, 0xfe7c, 0xfe7e, 0xff9e, 0xff9f,
+	}
+	notXIDContinue = []rune{
+		0x037a, 0x309b, 0x309c, 0xfc5e, 0xfc5f, 0xfc60, 0xfc61, 0xfc62, 0xfc63,
+		0xfdfa, 0xfdfb, 0xfe70, 0xfe72, 0xfe74, 0xfe76, 0xfe78, 0xfe7a, 0xfe7c, 0xfe7e,
+	}
+)
+
+// isIdentifierStart reports whether r is `_` or in XID_Start, as Unicode
+// Standard Annex #31 derives it from the general categories
+func isIdentifierStart(r rune) bool {
+	if r < utf8.RuneSelf {
+		return classes[r] == classLetter
+	}
+	return unicode.In(r, unicode.L, unicode.Nl, unicode.Other_ID_Start) &&
+		!unicode.In(r, unicode.Pattern_Syntax, unicode.Pattern_White_Space) &&
+		!slices.Contains(notXIDStart, r)
+}
+
+// isIdentifierContinue reports whether r is in XID_Continue
+func isIdentifierContinue(r rune) bool {
+	if r < utf8.RuneSelf {
+		return classes[r] == classLetter || classes[r] == classDigit
+	}
+	if slices.Contains(notXIDContinue, r) {
+		return false
+	}
+	return isIdentifierStart(r) || slices.Contains(notXIDStart, r) ||
+		(unicode.In(r, unicode.Mn, unicode.Mc, unicode.Nd, unicode.Pc, unicode.Other_ID_Continue) &&
+			!unicode.In(r, unicode.Pattern_Syntax, unicode.Pattern_White_Space))
+}
+
+// counter counts the characters read in the current line, so tokens know their columns
+type counter struct {
+	reader io.RuneScanner
+	// the columns of the next character in runes and in UTF-16 code units, starting at 0
+	column   uint64
+	column16 uint64
+	last     rune
+}
+
+func (c *counter) ReadRune() (rune, int, error) {
+	r, size, err := c.reader.ReadRune()
+	if err == nil {
+		c.column++
+		c.column16 += uint64(utf16.RuneLen(r))
+		c.last = r
+	}
+	return r, size, err
+}
+
+func (c *counter) UnreadRune() error {
+	err := c.reader.UnreadRune()
+	if err == nil {
+		c.column--
+		c.column16 -= uint64(utf16.RuneLen(c.last))
+	}
+	return err
+}
+
+// Lexer reads tokens from a source one at a time, as they are asked for, so
+// the whole source never has to be in memory
+type Lexer struct {
+	reader     *counter
+	lineNumber uint64
+	// whether whitespace and comments are kept as the trivia of the tokens
+	trivia bool
+	// the trivia since the last token
+	leading []Trivia
+	// the text of the token being scanned, reused for every token
+	buffer []byte
+	err    error
+}
+
+// NewLexer returns a lexer reading UTF-8 from reader, which is buffered
+// unless it can already unread runes. Whitespace and comments are dropped.
+func NewLexer(reader io.Reader) *Lexer {
+	scanner, ok := reader.(io.RuneScanner)
+	if !ok {
+		scanner = bufio.NewReader(reader)
+	}
+	return &Lexer{reader: &counter{reader: scanner}, lineNumber: 1}
+}
+
+// NewTriviaLexer returns a lexer that keeps the whitespace and comments
+// before every token as its Leading trivia
+func NewTriviaLexer(reader io.Reader) *Lexer {
+	l := NewLexer(reader)
+	l.trivia = true
+	return l
+}
+
+// Trailing returns the trivia after the last token, once Next returned io.EOF
+func (l *Lexer) Trailing() []Trivia {
+	if l.err != io.EOF {
+		return nil
+	}
+	return l.leading
+}
+
+// Err returns the error that stopped the lexer, nil when the source ended normally
+func (l *Lexer) Err() error {
+	if l.err == io.EOF {
+		return nil
+	}
+	return l.err
+}
+
+// All yields the tokens until the source ends or reading it fails, Err tells the two apart
+func (l *Lexer) All() iter.Seq[Token] {
+	return func(yield func(Token) bool) {
+		for {
+			token, err := l.Next()
+			if err != nil || !yield(token) {
+				return
+			}
+		}
+	}
+}
+
+// read returns the next character and rejects the bytes that are not UTF-8
+func (l *Lexer) read() (rune, error) {
+	r, size, err := l.reader.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r == utf8.RuneError && size == 1 {
+		return 0, Error("invalid UTF-8 encoding", l.lineNumber, l.reader.column)
+	}
+	return r, nil
+}
+
+// newline returns the line ending r starts after reading the rest of it, or ""
+func (l *Lexer) newline(r rune) (string, error) {
+	if r == '\r' {
+		r, err := l.read()
 		if err == io.EOF {
-			break
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if r == '\n' {
+			l.newLine()
+			return "\r\n", nil
+		}
+		err = l.reader.UnreadRune()
+		if err != nil {
+			return "", err
+		}
+	} else if r == '\n' {
+		l.newLine()
+		return "\n", nil
+	}
+	return "", nil
+}
+
+func (l *Lexer) newLine() {
+	l.lineNumber++
+	l.reader.column = 0
+	l.reader.column16 = 0
+}
+
+// Next returns the next token, or io.EOF once the source ends. After an
+// error every call returns it again.
+func (l *Lexer) Next() (Token, error) {
+	if l.err != nil {
+		return Token{}, l.err
+	}
+	token, err := l.next()
+	if err != nil {
+		l.err = err
+	}
+	return token, err
+}
+
+func (l *Lexer) next() (Token, error) {
+	reader := l.reader
+	l.leading = nil
+	for {
+		line, column, column16 := l.lineNumber, reader.column+1, reader.column16+1
+		token := func(tokenType TokenType, value string) (Token, error) {
+			leading := l.leading
+			l.leading = nil
+			return Token{Type: tokenType, Value: value, LineNumber: line, Column: column, UTF16Column: column16, Leading: leading}, nil
 		}
+		r, err := l.read()
 		if err != nil {
-			return nil, err
+			return Token{}, err
 		}
 
-		checkNL := func(b byte) (bool, error) {
-			if b == '\r' {
-				b, err := reader.ReadByte()
+		if r == '/' {
+			next, err := l.read()
+			if err != nil && err != io.EOF {
+				return Token{}, err
+			}
+			if err == nil && next == '/' {
+				err = l.lineComment(line, column)
 				if err != nil {
-					return false, err
-				}
-				if b == '\n' {
-					lineNumber++
-					return true, nil
+					return Token{}, err
 				}
-				err = reader.UnreadByte()
+				continue
+			}
+			if err == nil && next == '*' {
+				err = l.blockComment(line, column)
 				if err != nil {
-					return false, err
+					return Token{}, err
 				}
-			} else if b == '\n' {
-				lineNumber++
-				return true, nil
-			}
-			return false, nil
-		}
-
-		if byteResult == '/' {
-			byteResult, err := reader.ReadByte()
-			if err != nil {
-				return nil, err
+				continue
 			}
-			if byteResult == '/' {
-				for {
-					byteResult, err := reader.ReadByte()
-					if err != nil {
-						return nil, err
-					}
-					shouldContinue, err := checkNL(byteResult)
-					if err != nil {
-						return nil, err
-					}
-					if shouldContinue {
-						break
-					}
+			// a division, the character after it starts the next token
+			if err == nil {
+				err = reader.UnreadRune()
+				if err != nil {
+					return Token{}, err
 				}
-				continue
 			}
 		}
 
-		shouldContinue, err := checkNL(byteResult)
+		newline, err := l.newline(r)
 		if err != nil {
-			return nil, err
+			return Token{}, err
 		}
-		if shouldContinue {
+		if newline != "" {
+			l.add(TriviaNewline, newline, line, column)
 			continue
 		}
 
 		// Match ; (end statement)
-		if byteResult == ';' {
-			tokens = append(tokens, Token{Type: TokenSemicolon, Value: ";", LineNumber: lineNumber})
-			continue
+		if r == ';' {
+			return token(TokenSemicolon, ";")
 		}
 
-		char := string(byteResult)
-
-		// Skip whitespace
-		if unicode.IsSpace(rune(byteResult)) {
-			continue
-		}
-
-		// Match numbers (integers)
-		if integerRegexp.MatchString(char) {
-			// Read number
-			number, err := Scan(reader, integerRegexp, true)
+		kind := class(r)
+		if kind == classSpace {
+			err = l.whitespace(r, line, column)
 			if err != nil {
-				return nil, err
+				return Token{}, err
 			}
-			tokens = append(tokens, Token{Type: TokenNumber, Value: number, LineNumber: lineNumber})
 			continue
 		}
 
-		// Match identifiers (variable names, function names)
-		if identifierRegexp.MatchString(char) {
-			// Read identifier
-			allowedAfterFirst, err := regexp.Compile("^[a-zA-Z0-9_]")
+		switch kind {
+		case classDigit:
+			number, err := l.scan(r, false)
 			if err != nil {
-				return nil, err
+				return Token{}, err
 			}
-			identifier, err := Scan(reader, allowedAfterFirst, true)
+			return token(TokenNumber, number)
+
+		case classLetter:
+			identifier, err := l.scan(r, true)
 			if err != nil {
-				return nil, err
+				return Token{}, err
 			}
-
-			// Check if it's a keyword
 			if tokenType, ok := keywords[identifier]; ok {
-				// It's a keyword
-				tokens = append(tokens, Token{Type: tokenType, Value: identifier, LineNumber: lineNumber})
-			} else {
-				// Regular identifier
-				// if wasBeforeColon true, treat this as type
-				if wasPreviousColon {
-					tokens = append(tokens, Token{Type: TokenIdentifierType, Value: identifier, LineNumber: lineNumber})
-					wasPreviousColon = false
-				} else {
-					tokens = append(tokens, Token{Type: TokenIdentifier, Value: identifier, LineNumber: lineNumber})
-				}
+				return token(tokenType, identifier)
 			}
+			return token(TokenIdentifier, identifier)
 
-			continue
-		}
-
-		// Match operations (+, -, *, /, =)
-		if operationRegexp.MatchString(char) {
-			tokens = append(tokens, Token{Type: TokenOperation, Value: char, LineNumber: lineNumber})
-			continue
-		}
-
-		// Match punctuation (parentheses, braces, semicolons, etc.)
-		if punctuationRegexp.MatchString(char) {
-			tokens = append(tokens, Token{Type: TokenPunctuation, Value: char, LineNumber: lineNumber})
-			continue
-		}
+		// operations (+, -, *, /, =, <, >) and comparisons (==, !=, <=, >=)
+		case classOperation:
+			operation := operations[r]
+			if r == '=' || r == '!' || r == '<' || r == '>' {
+				next, err := l.read()
+				if err == nil && next == '=' {
+					operation += "="
+				} else if err == nil {
+					err = reader.UnreadRune()
+					if err != nil {
+						return Token{}, err
+					}
+				} else if err != io.EOF {
+					return Token{}, err
+				}
+			}
+			return token(TokenOperation, operation)
 
-		// Handle colon (possibly for types)
-		if char == ":" {
-			wasPreviousColon = true
-			continue
+		case classPunctuation:
+			return token(TokenPunctuation, punctuation[r])
 		}
 
 		// If no match, add an unknown token
-		tokens = append(tokens, Token{Type: TokenUnknown, Value: char, LineNumber: lineNumber})
+		return token(TokenUnknown, string(r))
 	}
-
-	return queue.NewQueueFromSlice(tokens), nil
 }
 
-// Scan function to read and match allowed characters for a token
-func Scan(reader *bytes.Reader, allowed *regexp.Regexp, unread bool) (string, error) {
-	if unread {
-		err := reader.UnreadByte()
-		if err != nil {
-			return "", err
-		}
+// operations and punctuation by their character, so their tokens share the strings
+var operations, punctuation = func() (operations [utf8.RuneSelf]string, punctuation [utf8.RuneSelf]string) {
+	for _, b := range []byte("+-*/=<>!") {
+		operations[b] = string(b)
 	}
-	firstByte, err := reader.ReadByte()
-	if err != nil {
-		return "", err
-	}
-	value := string(firstByte)
-	if !allowed.MatchString(value) {
-		err = reader.UnreadByte()
-		if err != nil {
-			return value, err
-		}
-		return value, nil
+	for _, b := range []byte("(){}[],:.") {
+		punctuation[b] = string(b)
 	}
+	return operations, punctuation
+}()
 
-	// Read until the next character is not allowed
-	for reader.Size() != 0 {
-		nextByte, err := reader.ReadByte()
+// scan reads the rest of a number, or of an identifier when letters are
+// allowed, after its first character
+func (l *Lexer) scan(first rune, letters bool) (string, error) {
+	l.buffer = utf8.AppendRune(l.buffer[:0], first)
+	for {
+		r, err := l.read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if err == io.EOF {
-				return value, nil
-			}
-			return value, err
+			return "", err
 		}
-
-		char := string(nextByte)
-		if !allowed.MatchString(char) {
-			err = reader.UnreadByte()
+		done := r >= utf8.RuneSelf || classes[r] != classDigit
+		if letters {
+			done = !isIdentifierContinue(r)
+		}
+		if done {
+			err = l.reader.UnreadRune()
 			if err != nil {
-				return value, err
+				return "", err
 			}
 			break
 		}
-		value += char
+		l.buffer = utf8.AppendRune(l.buffer, r)
 	}
-
-	return value, nil
+	return string(l.buffer), nil
 }
@@ -0,0 +1,204 @@
+package lexer_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"shake/lexer"
+	"strings"
+	"testing"
+)
+
+// lex returns the tokens of source and the error that stopped the lexer
+func lex(newLexer func(io.Reader) *lexer.Lexer, source string) ([]lexer.Token, error) {
+	l := newLexer(strings.NewReader(source))
+	tokens := []lexer.Token{}
+	for token := range l.All() {
+		tokens = append(tokens, token)
+	}
+	return tokens, l.Err()
+}
+
+// position is what the tests compare of a token
+type position struct {
+	Type   lexer.TokenType
+	Value  string
+	Line   uint64
+	Column uint64
+	UTF16  uint64
+}
+
+func positions(tokens []lexer.Token) []position {
+	result := []position{}
+	for _, token := range tokens {
+		result = append(result, position{token.Type, token.Value, token.LineNumber, token.Column, token.UTF16Column})
+	}
+	return result
+}
+
+// generate returns at least size bytes of functions using every kind of token
+func generate(size int) []byte {
+	source := &bytes.Buffer{}
+	for i := 0; source.Len() < size; i++ {
+		fmt.Fprintf(source, `// function_%d doubles its argument
+fn function_%d(value: int32, flag: bool): int32 {
+    /* a block comment */
+    numbers = [4]int32{1, 22, 333, %d};
+    for index, number in numbers {
+        value = value + number * 2 - index / 3;
+    }
+    if value >= 1000 {
+        return value;
+    }
+    return value == { 0: 1 else: 2 };
+}
+
+`, i, i, i)
+	}
+	return source.Bytes()
+}
+
+// TestGenerated checks the source the benchmark reads lexes without errors
+func TestGenerated(t *testing.T) {
+	source := generate(1 << 12)
+	l := lexer.NewLexer(bytes.NewReader(source))
+	count := map[lexer.TokenType]int{}
+	for token := range l.All() {
+		count[token.Type]++
+	}
+	if l.Err() != nil {
+		t.Fatal(l.Err())
+	}
+	for _, tokenType := range []lexer.TokenType{lexer.TokenOperation, lexer.TokenKeyword, lexer.TokenIdentifier, lexer.TokenNumber, lexer.TokenPunctuation, lexer.TokenSemicolon} {
+		if count[tokenType] == 0 {
+			t.Errorf("no %s tokens", tokenType)
+		}
+	}
+	if count[lexer.TokenUnknown] != 0 {
+		t.Errorf("%d unknown tokens", count[lexer.TokenUnknown])
+	}
+}
+
+// TestUnicode checks identifiers take any letter and columns count runes, and
+// code units of UTF-16 for the LSP, where characters outside the BMP take two
+func TestUnicode(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []position
+	}{
+		{"latin", "größe = 1;", []position{
+			{lexer.TokenIdentifier, "größe", 1, 1, 1},
+			{lexer.TokenOperation, "=", 1, 7, 7},
+			{lexer.TokenNumber, "1", 1, 9, 9},
+			{lexer.TokenSemicolon, ";", 1, 10, 10},
+		}},
+		{"greek", "π*r2", []position{
+			{lexer.TokenIdentifier, "π", 1, 1, 1},
+			{lexer.TokenOperation, "*", 1, 2, 2},
+			{lexer.TokenIdentifier, "r2", 1, 3, 3},
+		}},
+		{"astral", "𝑥 + 𝑦\n  z", []position{
+			{lexer.TokenIdentifier, "𝑥", 1, 1, 1},
+			{lexer.TokenOperation, "+", 1, 3, 4},
+			{lexer.TokenIdentifier, "𝑦", 1, 5, 6},
+			{lexer.TokenIdentifier, "z", 2, 3, 3},
+		}},
+		{"crlf", "a\r\nб", []position{
+			{lexer.TokenIdentifier, "a", 1, 1, 1},
+			{lexer.TokenIdentifier, "б", 2, 1, 1},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := lex(lexer.NewLexer, test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := positions(tokens)
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got  %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestPunctuation checks `:` and `.` are tokens of their own wherever they are
+func TestPunctuation(t *testing.T) {
+	tokens, err := lex(lexer.NewLexer, "x: math.Vec;\nfn f(): int32: a.b;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []position{
+		{lexer.TokenIdentifier, "x", 1, 1, 1},
+		{lexer.TokenPunctuation, ":", 1, 2, 2},
+		{lexer.TokenIdentifier, "math", 1, 4, 4},
+		{lexer.TokenPunctuation, ".", 1, 8, 8},
+		{lexer.TokenIdentifier, "Vec", 1, 9, 9},
+		{lexer.TokenSemicolon, ";", 1, 12, 12},
+		{lexer.TokenKeyword, "fn", 2, 1, 1},
+		{lexer.TokenIdentifier, "f", 2, 4, 4},
+		{lexer.TokenPunctuation, "(", 2, 5, 5},
+		{lexer.TokenPunctuation, ")", 2, 6, 6},
+		{lexer.TokenPunctuation, ":", 2, 7, 7},
+		{lexer.TokenIdentifier, "int32", 2, 9, 9},
+		{lexer.TokenPunctuation, ":", 2, 14, 14},
+		{lexer.TokenIdentifier, "a", 2, 16, 16},
+		{lexer.TokenPunctuation, ".", 2, 17, 17},
+		{lexer.TokenIdentifier, "b", 2, 18, 18},
+		{lexer.TokenSemicolon, ";", 2, 19, 19},
+	}
+	got := positions(tokens)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+// TestInvalidUTF8 checks the lexer stops at the first byte that is not UTF-8
+// and reports its line and column
+func TestInvalidUTF8(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   uint64
+		column uint64
+	}{
+		{"start", "\xff", 1, 1},
+		{"identifier", "ab\xffc", 1, 3},
+		{"after unicode", "x = 1;\n  πx\xc3", 2, 5},
+		{"comment", "// \xfe\n", 1, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := lex(lexer.NewLexer, test.source)
+			var diagnostic *lexer.Diagnostic
+			if !errors.As(err, &diagnostic) {
+				t.Fatalf("got %v, want a diagnostic", err)
+			}
+			if diagnostic.Reason != "invalid UTF-8 encoding" || diagnostic.LineNumber != test.line || diagnostic.Column != test.column {
+				t.Errorf("got %q at %d:%d, want invalid UTF-8 encoding at %d:%d", diagnostic.Reason, diagnostic.LineNumber, diagnostic.Column, test.line, test.column)
+			}
+		})
+	}
+}
+
+// BenchmarkLexer reports how many MB/s of source the lexer reads and what it
+// allocates. Compare two versions by running
+//
+//	go test -run NONE -bench Lexer -count 10 ./lexer > old.txt
+//
+// on each and passing both files to benchstat.
+func BenchmarkLexer(b *testing.B) {
+	source := generate(1 << 20)
+	b.SetBytes(int64(len(source)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := lexer.NewLexer(bytes.NewReader(source))
+		for range l.All() {
+		}
+		if l.Err() != nil {
+			b.Fatal(l.Err())
+		}
+	}
+}
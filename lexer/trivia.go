@@ -0,0 +1,218 @@
+package lexer
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TriviaKind int
+
+const (
+	// spaces and tabs
+	TriviaWhitespace TriviaKind = iota
+	// `\n` or `\r\n`
+	TriviaNewline
+	// `// ...` up to the end of the line
+	TriviaComment
+	// `/// ...`, which documents the declaration after it
+	TriviaDocComment
+	// `/* ... */`, which can be nested and span lines
+	TriviaBlockComment
+)
+
+var triviaNames = map[TriviaKind]string{
+	TriviaWhitespace:   "Whitespace",
+	TriviaNewline:      "Newline",
+	TriviaComment:      "Comment",
+	TriviaDocComment:   "DocComment",
+	TriviaBlockComment: "BlockComment",
+}
+
+func (tk TriviaKind) String() string {
+	return triviaNames[tk]
+}
+
+func (tk TriviaKind) MarshalText() ([]byte, error) {
+	return []byte(tk.String()), nil
+}
+
+// Trivia is text between tokens that does not change the program
+type Trivia struct {
+	Kind  TriviaKind
+	Value string
+	// where the trivia starts
+	LineNumber uint64
+	Column     uint64
+}
+
+// Comment reports whether the trivia is a comment of any kind
+func (t Trivia) Comment() bool {
+	return t.Kind == TriviaComment || t.Kind == TriviaDocComment || t.Kind == TriviaBlockComment
+}
+
+// Doc returns the `///` comments right above the token, a line each without
+// the slashes, or "" when there are none. A blank line or another comment
+// between them and the token ends them, and a `///` after the previous token
+// on its line is not one of them.
+func (t Token) Doc() string {
+	lines := []string{}
+	newlines := 0
+	for i := len(t.Leading) - 1; i >= 0; i-- {
+		trivia := t.Leading[i]
+		if trivia.Kind == TriviaWhitespace {
+			continue
+		}
+		if trivia.Kind == TriviaNewline {
+			newlines++
+			if newlines > 1 {
+				break
+			}
+			continue
+		}
+		if trivia.Kind != TriviaDocComment || !t.startsLine(i) {
+			break
+		}
+		newlines = 0
+		lines = append(lines, strings.TrimPrefix(strings.TrimPrefix(trivia.Value, "///"), " "))
+	}
+	slices.Reverse(lines)
+	return strings.Join(lines, "\n")
+}
+
+// startsLine reports whether only whitespace is between the trivia at i and
+// the start of its line
+func (t Token) startsLine(i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		switch t.Leading[j].Kind {
+		case TriviaWhitespace:
+			continue
+		case TriviaNewline:
+			return true
+		}
+		return false
+	}
+	// the trivia right after the previous token is on its line, unless the
+	// file starts with it
+	first := t.Leading[0]
+	return first.LineNumber == 1 && first.Column == 1
+}
+
+// add keeps trivia when the lexer keeps trivia
+func (l *Lexer) add(kind TriviaKind, value string, line uint64, column uint64) {
+	if l.trivia {
+		l.leading = append(l.leading, Trivia{Kind: kind, Value: value, LineNumber: line, Column: column})
+	}
+}
+
+// whitespace reads the spaces after first up to the end of the line
+func (l *Lexer) whitespace(first rune, line uint64, column uint64) error {
+	l.buffer = utf8.AppendRune(l.buffer[:0], first)
+	for {
+		r, err := l.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if r == '\n' || r == '\r' || class(r) != classSpace {
+			err = l.reader.UnreadRune()
+			if err != nil {
+				return err
+			}
+			break
+		}
+		l.buffer = utf8.AppendRune(l.buffer, r)
+	}
+	l.add(TriviaWhitespace, string(l.buffer), line, column)
+	return nil
+}
+
+// lineComment reads a comment after its `//` and the line ending after it
+func (l *Lexer) lineComment(line uint64, column uint64) error {
+	l.buffer = append(l.buffer[:0], "//"...)
+	newline := ""
+	for {
+		r, err := l.read()
+		// a comment can end the file
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		newline, err = l.newline(r)
+		if err != nil {
+			return err
+		}
+		if newline != "" {
+			break
+		}
+		l.buffer = utf8.AppendRune(l.buffer, r)
+	}
+	comment := strings.TrimRightFunc(string(l.buffer), unicode.IsSpace)
+	kind := TriviaComment
+	if strings.HasPrefix(comment, "///") && !strings.HasPrefix(comment, "////") {
+		kind = TriviaDocComment
+	}
+	l.add(kind, comment, line, column)
+	if newline != "" {
+		l.add(TriviaNewline, newline, line, column+uint64(utf8.RuneCount(l.buffer)))
+	}
+	return nil
+}
+
+// blockComment reads a comment after its `/*` up to the `*/` that closes it,
+// the comments nested in it are part of it
+func (l *Lexer) blockComment(line uint64, column uint64) error {
+	l.buffer = append(l.buffer[:0], "/*"...)
+	depth := 1
+	for depth > 0 {
+		r, err := l.read()
+		if err == io.EOF {
+			return Error("unterminated block comment", line, column)
+		}
+		if err != nil {
+			return err
+		}
+		newline, err := l.newline(r)
+		if err != nil {
+			return err
+		}
+		if newline != "" {
+			l.buffer = append(l.buffer, newline...)
+			continue
+		}
+		l.buffer = utf8.AppendRune(l.buffer, r)
+		if r != '*' && r != '/' {
+			continue
+		}
+
+		next, err := l.read()
+		if err == io.EOF {
+			return Error("unterminated block comment", line, column)
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case r == '*' && next == '/':
+			depth--
+		case r == '/' && next == '*':
+			depth++
+		default:
+			// the character can start `*/` or `/*` itself
+			err = l.reader.UnreadRune()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		l.buffer = utf8.AppendRune(l.buffer, next)
+	}
+	l.add(TriviaBlockComment, strings.TrimRightFunc(string(l.buffer), unicode.IsSpace), line, column)
+	return nil
+}
@@ -0,0 +1,189 @@
+package lexer_test
+
+import (
+	"errors"
+	"fmt"
+	"shake/lexer"
+	"strings"
+	"testing"
+)
+
+// TestBlockComments checks a comment nested in a block comment does not end it
+func TestBlockComments(t *testing.T) {
+	tokens, err := lex(lexer.NewTriviaLexer, "/* a /* b */ c */ x\n/* one\n   /* two */\n*/ y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []position{
+		{lexer.TokenIdentifier, "x", 1, 19, 19},
+		{lexer.TokenIdentifier, "y", 4, 4, 4},
+	}
+	if got := positions(tokens); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got  %v\nwant %v", got, want)
+	}
+	if comment := tokens[0].Leading[0]; comment.Kind != lexer.TriviaBlockComment || comment.Value != "/* a /* b */ c */" {
+		t.Errorf("got %+v, want the whole nested comment", comment)
+	}
+	if comment := tokens[1].Leading[1]; comment.Kind != lexer.TriviaBlockComment || comment.Value != "/* one\n   /* two */\n*/" {
+		t.Errorf("got %+v, want the comment over three lines", comment)
+	}
+}
+
+// TestDivision checks a `/` that starts no comment is the operation, which
+// used to swallow the identifier after it
+func TestDivision(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{"a/b", []string{"a", "/", "b"}},
+		{"a / b", []string{"a", "/", "b"}},
+		{"a/ /b", []string{"a", "/", "/", "b"}},
+		{"a//b\nc", []string{"a", "c"}},
+		{"a/*b*/c", []string{"a", "c"}},
+		{"4/2/1", []string{"4", "/", "2", "/", "1"}},
+	}
+	for _, test := range tests {
+		tokens, err := lex(lexer.NewLexer, test.source)
+		if err != nil {
+			t.Errorf("%q: %v", test.source, err)
+			continue
+		}
+		got := []string{}
+		for _, token := range tokens {
+			got = append(got, token.Value)
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%q: got %q, want %q", test.source, got, test.want)
+		}
+	}
+}
+
+// TestDoc checks which `///` comments document the token after them
+func TestDoc(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"lines", "/// one\n///two\nfn", "one\ntwo"},
+		{"indented", "x\n    /// one\n    fn", "one"},
+		{"blank line", "/// one\n\n/// two\nfn", "two"},
+		{"comment between", "/// one\n// two\nfn", ""},
+		{"block comment between", "/// one\n/* two */\nfn", ""},
+		{"four slashes", "//// one\nfn", ""},
+		{"after a token", "x /// one\nfn", ""},
+		{"after a token and above", "x /// one\n/// two\nfn", "two"},
+		{"separated by a blank line", "/// one\n\nfn", ""},
+		{"same line", "/// one\r\nfn", "one"},
+		{"none", "fn", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := lex(lexer.NewTriviaLexer, test.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tokens[len(tokens)-1].Doc(); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestTrivia checks the trivia lexer keeps everything between tokens where it
+// is, so the source can be printed back, and the plain lexer keeps none of it
+func TestTrivia(t *testing.T) {
+	source := "x \t// one\r\n\n  /// two\ny /* three */\n"
+	l := lexer.NewTriviaLexer(strings.NewReader(source))
+	tokens := []lexer.Token{}
+	for token := range l.All() {
+		tokens = append(tokens, token)
+	}
+	if l.Err() != nil {
+		t.Fatal(l.Err())
+	}
+	want := [][]lexer.Trivia{
+		nil,
+		{
+			{Kind: lexer.TriviaWhitespace, Value: " \t", LineNumber: 1, Column: 2},
+			{Kind: lexer.TriviaComment, Value: "// one", LineNumber: 1, Column: 4},
+			{Kind: lexer.TriviaNewline, Value: "\r\n", LineNumber: 1, Column: 10},
+			{Kind: lexer.TriviaNewline, Value: "\n", LineNumber: 2, Column: 1},
+			{Kind: lexer.TriviaWhitespace, Value: "  ", LineNumber: 3, Column: 1},
+			{Kind: lexer.TriviaDocComment, Value: "/// two", LineNumber: 3, Column: 3},
+			{Kind: lexer.TriviaNewline, Value: "\n", LineNumber: 3, Column: 10},
+		},
+	}
+	trailing := []lexer.Trivia{
+		{Kind: lexer.TriviaWhitespace, Value: " ", LineNumber: 4, Column: 2},
+		{Kind: lexer.TriviaBlockComment, Value: "/* three */", LineNumber: 4, Column: 3},
+		{Kind: lexer.TriviaNewline, Value: "\n", LineNumber: 4, Column: 14},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+	text := ""
+	for i, token := range tokens {
+		if fmt.Sprint(token.Leading) != fmt.Sprint(want[i]) {
+			t.Errorf("trivia before %s:\ngot  %+v\nwant %+v", token.Value, token.Leading, want[i])
+		}
+		for _, trivia := range token.Leading {
+			text += trivia.Value
+		}
+		text += token.Value
+	}
+	if fmt.Sprint(l.Trailing()) != fmt.Sprint(trailing) {
+		t.Errorf("trailing trivia:\ngot  %+v\nwant %+v", l.Trailing(), trailing)
+	}
+	for _, trivia := range l.Trailing() {
+		text += trivia.Value
+	}
+	if text != source {
+		t.Errorf("the tokens and trivia give back %q, want %q", text, source)
+	}
+
+	tokens, err := lex(lexer.NewLexer, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if token.Leading != nil {
+			t.Errorf("the plain lexer kept trivia before %s: %+v", token.Value, token.Leading)
+		}
+	}
+}
+
+// TestUnterminated checks a block comment still open when the source ends is
+// reported where it starts
+func TestUnterminated(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		line   uint64
+		column uint64
+	}{
+		{"open", "/* never closed", 1, 1},
+		{"after a token", "x = 1;\n  π /* never", 2, 5},
+		{"nested", "/* a /* b */", 1, 1},
+		{"ends with a star", "/* a *", 1, 1},
+		{"ends with a slash", "/* a /", 1, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := lex(lexer.NewTriviaLexer, test.source)
+			var diagnostic *lexer.Diagnostic
+			if !errors.As(err, &diagnostic) {
+				t.Fatalf("got %v, want a diagnostic", err)
+			}
+			if diagnostic.Reason != "unterminated block comment" || diagnostic.LineNumber != test.line || diagnostic.Column != test.column {
+				t.Errorf("got %q at %d:%d, want unterminated block comment at %d:%d", diagnostic.Reason, diagnostic.LineNumber, diagnostic.Column, test.line, test.column)
+			}
+			// the plain lexer reads comments the same way
+			_, plain := lex(lexer.NewLexer, test.source)
+			if fmt.Sprint(plain) != fmt.Sprint(err) {
+				t.Errorf("the plain lexer returned %v, the trivia lexer %v", plain, err)
+			}
+		})
+	}
+}
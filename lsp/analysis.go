@@ -0,0 +1,450 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"shake/lexer"
+	"shake/parser"
+	"shake/types"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// document is an open file. Positions count UTF-16 code units in a line, as
+// the protocol does, which the lexer gives every token along with its column.
+type document struct {
+	version int
+	lines   []string
+	// the last version of the text that parsed and the lines it had, used to
+	// answer requests while the text does not parse
+	program      *parser.NodeProgram
+	programLines []string
+	declarations map[*parser.NodeTermIdentifier]parser.Reference
+	diagnostics  []Diagnostic
+}
+
+func newDocument(text string, version int) *document {
+	d := &document{}
+	d.update(text, version)
+	return d
+}
+
+// update parses the new text of the document and finds its diagnostics
+func (d *document) update(text string, version int) {
+	d.version = version
+	d.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	d.diagnostics = []Diagnostic{}
+
+	program, warnings, err := parse(text)
+	for _, warning := range warnings {
+		d.diagnostics = append(d.diagnostics, d.diagnostic(warning))
+	}
+	if err != nil {
+		d.diagnostics = append(d.diagnostics, d.diagnostic(err))
+		return
+	}
+	d.program = program
+	d.programLines = d.lines
+	d.declarations = map[*parser.NodeTermIdentifier]parser.Reference{}
+	for _, reference := range program.References() {
+		if reference.Declaration {
+			d.declarations[reference.Identifier] = reference
+		}
+	}
+}
+
+// change applies the changes of the client in order and parses the result
+func (d *document) change(changes []TextDocumentContentChangeEvent, version int) {
+	text := strings.Join(d.lines, "\n")
+	for _, change := range changes {
+		if change.Range == nil {
+			text = change.Text
+			continue
+		}
+		start := offset(text, change.Range.Start)
+		end := max(start, offset(text, change.Range.End))
+		text = text[:start] + change.Text + text[end:]
+	}
+	d.update(text, version)
+}
+
+// offset returns where position is in text, in bytes. A position past the
+// end of its line is the end of the line, past the last line the end of text.
+func offset(text string, position Position) int {
+	start := 0
+	for line := 0; line < position.Line; line++ {
+		newline := strings.IndexByte(text[start:], '\n')
+		if newline < 0 {
+			return len(text)
+		}
+		start += newline + 1
+	}
+	character := 0
+	for i, r := range text[start:] {
+		if r == '\n' || r == '\r' || character >= position.Character {
+			return start + i
+		}
+		character += utf16.RuneLen(r)
+	}
+	return len(text)
+}
+
+// parse parses a program the way the compiler does. The text changes with
+// every key stroke, so the parser sees a lot of broken programs and a panic
+// in it is reported as a diagnostic instead of stopping the server.
+func parse(text string) (program *parser.NodeProgram, warnings []error, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			program, err = nil, fmt.Errorf("the parser failed: %v", r)
+		}
+	}()
+	l := lexer.NewLexer(strings.NewReader(text))
+	p := parser.NewParser(l.All())
+	program, err = p.ParseProgram()
+	// the parser sees the tokens end when the lexer fails
+	if l.Err() != nil {
+		return nil, p.Warnings(), l.Err()
+	}
+	return program, p.Warnings(), err
+}
+
+// diagnostic covers the whole line the error is at, the parser only knows
+// lines, or the character the lexer stopped at
+func (d *document) diagnostic(err error) Diagnostic {
+	diagnostic := Diagnostic{Severity: SeverityError, Source: "shake", Message: err.Error()}
+	var parsed *parser.Diagnostic
+	var lexed *lexer.Diagnostic
+	if errors.As(err, &lexed) {
+		diagnostic.Message = lexed.Reason
+		if lexed.LineNumber > 0 {
+			diagnostic.Range = characterRange(d.lines, int(lexed.LineNumber)-1, int(lexed.Column)-1)
+		}
+	} else if errors.As(err, &parsed) {
+		diagnostic.Message = parsed.Reason
+		if parsed.Warning {
+			diagnostic.Severity = SeverityWarning
+		}
+		if parsed.LineNumber > 0 {
+			diagnostic.Range = lineRange(d.lines, int(parsed.LineNumber)-1)
+		}
+	}
+	return diagnostic
+}
+
+func lineRange(lines []string, line int) Range {
+	length := 0
+	if line < len(lines) {
+		length = utf16Length(lines[line])
+	}
+	return Range{Start: Position{Line: line}, End: Position{Line: line, Character: length}}
+}
+
+// characterRange covers the character at column, counted in runes like the
+// lexer counts them, or the end of the line when the line is shorter
+func characterRange(lines []string, line int, column int) Range {
+	if line >= len(lines) {
+		return Range{Start: Position{Line: line}, End: Position{Line: line}}
+	}
+	start, i := 0, 0
+	for _, r := range lines[line] {
+		if i == column {
+			end := Position{Line: line, Character: start + utf16.RuneLen(r)}
+			return Range{Start: Position{Line: line, Character: start}, End: end}
+		}
+		start += utf16.RuneLen(r)
+		i++
+	}
+	return Range{Start: Position{Line: line, Character: start}, End: Position{Line: line, Character: start}}
+}
+
+func utf16Length(s string) int {
+	length := 0
+	for _, r := range s {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
+
+// toPosition converts a position of the parser, which starts at 1
+func toPosition(position parser.Position) Position {
+	return Position{Line: int(position.Line) - 1, Character: int(position.UTF16Column) - 1}
+}
+
+// fromPosition converts a position of the protocol to one of the parser,
+// which only has the column in UTF-16 code units
+func fromPosition(position Position) parser.Position {
+	return parser.Position{Line: uint64(position.Line) + 1, UTF16Column: uint64(position.Character) + 1}
+}
+
+// before reports whether a comes before b
+func before(a, b parser.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.UTF16Column < b.UTF16Column)
+}
+
+func referenceRange(reference parser.Reference) Range {
+	start := toPosition(reference.Position)
+	end := start
+	end.Character += utf16Length(reference.Identifier.Identifier)
+	return Range{Start: start, End: end}
+}
+
+// referenceAt returns the identifier at position, a cursor right after the
+// identifier is still on it
+func (d *document) referenceAt(position Position) (parser.Reference, bool) {
+	if d.program == nil {
+		return parser.Reference{}, false
+	}
+	for _, reference := range d.program.References() {
+		r := referenceRange(reference)
+		if r.Start.Line == position.Line && r.Start.Character <= position.Character && position.Character <= r.End.Character {
+			return reference, true
+		}
+	}
+	return parser.Reference{}, false
+}
+
+func (d *document) hover(position Position) *Hover {
+	reference, ok := d.referenceAt(position)
+	if !ok {
+		return nil
+	}
+	identifier := reference.Identifier
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: fmt.Sprintf("```shake\n%s: %s\n```", identifier.Identifier, identifier.Type),
+		},
+		Range: referenceRange(reference),
+	}
+}
+
+// definition returns where the identifier at position is declared. Functions
+// of the host are declared outside the source and have no definition.
+func (d *document) definition(uri string, position Position) *Location {
+	reference, ok := d.referenceAt(position)
+	if !ok {
+		return nil
+	}
+	declaration, ok := d.declarations[reference.Identifier]
+	if !ok {
+		return nil
+	}
+	return &Location{URI: uri, Range: referenceRange(declaration)}
+}
+
+func (d *document) references(uri string, position Position, includeDeclaration bool) []Location {
+	locations := []Location{}
+	reference, ok := d.referenceAt(position)
+	if !ok {
+		return locations
+	}
+	for _, other := range d.program.References() {
+		if other.Identifier != reference.Identifier || (other.Declaration && !includeDeclaration) {
+			continue
+		}
+		locations = append(locations, Location{URI: uri, Range: referenceRange(other)})
+	}
+	// assignments are parsed after their value, which uses the variable first
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i].Range.Start, locations[j].Range.Start
+		return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+	})
+	return locations
+}
+
+// completion lists the identifiers visible at position, the innermost first
+func (d *document) completion(position Position) []CompletionItem {
+	items := []CompletionItem{}
+	if d.program == nil {
+		return items
+	}
+	at := fromPosition(position)
+	scopes := []*parser.NodeScope{&d.program.NodeScope}
+	for _, statement := range d.program.Statements() {
+		if function, ok := statement.(*parser.NodeFunction); ok {
+			scopes = append(scopes, enclosing(function.Scope(), at)...)
+		}
+	}
+
+	seen := map[string]bool{}
+	for i := len(scopes) - 1; i >= 0; i-- {
+		for _, identifier := range scopes[i].Identifiers() {
+			if seen[identifier.Identifier] {
+				continue
+			}
+			// variables can only be used after they are declared, functions anywhere
+			declaration, ok := d.declarations[identifier]
+			if ok && i > 0 && !before(declaration.Position, at) {
+				continue
+			}
+			seen[identifier.Identifier] = true
+			kind := CompletionVariable
+			if identifier.Type.Kind() == types.KindFunction {
+				kind = CompletionFunction
+			}
+			items = append(items, CompletionItem{Label: identifier.Identifier, Kind: kind, Detail: identifier.Type.String()})
+		}
+	}
+	return items
+}
+
+// enclosing returns scope and the scopes nested in it that contain position, outermost first
+func enclosing(scope *parser.NodeScope, at parser.Position) []*parser.NodeScope {
+	if scope == nil {
+		return nil
+	}
+	start, end := scope.Range()
+	if !before(start, at) || before(end, at) {
+		return nil
+	}
+	scopes := []*parser.NodeScope{scope}
+	for _, nested := range nestedScopes(scope) {
+		if inner := enclosing(nested, at); len(inner) > 0 {
+			return append(scopes, inner...)
+		}
+	}
+	return scopes
+}
+
+// nestedScopes returns the scopes directly inside the statements of scope
+func nestedScopes(scope *parser.NodeScope) []*parser.NodeScope {
+	scopes := []*parser.NodeScope{}
+	for _, statement := range scope.Statements() {
+		switch statement := statement.(type) {
+		case *parser.NodeAssignment:
+			scopes = append(scopes, expressionScopes(*statement.Expression)...)
+		case *parser.NodeIndexAssignment:
+			scopes = append(scopes, expressionScopes(statement.Target)...)
+			scopes = append(scopes, expressionScopes(statement.Expression)...)
+		case *parser.NodeExpressionStatement:
+			scopes = append(scopes, expressionScopes(statement.Expression)...)
+		case *parser.NodeReturn:
+			scopes = append(scopes, expressionScopes(statement.Value())...)
+		case *parser.NodeConditional:
+			scopes = append(scopes, expressionScopes(statement)...)
+		case *parser.NodeForIn:
+			scopes = append(scopes, expressionScopes(statement.Collection)...)
+			scopes = append(scopes, statement.Scope())
+		}
+	}
+	return scopes
+}
+
+// expressionScopes returns the scopes of the conditionals and functions in an expression
+func expressionScopes(expression parser.NodeExpression) []*parser.NodeScope {
+	scopes := []*parser.NodeScope{}
+	switch expression := expression.(type) {
+	case *parser.NodeExpressionBinary:
+		scopes = append(scopes, expressionScopes(expression.Left)...)
+		scopes = append(scopes, expressionScopes(expression.Right)...)
+	case *parser.NodeExpressionCall:
+		scopes = append(scopes, expressionScopes(expression.Callee)...)
+		for _, argument := range expression.Arguments {
+			scopes = append(scopes, expressionScopes(argument)...)
+		}
+	case *parser.NodeExpressionMethodCall:
+		scopes = append(scopes, expressionScopes(expression.Receiver)...)
+		for _, argument := range expression.Arguments {
+			scopes = append(scopes, expressionScopes(argument)...)
+		}
+	case *parser.NodeExpressionConversion:
+		scopes = append(scopes, expressionScopes(expression.Value)...)
+	case *parser.NodeExpressionIndex:
+		scopes = append(scopes, expressionScopes(expression.Collection)...)
+		scopes = append(scopes, expressionScopes(expression.Index)...)
+	case *parser.NodeExpressionBuiltin:
+		for _, argument := range expression.Arguments {
+			scopes = append(scopes, expressionScopes(argument)...)
+		}
+	case *parser.NodeExpressionCollection:
+		for _, key := range expression.Keys {
+			scopes = append(scopes, expressionScopes(key)...)
+		}
+		for _, element := range expression.Elements {
+			scopes = append(scopes, expressionScopes(element)...)
+		}
+	case *parser.NodeConditional:
+		scopes = append(scopes, expressionScopes(expression.Condition)...)
+		if expression.Scope() != nil {
+			scopes = append(scopes, expression.Scope())
+		}
+		for _, arm := range expression.Arms {
+			if arm.Scope() != nil {
+				scopes = append(scopes, arm.Scope())
+			} else {
+				scopes = append(scopes, expressionScopes(arm.Result)...)
+			}
+		}
+	case *parser.NodeFunction:
+		scopes = append(scopes, expression.Scope())
+	}
+	return scopes
+}
+
+// symbols lists the functions and interfaces of the program. Interfaces and
+// their methods only know their lines, their ranges cover the whole lines.
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	if d.program == nil {
+		return symbols
+	}
+	for _, statement := range d.program.Statements() {
+		switch statement := statement.(type) {
+		case *parser.NodeFunction:
+			// methods are not identifiers, their name is only known by line
+			if statement.Receiver() != nil {
+				_, end := statement.Scope().Range()
+				symbols = append(symbols, DocumentSymbol{
+					Name:   statement.QualifiedName(),
+					Detail: statement.GetType().String(),
+					Kind:   SymbolMethod,
+					Range: Range{
+						Start: Position{Line: int(statement.Line()) - 1},
+						End:   Position{Line: int(end.Line) - 1, Character: int(end.UTF16Column)},
+					},
+					SelectionRange: lineRange(d.programLines, int(statement.Line())-1),
+				})
+				continue
+			}
+			declaration, ok := d.declarations[d.program.Identifier(statement.Name())]
+			if !ok {
+				continue
+			}
+			_, end := statement.Scope().Range()
+			selection := referenceRange(declaration)
+			symbols = append(symbols, DocumentSymbol{
+				Name:   statement.Name(),
+				Detail: statement.GetType().String(),
+				Kind:   SymbolFunction,
+				Range: Range{
+					Start: Position{Line: selection.Start.Line},
+					End:   Position{Line: int(end.Line) - 1, Character: int(end.UTF16Column)},
+				},
+				SelectionRange: selection,
+			})
+		case *parser.NodeInterface:
+			symbol := DocumentSymbol{
+				Name: statement.Name(),
+				Kind: SymbolInterface,
+				Range: Range{
+					Start: Position{Line: int(statement.Line()) - 1},
+					End:   lineRange(d.programLines, int(statement.End())-1).End,
+				},
+				SelectionRange: lineRange(d.programLines, int(statement.Line())-1),
+			}
+			for _, method := range statement.Methods() {
+				line := lineRange(d.programLines, int(method.LineNumber)-1)
+				symbol.Children = append(symbol.Children, DocumentSymbol{
+					Name:           method.Name,
+					Detail:         method.Type.String(),
+					Kind:           SymbolMethod,
+					Range:          line,
+					SelectionRange: line,
+				})
+			}
+			symbols = append(symbols, symbol)
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,241 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// ErrNoShutdown is returned by Run when the client exits or goes away without
+// asking the server to shut down first
+var ErrNoShutdown = errors.New("the client exited without shutting the server down")
+
+// Server answers the requests of one client, a message at a time
+type Server struct {
+	in          *bufio.Reader
+	out         io.Writer
+	documents   map[string]*document
+	initialized bool
+	shutdown    bool
+}
+
+// NewServer returns a server reading messages from in and writing to out, usually standard input and output
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		documents: map[string]*document{},
+	}
+}
+
+// Run serves the client until it sends `exit`
+func (s *Server) Run() error {
+	for {
+		body, err := s.read()
+		if err == io.EOF {
+			return ErrNoShutdown
+		}
+		if err != nil {
+			return err
+		}
+
+		message := request{}
+		err = json.Unmarshal(body, &message)
+		if err != nil {
+			err = s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if message.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		result, failure := s.handle(message)
+		// notifications have no response
+		if message.ID == nil {
+			continue
+		}
+		err = s.reply(message.ID, result, failure)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read returns the content of the next message, after its headers
+func (s *Server) read() ([]byte, error) {
+	headers, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length: %q", headers.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(s.in, body)
+	return body, err
+}
+
+func (s *Server) write(message any) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *Server) reply(id *json.RawMessage, result any, failure *responseError) error {
+	if failure != nil {
+		return s.write(response{JSONRPC: "2.0", ID: id, Error: failure})
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.write(response{JSONRPC: "2.0", ID: id, Result: encoded})
+}
+
+func (s *Server) notify(method string, params any) error {
+	return s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle runs a request or notification and returns its result
+func (s *Server) handle(message request) (any, *responseError) {
+	if !s.initialized && message.Method != "initialize" {
+		return nil, &responseError{Code: codeNotInitialized, Message: "the server is not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+
+	switch message.Method {
+	case "initialize":
+		s.initialized = true
+		result := initializeResult{}
+		result.Capabilities.TextDocumentSync = 2
+		result.Capabilities.HoverProvider = true
+		result.Capabilities.DefinitionProvider = true
+		result.Capabilities.ReferencesProvider = true
+		result.Capabilities.DocumentSymbolProvider = true
+		result.Capabilities.CompletionProvider.TriggerCharacters = []string{}
+		result.ServerInfo.Name = "shake"
+		return result, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		params := DidOpenTextDocumentParams{}
+		if failure := decode(message.Params, &params); failure != nil {
+			return nil, failure
+		}
+		d := newDocument(params.TextDocument.Text, params.TextDocument.Version)
+		s.documents[params.TextDocument.URI] = d
+		return nil, s.publish(params.TextDocument.URI, d)
+	case "textDocument/didChange":
+		params := DidChangeTextDocumentParams{}
+		if failure := decode(message.Params, &params); failure != nil {
+			return nil, failure
+		}
+		d, ok := s.documents[params.TextDocument.URI]
+		if !ok || len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		d.change(params.ContentChanges, params.TextDocument.Version)
+		return nil, s.publish(params.TextDocument.URI, d)
+	case "textDocument/didClose":
+		params := DidCloseTextDocumentParams{}
+		if failure := decode(message.Params, &params); failure != nil {
+			return nil, failure
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, s.publish(params.TextDocument.URI, &document{diagnostics: []Diagnostic{}})
+
+	case "textDocument/hover":
+		params := TextDocumentPositionParams{}
+		d, failure := s.document(message.Params, &params, &params.TextDocument)
+		if failure != nil {
+			return nil, failure
+		}
+		return d.hover(params.Position), nil
+	case "textDocument/definition":
+		params := TextDocumentPositionParams{}
+		d, failure := s.document(message.Params, &params, &params.TextDocument)
+		if failure != nil {
+			return nil, failure
+		}
+		return d.definition(params.TextDocument.URI, params.Position), nil
+	case "textDocument/references":
+		params := ReferenceParams{}
+		d, failure := s.document(message.Params, &params, &params.TextDocument)
+		if failure != nil {
+			return nil, failure
+		}
+		return d.references(params.TextDocument.URI, params.Position, params.Context.IncludeDeclaration), nil
+	case "textDocument/completion":
+		params := TextDocumentPositionParams{}
+		d, failure := s.document(message.Params, &params, &params.TextDocument)
+		if failure != nil {
+			return nil, failure
+		}
+		return d.completion(params.Position), nil
+	case "textDocument/documentSymbol":
+		params := DocumentSymbolParams{}
+		d, failure := s.document(message.Params, &params, &params.TextDocument)
+		if failure != nil {
+			return nil, failure
+		}
+		return d.symbols(), nil
+	}
+
+	// notifications the server does not know are ignored
+	if message.ID == nil {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method: %s is not supported", message.Method)}
+}
+
+func decode(params json.RawMessage, v any) *responseError {
+	err := json.Unmarshal(params, v)
+	if err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// document decodes the params of a request about an open document and returns the document
+func (s *Server) document(params json.RawMessage, v any, identifier *TextDocumentIdentifier) (*document, *responseError) {
+	if failure := decode(params, v); failure != nil {
+		return nil, failure
+	}
+	d, ok := s.documents[identifier.URI]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document: %s is not open", identifier.URI)}
+	}
+	return d, nil
+}
+
+func (s *Server) publish(uri string, d *document) *responseError {
+	err := s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{
+		URI:         uri,
+		Version:     d.version,
+		Diagnostics: d.diagnostics,
+	})
+	if err != nil {
+		return &responseError{Code: codeInvalidRequest, Message: err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package lsp_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"shake/lsp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const source = `fn double(x: int32): int32 {
+    return x + x;
+}
+
+fn main(): int32 {
+    return double(21);
+}
+`
+
+// client talks to a server running in the same process over pipes
+type client struct {
+	t      *testing.T
+	in     *io.PipeWriter
+	out    *bufio.Reader
+	id     int
+	result chan error
+}
+
+func newClient(t *testing.T) *client {
+	serverIn, in := io.Pipe()
+	out, serverOut := io.Pipe()
+	c := &client{t: t, in: in, out: bufio.NewReader(out), result: make(chan error, 1)}
+	go func() {
+		err := lsp.NewServer(serverIn, serverOut).Run()
+		serverOut.Close()
+		c.result <- err
+	}()
+	t.Cleanup(func() { in.Close() })
+	return c
+}
+
+func (c *client) send(message map[string]any) {
+	c.t.Helper()
+	message["jsonrpc"] = "2.0"
+	body, err := json.Marshal(message)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_, err = io.WriteString(c.in, "Content-Length: "+strconv.Itoa(len(body))+"\r\n\r\n"+string(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// receive reads the next message of the server
+func (c *client) receive() map[string]json.RawMessage {
+	c.t.Helper()
+	headers, err := textproto.NewReader(c.out).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, err := strconv.Atoi(headers.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(c.out, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	message := map[string]json.RawMessage{}
+	err = json.Unmarshal(body, &message)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return message
+}
+
+// request sends a request and decodes the result of its response into result
+func (c *client) request(method string, params any, result any) {
+	c.t.Helper()
+	c.id++
+	c.send(map[string]any{"id": c.id, "method": method, "params": params})
+	response := c.receive()
+	if string(response["id"]) != strconv.Itoa(c.id) {
+		c.t.Fatalf("%s: got the response to request %s, want %d", method, response["id"], c.id)
+	}
+	if failure, ok := response["error"]; ok {
+		c.t.Fatalf("%s failed: %s", method, failure)
+	}
+	if result != nil {
+		err := json.Unmarshal(response["result"], result)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *client) notify(method string, params any) {
+	c.t.Helper()
+	c.send(map[string]any{"method": method, "params": params})
+}
+
+// open opens the document and returns the diagnostics the server publishes for it
+func (c *client) open(uri string, text string) []lsp.Diagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "shake", Version: 1, Text: text},
+	})
+	message := c.receive()
+	if string(message["method"]) != `"textDocument/publishDiagnostics"` {
+		c.t.Fatalf("got %s, want diagnostics", message["method"])
+	}
+	params := lsp.PublishDiagnosticsParams{}
+	err := json.Unmarshal(message["params"], &params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if params.URI != uri {
+		c.t.Fatalf("got the diagnostics of %s, want %s", params.URI, uri)
+	}
+	return params.Diagnostics
+}
+
+func position(uri string, line int, character int) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: uri},
+		Position:     lsp.Position{Line: line, Character: character},
+	}
+}
+
+func span(line int, start int, end int) lsp.Range {
+	return lsp.Range{Start: lsp.Position{Line: line, Character: start}, End: lsp.Position{Line: line, Character: end}}
+}
+
+// TestSession runs a whole session, from initialize to exit
+func TestSession(t *testing.T) {
+	c := newClient(t)
+
+	initialized := struct {
+		Capabilities struct {
+			HoverProvider      bool `json:"hoverProvider"`
+			DefinitionProvider bool `json:"definitionProvider"`
+		} `json:"capabilities"`
+	}{}
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, &initialized)
+	if !initialized.Capabilities.HoverProvider || !initialized.Capabilities.DefinitionProvider {
+		t.Errorf("the server does not provide hover and definition: %+v", initialized)
+	}
+	c.notify("initialized", map[string]any{})
+
+	uri := "file:///double.shk"
+	if diagnostics := c.open(uri, source); len(diagnostics) != 0 {
+		t.Errorf("got diagnostics for a valid program: %+v", diagnostics)
+	}
+
+	hover := lsp.Hover{}
+	c.request("textDocument/hover", position(uri, 1, 11), &hover)
+	if !strings.Contains(hover.Contents.Value, "x: int32") || hover.Range != span(1, 11, 12) {
+		t.Errorf("got hover %+v, want x: int32 at 1:11", hover)
+	}
+
+	definitions := map[lsp.Position]lsp.Range{
+		// the parameter used in double
+		{Line: 1, Character: 15}: span(0, 10, 11),
+		// the function called in main
+		{Line: 5, Character: 12}: span(0, 3, 9),
+	}
+	for at, want := range definitions {
+		location := lsp.Location{}
+		c.request("textDocument/definition", position(uri, at.Line, at.Character), &location)
+		if location.URI != uri || location.Range != want {
+			t.Errorf("definition at %+v: got %+v, want %+v", at, location, want)
+		}
+	}
+
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.result; err != nil {
+		t.Errorf("the server exited with: %v", err)
+	}
+}
+
+// TestDiagnostics checks where the errors of the lexer and of the parser are
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+
+	tests := []struct {
+		name string
+		text string
+		want lsp.Range
+	}{
+		// the lexer knows the character
+		{"lexer", "fn main(): int32 {\n    𝑥 /* never closed\n}\n", span(1, 7, 8)},
+		// the parser knows the line
+		{"parser", "fn main(): int32 {\n    return true;\n}\n", span(1, 0, 16)},
+	}
+	// the client fails the test it was made with, so the cases share it
+	for _, test := range tests {
+		diagnostics := c.open("file:///"+test.name+".shk", test.text)
+		if len(diagnostics) != 1 {
+			t.Errorf("%s: got %+v, want one diagnostic", test.name, diagnostics)
+			continue
+		}
+		diagnostic := diagnostics[0]
+		if diagnostic.Range != test.want || diagnostic.Severity != lsp.SeverityError {
+			t.Errorf("%s: got %+v, want an error at %+v", test.name, diagnostic, test.want)
+		}
+		if strings.Contains(diagnostic.Message, "\x1b[") || strings.Contains(diagnostic.Message, "line") {
+			t.Errorf("%s: the message is not only the reason: %q", test.name, diagnostic.Message)
+		}
+	}
+}
+
+// features is a program with a function, a method, an interface and a
+// variable used several times
+const features = `interface Shape {
+    Area(): int32;
+}
+
+fn (side: int32) Area(): int32 {
+    return side * side;
+}
+
+fn double(x: int32): int32 {
+    y = x + x;
+    return y + y - x;
+}
+`
+
+// TestFeatures checks references, completion and the symbols of a document
+func TestFeatures(t *testing.T) {
+	c := newClient(t)
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	uri := "file:///features.shk"
+	if diagnostics := c.open(uri, features); len(diagnostics) != 0 {
+		t.Fatalf("got diagnostics for a valid program: %+v", diagnostics)
+	}
+
+	references := func(line int, character int, declaration bool) []lsp.Range {
+		params := lsp.ReferenceParams{TextDocumentPositionParams: position(uri, line, character)}
+		params.Context.IncludeDeclaration = declaration
+		locations := []lsp.Location{}
+		c.request("textDocument/references", params, &locations)
+		ranges := []lsp.Range{}
+		for _, location := range locations {
+			if location.URI != uri {
+				t.Errorf("got a reference in %s", location.URI)
+			}
+			ranges = append(ranges, location.Range)
+		}
+		return ranges
+	}
+	// y is declared at 9:4 and used twice on the next line
+	want := []lsp.Range{span(9, 4, 5), span(10, 11, 12), span(10, 15, 16)}
+	if got := references(10, 15, true); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("references of y: got %v, want %v", got, want)
+	}
+	if got := references(9, 4, false); fmt.Sprint(got) != fmt.Sprint(want[1:]) {
+		t.Errorf("references of y without its declaration: got %v, want %v", got, want[1:])
+	}
+	if got := references(2, 0, true); len(got) != 0 {
+		t.Errorf("references outside an identifier: got %v", got)
+	}
+
+	completion := func(line int, character int) map[string]lsp.CompletionItem {
+		items := []lsp.CompletionItem{}
+		c.request("textDocument/completion", position(uri, line, character), &items)
+		labels := map[string]lsp.CompletionItem{}
+		for _, item := range items {
+			labels[item.Label] = item
+		}
+		return labels
+	}
+	// before y is declared only the parameter and the function are in scope
+	items := completion(9, 4)
+	if items["x"].Kind != lsp.CompletionVariable || items["x"].Detail != "int32" {
+		t.Errorf("completion of x: got %+v", items["x"])
+	}
+	if items["double"].Kind != lsp.CompletionFunction || items["double"].Detail != "fn(int32): int32" {
+		t.Errorf("completion of double: got %+v", items["double"])
+	}
+	if _, ok := items["y"]; ok {
+		t.Error("y is completed before its declaration")
+	}
+	if _, ok := items["side"]; ok {
+		t.Error("the receiver of another function is completed")
+	}
+	if _, ok := completion(10, 4)["y"]; !ok {
+		t.Error("y is not completed after its declaration")
+	}
+
+	symbols := []lsp.DocumentSymbol{}
+	c.request("textDocument/documentSymbol", lsp.DocumentSymbolParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &symbols)
+	got := []string{}
+	for _, symbol := range symbols {
+		got = append(got, fmt.Sprintf("%s %d %d-%d", symbol.Name, symbol.Kind, symbol.Range.Start.Line, symbol.Range.End.Line))
+		for _, child := range symbol.Children {
+			got = append(got, fmt.Sprintf("  %s %d %d-%d", child.Name, child.Kind, child.Range.Start.Line, child.Range.End.Line))
+		}
+	}
+	wantSymbols := []string{
+		fmt.Sprintf("Shape %d 0-2", lsp.SymbolInterface),
+		fmt.Sprintf("  Area %d 1-1", lsp.SymbolMethod),
+		fmt.Sprintf("int32.Area %d 4-6", lsp.SymbolMethod),
+		fmt.Sprintf("double %d 8-11", lsp.SymbolFunction),
+	}
+	if strings.Join(got, "\n") != strings.Join(wantSymbols, "\n") {
+		t.Errorf("got symbols:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(wantSymbols, "\n"))
+	}
+}
+
+// change sends changes and returns the diagnostics the server publishes
+func (c *client) change(uri string, version int, changes ...lsp.TextDocumentContentChangeEvent) []lsp.Diagnostic {
+	c.t.Helper()
+	c.notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: uri, Version: version},
+		ContentChanges: changes,
+	})
+	message := c.receive()
+	params := lsp.PublishDiagnosticsParams{}
+	err := json.Unmarshal(message["params"], &params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if params.Version != version {
+		c.t.Errorf("got the diagnostics of version %d, want %d", params.Version, version)
+	}
+	return params.Diagnostics
+}
+
+// TestIncrementalChange checks ranged changes are applied in order, with
+// characters counted in UTF-16 like the client counts them
+func TestIncrementalChange(t *testing.T) {
+	c := newClient(t)
+	initialized := struct {
+		Capabilities struct {
+			TextDocumentSync int `json:"textDocumentSync"`
+		} `json:"capabilities"`
+	}{}
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, &initialized)
+	if initialized.Capabilities.TextDocumentSync != 2 {
+		t.Errorf("got sync kind %d, want incremental", initialized.Capabilities.TextDocumentSync)
+	}
+	uri := "file:///double.shk"
+	c.open(uri, source)
+
+	replace := func(r lsp.Range, text string) lsp.TextDocumentContentChangeEvent {
+		return lsp.TextDocumentContentChangeEvent{Range: &r, Text: text}
+	}
+	// `return x + x;` becomes `return true;`, which does not type check
+	if diagnostics := c.change(uri, 2, replace(span(1, 11, 16), "true")); len(diagnostics) != 1 {
+		t.Fatalf("got %+v, want one diagnostic", diagnostics)
+	}
+	// back to an int32, then rename x in two edits, the second on the text
+	// the first made
+	diagnostics := c.change(uri, 3,
+		replace(span(1, 11, 15), "x * 2"),
+		replace(span(0, 10, 11), "𝑥"),
+		replace(span(1, 11, 12), "𝑥"),
+	)
+	if len(diagnostics) != 0 {
+		t.Fatalf("got %+v, want no diagnostics", diagnostics)
+	}
+	hover := lsp.Hover{}
+	c.request("textDocument/hover", position(uri, 1, 11), &hover)
+	if !strings.Contains(hover.Contents.Value, "𝑥: int32") || hover.Range != span(1, 11, 13) {
+		t.Errorf("got hover %+v, want 𝑥: int32 at 1:11", hover)
+	}
+	// the parameter takes two UTF-16 code units, so its `)` is at 19
+	diagnostics = c.change(uri, 4,
+		replace(span(0, 19, 19), ", y: int32"),
+		replace(span(5, 20, 20), ", 1"),
+	)
+	if len(diagnostics) != 0 {
+		t.Fatalf("got %+v, want no diagnostics", diagnostics)
+	}
+	// a change without a range is the whole text
+	if diagnostics := c.change(uri, 5, lsp.TextDocumentContentChangeEvent{Text: "fn main(): int32 {\n    return 1;\n}\n"}); len(diagnostics) != 0 {
+		t.Fatalf("got %+v, want no diagnostics", diagnostics)
+	}
+	symbols := []lsp.DocumentSymbol{}
+	c.request("textDocument/documentSymbol", lsp.DocumentSymbolParams{TextDocument: lsp.TextDocumentIdentifier{URI: uri}}, &symbols)
+	if len(symbols) != 1 || symbols[0].Name != "main" {
+		t.Errorf("got symbols %+v, want main", symbols)
+	}
+}
+
+// TestContentLength checks the server stops at a header it cannot read a body by
+func TestContentLength(t *testing.T) {
+	for _, length := range []string{"-1", "ten", ""} {
+		c := newClient(t)
+		_, err := io.WriteString(c.in, "Content-Length: "+length+"\r\n\r\n{}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = <-c.result
+		if err == nil || !strings.Contains(err.Error(), "invalid Content-Length") {
+			t.Errorf("Content-Length: %q: got %v, want an invalid Content-Length", length, err)
+		}
+	}
+}
@@ -2,44 +2,405 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"shake/ast"
+	"shake/bytecode"
+	"shake/codegen/amd64"
+	"shake/codegen/c"
+	"shake/codegen/golang"
+	"shake/codegen/llvm"
+	"shake/codegen/wasm"
+	formatter "shake/format"
+	"shake/ir"
 	"shake/lexer"
+	"shake/lsp"
 	"shake/options"
 	"shake/parser"
+	"shake/repl"
+	"shake/trace"
+	"shake/vm"
+	"slices"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 )
 
+// exit codes by the kind of failure. `run` exits with the result of the
+// program instead, which can be any of them, so only the error its failures
+// print to stderr tells a failure from a result.
+const (
+	// a file could not be read or written
+	exitFile = 1
+	// the program does not lex, parse or compile
+	exitCompile = 2
+	// the program failed while running
+	exitRuntime = 3
+	// a backend or the tools it runs failed
+	exitBuild = 4
+	// the command line is invalid
+	exitUsage = 5
+	// `fmt --check` found files that are not formatted
+	exitUnformatted = 6
+)
+
 func main() {
-	flags.Parse(&options.Options)
-	programSource, err := os.ReadFile(options.Options.Input)
+	p := flags.NewParser(&options.Options, flags.Default)
+	_, err := p.Parse()
+	if err != nil {
+		// the parser already printed the error or the help
+		if flags.WroteHelp(err) {
+			os.Exit(0)
+		}
+		os.Exit(exitUsage)
+	}
+	trace.Enable(len(options.Options.Verbose) > 0 && options.Options.Verbose[0])
+
+	switch p.Active.Name {
+	case "lex":
+		os.Exit(lex(options.Options.Lex))
+	case "parse":
+		os.Exit(parse(options.Options.Parse))
+	case "check":
+		os.Exit(check(options.Options.Check))
+	case "run":
+		os.Exit(run(options.Options.Run))
+	case "build":
+		os.Exit(build(options.Options.Build))
+	case "fmt":
+		os.Exit(format(options.Options.Fmt))
+	case "lsp":
+		os.Exit(serve())
+	case "repl":
+		os.Exit(interactive())
+	}
+}
+
+// fail prints err and returns the exit code for it
+func fail(code int, err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return code
+}
+
+// readSource reads a whole file, `-` reads standard input
+func readSource(file string) ([]byte, int) {
+	var source []byte
+	var err error
+	if file == "-" {
+		source, err = io.ReadAll(os.Stdin)
+	} else {
+		source, err = os.ReadFile(file)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not read file")
-		os.Exit(1)
+		return nil, fail(exitFile, err)
 	}
-	programReader := bytes.NewReader(programSource)
-	tokens, err := lexer.Lex(programReader)
+	return source, 0
+}
+
+// openSource opens a file for the lexer to read as it goes, `-` streams
+// standard input instead of reading it whole first
+func openSource(file string) (io.ReadCloser, int) {
+	if file == "-" {
+		return io.NopCloser(os.Stdin), 0
+	}
+	f, err := os.Open(file)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not lex file")
-		os.Exit(2)
+		return nil, fail(exitFile, err)
 	}
+	return f, 0
+}
 
-	if options.Options.Lexer {
-		encoder := json.NewEncoder(os.Stdout)
-		encoder.SetIndent("", "  ")
-		encoder.Encode(tokens)
+// lexFailed returns the exit code for the error that stopped the lexer, which
+// is reading the file failing unless the source itself is wrong
+func lexFailed(file string, err error) int {
+	var diagnostic *lexer.Diagnostic
+	if !errors.As(err, &diagnostic) {
+		return fail(exitFile, err)
 	}
+	return fail(exitCompile, fmt.Errorf("could not lex %s: %w", file, err))
+}
 
-	p := parser.NewParser(tokens)
+// parseTokens parses the tokens of a file as the lexer reads them
+func parseTokens(file string, l *lexer.Lexer) (*parser.NodeProgram, int) {
+	p := parser.NewParser(l.All())
 	program, err := p.ParseProgram()
+	// the parser sees the tokens end when the lexer fails
+	if l.Err() != nil {
+		return nil, lexFailed(file, l.Err())
+	}
+	for _, warning := range p.Warnings() {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	if err != nil {
+		return nil, fail(exitCompile, err)
+	}
+	return program, 0
+}
+
+func parseFile(file string) (*parser.NodeProgram, int) {
+	source, code := openSource(file)
+	if code != 0 {
+		return nil, code
+	}
+	defer source.Close()
+	return parseTokens(file, lexer.NewLexer(source))
+}
+
+func lex(command options.Lex) int {
+	source, code := openSource(command.Args.File)
+	if code != 0 {
+		return code
+	}
+	defer source.Close()
+	newLexer := lexer.NewLexer
+	if command.Trivia {
+		newLexer = lexer.NewTriviaLexer
+	}
+	l := newLexer(source)
+	tokens := slices.Collect(l.All())
+	if l.Err() != nil {
+		return lexFailed(command.Args.File, l.Err())
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(tokens)
+	if err != nil {
+		return fail(exitFile, err)
+	}
+	return 0
+}
+
+func parse(command options.Parse) int {
+	source, code := openSource(command.Args.File)
+	if code != 0 {
+		return code
+	}
+	defer source.Close()
+	// the trivia has the doc comments of the declarations
+	program, code := parseTokens(command.Args.File, lexer.NewTriviaLexer(source))
+	if code != 0 {
+		return code
+	}
+	document, err := ast.FromProgram(program)
+	if err != nil {
+		return fail(exitCompile, err)
+	}
+	if command.Format == "json" {
+		err = ast.Encode(os.Stdout, document)
+	} else {
+		err = ast.Print(os.Stdout, document)
+	}
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fail(exitFile, err)
 	}
+	return 0
+}
 
-	if options.Options.Parser {
-		fmt.Println(program)
+// check compiles every file to find their errors, it keeps going after a file fails
+func check(command options.Check) int {
+	code := 0
+	for _, file := range command.Args.Files {
+		program, failed := parseFile(file)
+		if failed == 0 {
+			_, err := bytecode.Compile(program)
+			if err != nil {
+				failed = fail(exitCompile, err)
+			}
+		}
+		if code == 0 {
+			code = failed
+		}
+	}
+	return code
+}
+
+func run(command options.Run) int {
+	program, code := parseFile(command.Args.File)
+	if code != 0 {
+		return code
+	}
+	compiled, err := bytecode.Compile(program)
+	if err != nil {
+		return fail(exitCompile, err)
+	}
+
+	if command.Disassemble {
+		err = bytecode.Disassemble(os.Stdout, compiled)
+		if err != nil {
+			return fail(exitFile, err)
+		}
+		return 0
+	}
+
+	machine := vm.New(compiled)
+	machine.SetLimits(vm.Limits{
+		Steps:  command.MaxSteps,
+		Depth:  command.MaxDepth,
+		Memory: command.MaxMemory,
+	})
+	ctx := context.Background()
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
+	}
+	result, err := machine.RunContext(ctx)
+	if err != nil {
+		return fail(exitRuntime, err)
+	}
+	return int(result)
+}
+
+func build(command options.Build) int {
+	if command.Emit == "" && command.Output == "" {
+		return fail(exitUsage, errors.New("build needs --emit or -o"))
+	}
+	program, code := parseFile(command.Args.File)
+	if code != 0 {
+		return code
+	}
+	// every backend generates code from the optimized IR
+	module, err := buildIR(program, command)
+	if err != nil {
+		return fail(exitBuild, err)
+	}
+
+	if command.Emit != "" {
+		var source string
+		switch command.Emit {
+		case "ir":
+			var b strings.Builder
+			module.Write(&b)
+			source = b.String()
+		case "c":
+			source, err = c.Generate(module)
+		case "go":
+			source, err = golang.Generate(module, command.Package)
+		case "llvm":
+			source, err = llvm.Generate(module)
+		case "asm":
+			source, err = amd64.Generate(module)
+		}
+		if err != nil {
+			return fail(exitBuild, err)
+		}
+		fmt.Print(source)
+	}
+
+	if command.Output != "" {
+		switch command.Backend {
+		case "c":
+			err = c.Build(module, command.Output)
+		case "amd64":
+			err = amd64.Build(module, command.Output)
+		case "wasm":
+			var binary []byte
+			binary, err = wasm.Generate(module)
+			if err == nil {
+				err = os.WriteFile(command.Output, binary, 0o644)
+			}
+		}
+		if err != nil {
+			return fail(exitBuild, err)
+		}
+	}
+	return 0
+}
+
+// buildIR translates the program to SSA and optimizes it at the level given by -O
+func buildIR(program *parser.NodeProgram, command options.Build) (*ir.Module, error) {
+	module, err := ir.Build(program)
+	if err != nil {
+		return nil, err
+	}
+	err = ir.Optimize(module, command.Optimize, command.PrintAfter, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	return module, nil
+}
+
+// format prints files formatted, or with -w, -l, --check or --diff writes or
+// reports the files that are not. It keeps going after a file fails.
+func format(command options.Fmt) int {
+	if command.Write && (command.Check || command.Diff) {
+		return fail(exitUsage, errors.New("fmt cannot write with --check or --diff"))
+	}
+	code := 0
+	unformatted := false
+	for _, file := range command.Args.Files {
+		if command.Write && file == "-" {
+			return fail(exitUsage, errors.New("fmt cannot write standard input back"))
+		}
+		source, failed := readSource(file)
+		if failed != 0 {
+			if code == 0 {
+				code = failed
+			}
+			continue
+		}
+		formatted, err := formatter.Source(source)
+		if err != nil {
+			if code == 0 {
+				code = exitCompile
+			}
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		changed := !bytes.Equal(source, formatted)
+
+		switch {
+		case command.List || command.Check || command.Diff:
+			if !changed {
+				continue
+			}
+			unformatted = true
+			if command.List || command.Check {
+				fmt.Println(file)
+			}
+			if command.Diff {
+				fmt.Print(formatter.Diff(file, source, formatted))
+			}
+		case command.Write:
+			if !changed {
+				continue
+			}
+			err = os.WriteFile(file, formatted, 0o644)
+			if err != nil && code == 0 {
+				code = fail(exitFile, err)
+			}
+		default:
+			_, err = os.Stdout.Write(formatted)
+			if err != nil && code == 0 {
+				code = fail(exitFile, err)
+			}
+		}
+	}
+	if code == 0 && command.Check && unformatted {
+		code = exitUnformatted
+	}
+	return code
+}
+
+// serve runs the language server until the editor stops it
+func serve() int {
+	err := lsp.NewServer(os.Stdin, os.Stdout).Run()
+	if err != nil {
+		// the protocol asks for 1 when the server exits without a shutdown
+		return fail(exitFile, err)
+	}
+	return 0
+}
+
+// interactive runs the REPL on the terminal
+func interactive() int {
+	err := repl.Run(os.Stdin, os.Stdout)
+	if err != nil {
+		return fail(exitFile, err)
 	}
+	return 0
 }
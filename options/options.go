@@ -1,8 +1,71 @@
 package options
 
+import "time"
+
+// Input is the file a command reads, `-` reads standard input
+type Input struct {
+	File string `positional-arg-name:"file" description:"Shake source file, - for standard input"`
+}
+
+// Inputs are the files a command reads, `-` reads standard input
+type Inputs struct {
+	Files []string `positional-arg-name:"files" description:"Shake source files, - for standard input"`
+}
+
+type Lex struct {
+	Trivia bool  `long:"trivia" description:"Print the whitespace and comments before every token"`
+	Args   Input `positional-args:"yes" required:"yes"`
+}
+
+type Parse struct {
+	Format string `long:"format" choice:"text" choice:"json" default:"text" description:"Print the tree as text or as JSON following ast/SCHEMA.md"`
+	Args   Input  `positional-args:"yes" required:"yes"`
+}
+
+type Check struct {
+	Args Inputs `positional-args:"yes" required:"yes"`
+}
+
+type Run struct {
+	Disassemble bool          `short:"d" long:"disassemble" description:"Print the compiled bytecode instead of running it"`
+	MaxSteps    int64         `long:"max-steps" description:"Stop after this many VM instructions"`
+	MaxDepth    int           `long:"max-depth" description:"Stop when calls nest deeper than this"`
+	MaxMemory   int64         `long:"max-memory" description:"Stop after allocating this many bytes"`
+	Timeout     time.Duration `long:"timeout" description:"Stop after this long, e.g. 500ms"`
+	Args        Input         `positional-args:"yes" required:"yes"`
+}
+
+type Build struct {
+	Emit       string `long:"emit" choice:"ir" choice:"c" choice:"llvm" choice:"asm" choice:"go" description:"Print the generated code"`
+	Output     string `short:"o" long:"output" description:"Build an executable"`
+	Backend    string `long:"backend" choice:"c" choice:"amd64" choice:"wasm" default:"c" description:"How -o builds the program: with the system C compiler, with as and ld, or as a WebAssembly module"`
+	Optimize   int    `short:"O" long:"optimize" choice:"0" choice:"1" choice:"2" default:"0" description:"Optimization level of the IR every backend generates code from"`
+	PrintAfter string `long:"print-after" choice:"copyprop" choice:"fold" choice:"dce" choice:"cse" choice:"inline" description:"Print the IR to stderr every time the pass runs"`
+	Package    string `long:"package" default:"shake" description:"Package name of the code generated by --emit go"`
+	Args       Input  `positional-args:"yes" required:"yes"`
+}
+
+type Fmt struct {
+	Write bool   `short:"w" long:"write" description:"Write the result to the file instead of standard output"`
+	List  bool   `short:"l" long:"list" description:"Only list the files whose formatting differs"`
+	Check bool   `long:"check" description:"List the files whose formatting differs and fail if there are any"`
+	Diff  bool   `short:"d" long:"diff" description:"Print the changes formatting would make as a unified diff"`
+	Args  Inputs `positional-args:"yes" required:"yes"`
+}
+
+type Lsp struct{}
+
+type Repl struct{}
+
 var Options struct {
 	Verbose []bool `short:"v" long:"verbose" description:"Show verbose debug information"`
-	Lexer   bool   `short:"l" long:"lexer" description:"Show the lexer output"`
-	Parser  bool   `short:"p" long:"parser" description:"Show the parser output"`
-	Input   string `short:"i" long:"input" description:"input shk file"`
+
+	Lex   Lex   `command:"lex" description:"Print the tokens of a file as JSON"`
+	Parse Parse `command:"parse" description:"Print the syntax tree of a file"`
+	Check Check `command:"check" description:"Report the errors and warnings of files"`
+	Run   Run   `command:"run" description:"Run a file on the bytecode VM, its result is the exit code" long-description:"Run a file on the bytecode VM and exit with the low byte of its result. A result can be any exit code, so 2 and 3 do not always mean a compile or runtime failure: a failure also prints an error to standard error, where a result prints at most warnings."`
+	Build Build `command:"build" description:"Print generated code with --emit or build an executable with -o"`
+	Fmt   Fmt   `command:"fmt" description:"Format files"`
+	Lsp   Lsp   `command:"lsp" description:"Run the language server on standard input and output"`
+	Repl  Repl  `command:"repl" description:"Evaluate expressions and statements interactively"`
 }
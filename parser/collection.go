@@ -0,0 +1,453 @@
+package parser
+
+import (
+	"fmt"
+	"shake/lexer"
+	"shake/types"
+	"strconv"
+)
+
+// NodeExpressionCollection is an array, slice or map literal, e.g. `[]int32{1, 2}`
+type NodeExpressionCollection struct {
+	Type types.Type
+	// only set for map literals, Keys[i] maps to Elements[i]
+	Keys     []NodeExpression
+	Elements []NodeExpression
+	Span     Span
+}
+
+func (nec NodeExpressionCollection) GetType() types.Type {
+	return nec.Type
+}
+
+func (nec NodeExpressionCollection) GetSpan() Span {
+	return nec.Span
+}
+
+// NodeExpressionIndex is an index into a collection, e.g. `xs[0]` or `ages[id]`
+type NodeExpressionIndex struct {
+	Type       types.Type
+	Collection NodeExpression
+	Index      NodeExpression
+	// kept so an out of bounds access can be reported at runtime
+	LineNumber uint64
+	Span       Span
+}
+
+func (nei NodeExpressionIndex) GetType() types.Type {
+	return nei.Type
+}
+
+func (nei NodeExpressionIndex) GetSpan() Span {
+	return nei.Span
+}
+
+// NodeIndexAssignment sets an element of a collection, e.g. `xs[0] = 1;` or
+// `ages[id] = 30;`, which adds the key to a map when it is not in it yet
+type NodeIndexAssignment struct {
+	Target     *NodeExpressionIndex
+	Expression NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+// NodeExpressionBuiltin is a call to a builtin function such as `len` or `append`
+type NodeExpressionBuiltin struct {
+	Type       types.Type
+	Name       string
+	Arguments  []NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+func (neb NodeExpressionBuiltin) GetType() types.Type {
+	return neb.Type
+}
+
+func (neb NodeExpressionBuiltin) GetSpan() Span {
+	return neb.Span
+}
+
+// NodeForIn iterates over a collection, e.g. `for i, x in xs { ... }`
+type NodeForIn struct {
+	// Key is empty when only the value is bound
+	Key        string
+	Value      string
+	Collection NodeExpression
+	scope      *NodeScope
+	LineNumber uint64
+	Span       Span
+}
+
+func (nfi *NodeForIn) Scope() *NodeScope {
+	return nfi.scope
+}
+
+var builtins = map[string]bool{
+	"len":    true,
+	"append": true,
+}
+
+func (p *Parser) parseCollectionLiteral() (*NodeExpressionCollection, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("collection literal but found nothing", 0)
+	}
+	collectionType, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	if !collectionType.IsCollection() {
+		return nil, ExpectedError(fmt.Sprintf("collection type but found: %s", collectionType), token.LineNumber)
+	}
+	err = p.expectPunctuation("{")
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &NodeExpressionCollection{
+		Type:     collectionType,
+		Elements: []NodeExpression{},
+	}
+	if collectionType.Kind() == types.KindMap {
+		collection.Keys = []NodeExpression{}
+	}
+
+	// the constant keys already in the literal
+	seen := map[string]bool{}
+	// parse elements until `}`, separated by `,`
+	for {
+		currToken, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`}` but found nothing", token.LineNumber)
+		}
+		if currToken.Type == lexer.TokenPunctuation && currToken.Value == "}" {
+			p.tokens.Pop()
+			break
+		}
+
+		if collectionType.Kind() == types.KindMap {
+			// `key: value`
+			key, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if key.GetType() != collectionType.Key() {
+				return nil, Error(fmt.Sprintf("Mismatched type of map key: %s expected: %s", key.GetType(), collectionType.Key()), currToken.LineNumber)
+			}
+			if constant, ok := armKey(key); ok {
+				if seen[constant] {
+					p.warn(fmt.Sprintf("Duplicate map key: %s replaces the element before it", constant), currToken.LineNumber)
+				}
+				seen[constant] = true
+			}
+			collection.Keys = append(collection.Keys, key)
+			err = p.expectPunctuation(":")
+			if err != nil {
+				return nil, err
+			}
+		}
+		element, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		element, err = p.convert(element, collectionType.Elem(), currToken.LineNumber)
+		if err != nil {
+			return nil, err
+		}
+		if element.GetType() != collectionType.Elem() {
+			return nil, Error(fmt.Sprintf("Mismatched type of element: %s expected: %s", element.GetType(), collectionType.Elem()), currToken.LineNumber)
+		}
+		collection.Elements = append(collection.Elements, element)
+
+		// consume `,` unless this is the last element
+		currToken, err = p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`}` but found nothing", token.LineNumber)
+		}
+		if currToken.Type == lexer.TokenPunctuation && currToken.Value == "," {
+			p.tokens.Pop()
+			continue
+		}
+		err = expectToken(currToken, lexer.Token{Type: lexer.TokenPunctuation, Value: "}"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if collectionType.Kind() == types.KindArray && len(collection.Elements) > collectionType.Len() {
+		return nil, Error(fmt.Sprintf("Too many elements: %d for array of type: %s", len(collection.Elements), collectionType), token.LineNumber)
+	}
+
+	return collection, nil
+}
+
+// parseIndexAssignment parses `xs[i] = value;`
+func (p *Parser) parseIndexAssignment() (*NodeIndexAssignment, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("statement but found nothing", 0)
+	}
+	line := token.LineNumber
+	operand, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	target, ok := operand.(*NodeExpressionIndex)
+	if !ok {
+		return nil, Error("Only variables and elements of collections can be assigned", line)
+	}
+
+	// consume the `=`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`=` but found nothing", line)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenOperation, Value: "="})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	expression, err = p.convert(expression, target.Type, line)
+	if err != nil {
+		return nil, err
+	}
+	if expression.GetType() != target.Type {
+		return nil, Error(fmt.Sprintf("Mismatched type when assigning element of type %s and expression of type %s", target.Type, expression.GetType()), line)
+	}
+
+	// consume the `;`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`;` but found nothing", line)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenSemicolon})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	return &NodeIndexAssignment{
+		Target:     target,
+		Expression: expression,
+		LineNumber: line,
+	}, nil
+}
+
+// parseIndex parses `[index]` after a collection expression
+func (p *Parser) parseIndex(collection NodeExpression) (*NodeExpressionIndex, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`[` but found nothing", 0)
+	}
+	err = p.expectPunctuation("[")
+	if err != nil {
+		return nil, err
+	}
+
+	collectionType := collection.GetType()
+	if !collectionType.IsCollection() {
+		return nil, Error(fmt.Sprintf("Cannot index into type: %s", collectionType), token.LineNumber)
+	}
+
+	index, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	switch collectionType.Kind() {
+	case types.KindMap:
+		if index.GetType() != collectionType.Key() {
+			return nil, Error(fmt.Sprintf("Mismatched type of map key: %s expected: %s", index.GetType(), collectionType.Key()), token.LineNumber)
+		}
+	default:
+		if !index.GetType().IsInteger() {
+			return nil, Error(fmt.Sprintf("Index must be an integer but found: %s", index.GetType()), token.LineNumber)
+		}
+	}
+
+	// constant indexes can be checked now, negative ones against any array or slice
+	if literal, ok := index.(NodeExpressionLiteral); ok && collectionType.Kind() != types.KindMap {
+		if term, ok := literal.Value.(NodeTermInt32); ok {
+			value, err := strconv.Atoi(term.Value)
+			if err == nil && value < 0 {
+				return nil, Error(fmt.Sprintf("Index: %d is negative", value), token.LineNumber)
+			}
+			if err == nil && collectionType.Kind() == types.KindArray && value >= collectionType.Len() {
+				return nil, Error(fmt.Sprintf("Index: %d out of bounds for array of type: %s", value, collectionType), token.LineNumber)
+			}
+		}
+	}
+
+	err = p.expectPunctuation("]")
+	if err != nil {
+		return nil, err
+	}
+
+	return &NodeExpressionIndex{
+		Type:       collectionType.Elem(),
+		Collection: collection,
+		Index:      index,
+		LineNumber: token.LineNumber,
+	}, nil
+}
+
+func (p *Parser) parseBuiltin() (*NodeExpressionBuiltin, error) {
+	name := p.tokens.Pop()
+	err := p.expectPunctuation("(")
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := []NodeExpression{}
+	for {
+		token, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`)` but found nothing", name.LineNumber)
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == ")" {
+			p.tokens.Pop()
+			break
+		}
+		if len(arguments) > 0 {
+			err = p.expectPunctuation(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		argument, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+
+	builtin := &NodeExpressionBuiltin{
+		Name:       name.Value,
+		Arguments:  arguments,
+		LineNumber: name.LineNumber,
+	}
+	switch name.Value {
+	case "len":
+		if len(arguments) != 1 || !arguments[0].GetType().IsCollection() {
+			return nil, Error("len expects a single array, slice or map", name.LineNumber)
+		}
+		builtin.Type = types.TypeInt32
+	case "append":
+		if len(arguments) < 2 || arguments[0].GetType().Kind() != types.KindSlice {
+			return nil, Error("append expects a slice followed by the elements to append", name.LineNumber)
+		}
+		sliceType := arguments[0].GetType()
+		for i, argument := range arguments[1:] {
+			argument, err := p.convert(argument, sliceType.Elem(), name.LineNumber)
+			if err != nil {
+				return nil, err
+			}
+			if argument.GetType() != sliceType.Elem() {
+				return nil, Error(fmt.Sprintf("Cannot append: %s to slice of type: %s", argument.GetType(), sliceType), name.LineNumber)
+			}
+			builtin.Arguments[i+1] = argument
+		}
+		builtin.Type = sliceType
+	}
+
+	return builtin, nil
+}
+
+func (p *Parser) parseForIn() (*NodeForIn, error) {
+	// consume the `for` keyword
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("statement but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenKeyword, Value: "for"})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	// `for x in` or `for i, x in`
+	names := []*lexer.Token{}
+	for {
+		nameToken, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("identifier but found nothing", token.LineNumber)
+		}
+		err = expectToken(nameToken, lexer.Token{Type: lexer.TokenIdentifier})
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, p.tokens.Pop())
+
+		nextToken, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`in` but found nothing", token.LineNumber)
+		}
+		if len(names) == 1 && nextToken.Type == lexer.TokenPunctuation && nextToken.Value == "," {
+			p.tokens.Pop()
+			continue
+		}
+		err = expectToken(nextToken, lexer.Token{Type: lexer.TokenKeyword, Value: "in"})
+		if err != nil {
+			return nil, err
+		}
+		p.tokens.Pop()
+		break
+	}
+
+	collection, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	collectionType := collection.GetType()
+	if !collectionType.IsCollection() {
+		return nil, Error(fmt.Sprintf("Cannot iterate over type: %s", collectionType), token.LineNumber)
+	}
+
+	forIn := &NodeForIn{
+		Collection: collection,
+		LineNumber: token.LineNumber,
+	}
+	// the loop variables only exist inside the body
+	scope := p.newScope(p.program.CurrentScope.returnType)
+	// the key of an array or slice is its index
+	keyType := types.TypeInt32
+	if collectionType.Kind() == types.KindMap {
+		keyType = collectionType.Key()
+	}
+	var keyToken, valueToken *lexer.Token
+	switch {
+	case len(names) == 2:
+		keyToken, valueToken = names[0], names[1]
+	case collectionType.Kind() == types.KindMap:
+		// a single name iterates over the keys of a map
+		keyToken = names[0]
+	default:
+		// and over the elements of an array or slice
+		valueToken = names[0]
+	}
+	if keyToken != nil {
+		forIn.Key = keyToken.Value
+		key := &NodeTermIdentifier{Type: keyType, Identifier: forIn.Key}
+		scope.identifiers[forIn.Key] = key
+		p.reference(keyToken, key, true)
+	}
+	if valueToken != nil {
+		forIn.Value = valueToken.Value
+		value := &NodeTermIdentifier{Type: collectionType.Elem(), Identifier: forIn.Value}
+		scope.identifiers[forIn.Value] = value
+		p.reference(valueToken, value, true)
+	}
+
+	err = p.parseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	forIn.scope = scope
+
+	return forIn, nil
+}
@@ -0,0 +1,327 @@
+package parser
+
+import (
+	"fmt"
+	"shake/lexer"
+	"shake/types"
+	"strconv"
+	"strings"
+)
+
+type NodeConditionalArm struct {
+	// nil for the `else` arm
+	Value NodeExpression
+	// an arm is either a scope `1 { ... }` or a single expression `1: 10`
+	scope      *NodeScope
+	Result     NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+// NodeConditional is either `if x == 1 { ... }` or matches a subject against the
+// values of its arms, as in `if x == 1 { true {} false {} }` and `if x == { 1 {} else {} }`
+type NodeConditional struct {
+	// the type of the value the conditional produces when used as an expression
+	Type types.Type
+	// the condition of the body, or the subject the arms are matched against
+	Condition NodeExpression
+	// the body when the conditional has no arms
+	scope      *NodeScope
+	Arms       []*NodeConditionalArm
+	LineNumber uint64
+	// line of the `}` closing the arms
+	end  uint64
+	Span Span
+}
+
+func (nc NodeConditional) GetType() types.Type {
+	return nc.Type
+}
+
+func (nc NodeConditional) GetSpan() Span {
+	return nc.Span
+}
+
+// Scope returns the body, or nil when the conditional has arms
+func (nc *NodeConditional) Scope() *NodeScope {
+	return nc.scope
+}
+
+// End returns the line of the `}` closing the body or the arms
+func (nc *NodeConditional) End() uint64 {
+	if nc.scope != nil {
+		return nc.scope.end.Line
+	}
+	return nc.end
+}
+
+// Scope returns the scope of the arm, or nil when the arm is a single expression
+func (nca *NodeConditionalArm) Scope() *NodeScope {
+	return nca.scope
+}
+
+func (p *Parser) parseConditional(isExpression bool) (*NodeConditional, error) {
+	// consume the `if` keyword
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("statement but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenKeyword, Value: "if"})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	conditional := &NodeConditional{
+		Type:       types.TypeEmpty,
+		Condition:  condition,
+		LineNumber: token.LineNumber,
+	}
+
+	// `if x == {` matches x against the arms
+	nextToken, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`{` but found nothing", token.LineNumber)
+	}
+	if nextToken.Type == lexer.TokenOperation && nextToken.Value == "==" {
+		p.tokens.Pop()
+		err = p.parseArms(conditional, isExpression)
+		if err != nil {
+			return nil, err
+		}
+		return conditional, p.checkArms(conditional, isExpression)
+	}
+
+	if condition.GetType() != types.TypeBool {
+		return nil, Error(fmt.Sprintf("Condition must be of type: %s but found: %s", types.TypeBool, condition.GetType()), token.LineNumber)
+	}
+
+	// `{ true {` or `{ true:` starts the arms, anything else is the body
+	firstToken, err := p.tokens.Peek(1)
+	if err != nil {
+		return nil, ExpectedError("`{` but found nothing", token.LineNumber)
+	}
+	if firstToken.Type == lexer.TokenKeyword && (firstToken.Value == "true" || firstToken.Value == "false" || firstToken.Value == "else") {
+		err = p.parseArms(conditional, isExpression)
+		if err != nil {
+			return nil, err
+		}
+		return conditional, p.checkArms(conditional, isExpression)
+	}
+
+	scope := p.newScope(p.program.CurrentScope.returnType)
+	err = p.parseScope(scope)
+	if err != nil {
+		return nil, err
+	}
+	conditional.scope = scope
+	return conditional, p.checkArms(conditional, isExpression)
+}
+
+// parseArms parses `{ value { ... } value: expression else { ... } }`
+func (p *Parser) parseArms(conditional *NodeConditional, isExpression bool) error {
+	subjectType := conditional.Condition.GetType()
+	if !subjectType.IsInteger() && subjectType != types.TypeBool && subjectType != types.TypeError {
+		return Error(fmt.Sprintf("Cannot match on type: %s", subjectType), conditional.LineNumber)
+	}
+
+	err := p.expectPunctuation("{")
+	if err != nil {
+		return err
+	}
+	// when used as an expression the type is inferred from the first arm
+	if isExpression {
+		conditional.Type = types.TypeUnknown
+	}
+
+	// parse arms until `}`
+	for {
+		token, err := p.tokens.Peek(0)
+		if err != nil {
+			return ExpectedError("`}` but found nothing", conditional.LineNumber)
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == "}" {
+			conditional.end = p.tokens.Pop().LineNumber
+			break
+		}
+
+		start := position(token)
+		arm := &NodeConditionalArm{
+			LineNumber: token.LineNumber,
+		}
+		switch {
+		case token.Type == lexer.TokenKeyword && token.Value == "else":
+			p.tokens.Pop()
+		// there are no error values to match against, only the lack of one
+		case subjectType == types.TypeError && token.Value == "empty":
+			p.tokens.Pop()
+			arm.Value = NodeExpressionLiteral{
+				Type:  types.TypeError,
+				Value: NodeTermEmpty{},
+				Span:  p.span(start),
+			}
+		default:
+			arm.Value, err = p.parseOperand()
+			if err != nil {
+				return err
+			}
+			if arm.Value.GetType() != subjectType {
+				return Error(fmt.Sprintf("Arm must be of type: %s but found: %s", subjectType, arm.Value.GetType()), token.LineNumber)
+			}
+		}
+
+		// the value is followed by a scope `{ ... }` or by `:` and an
+		// expression, the `:` can also come before a scope
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return ExpectedError("arm but found nothing", arm.LineNumber)
+		}
+		colon := token.Type == lexer.TokenPunctuation && token.Value == ":"
+		if colon {
+			p.tokens.Pop()
+			token, err = p.tokens.Peek(0)
+			if err != nil {
+				return ExpectedError("arm but found nothing", arm.LineNumber)
+			}
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == "{" {
+			returnType := p.program.CurrentScope.returnType
+			if isExpression {
+				// returning from the arm gives the conditional its value
+				returnType = conditional.Type
+			}
+			arm.scope = p.newScope(returnType)
+			err = p.parseScope(arm.scope)
+			if err != nil {
+				return err
+			}
+			if isExpression {
+				returns, path := p.scopeReturns(arm.scope)
+				if !returns {
+					return Error(fmt.Sprintf("Arm does not produce a value, this path falls through: %s", describePath("the arm", path)), arm.LineNumber)
+				}
+				conditional.Type = arm.scope.returnType
+			}
+		} else {
+			if !colon {
+				return ExpectedError(fmt.Sprintf("`:` or `{` but found: %s", token.Value), token.LineNumber)
+			}
+			arm.Result, err = p.parseExpression()
+			if err != nil {
+				return err
+			}
+			if isExpression && conditional.Type == types.TypeUnknown {
+				conditional.Type = arm.Result.GetType()
+			}
+			if isExpression && arm.Result.GetType() != conditional.Type {
+				return Error(fmt.Sprintf("Arm produces: %s but the conditional produces: %s", arm.Result.GetType(), conditional.Type), arm.LineNumber)
+			}
+		}
+		arm.Span = p.span(start)
+		conditional.Arms = append(conditional.Arms, arm)
+
+		// arms can be separated by `;`
+		token, err = p.tokens.Peek(0)
+		if err == nil && token.Type == lexer.TokenSemicolon {
+			p.tokens.Pop()
+		}
+	}
+
+	return nil
+}
+
+// armKey identifies the value an arm matches or a map key, or returns false if
+// it is not a constant
+func armKey(value NodeExpression) (string, bool) {
+	literal, ok := value.(NodeExpressionLiteral)
+	if !ok {
+		return "", false
+	}
+	switch term := literal.Value.(type) {
+	case NodeTermInt32:
+		number, err := strconv.ParseInt(term.Value, 10, 64)
+		if err != nil {
+			return term.Value, true
+		}
+		return strconv.FormatInt(number, 10), true
+	case NodeTermBool:
+		return strconv.FormatBool(term.Value), true
+	case NodeTermEmpty:
+		return "empty", true
+	}
+	return "", false
+}
+
+// uncoveredCases lists the values of the subject that no arm handles
+func uncoveredCases(conditional *NodeConditional) []string {
+	// a body only handles a true condition
+	if conditional.scope != nil {
+		return []string{"false"}
+	}
+
+	covered := map[string]bool{}
+	for _, arm := range conditional.Arms {
+		if arm.Value == nil {
+			return nil
+		}
+		if key, ok := armKey(arm.Value); ok {
+			covered[key] = true
+		}
+	}
+
+	uncovered := []string{}
+	switch conditional.Condition.GetType() {
+	case types.TypeBool:
+		for _, value := range []string{"true", "false"} {
+			if !covered[value] {
+				uncovered = append(uncovered, value)
+			}
+		}
+	case types.TypeError:
+		if !covered["empty"] {
+			uncovered = append(uncovered, "empty")
+		}
+		uncovered = append(uncovered, "an error (needs an else)")
+	default:
+		// integers can only be covered by an `else`
+		uncovered = append(uncovered, fmt.Sprintf("any other %s (needs an else)", conditional.Condition.GetType()))
+	}
+	return uncovered
+}
+
+// checkArms warns about arms that can never match and makes sure a
+// conditional used as an expression handles every value of its subject
+func (p *Parser) checkArms(conditional *NodeConditional, isExpression bool) error {
+	seen := map[string]bool{}
+	afterElse := false
+	for _, arm := range conditional.Arms {
+		if afterElse {
+			p.warn(fmt.Sprintf("Unreachable arm after the else of the if at line %d", conditional.LineNumber), arm.LineNumber)
+			continue
+		}
+		if arm.Value == nil {
+			afterElse = true
+			continue
+		}
+		key, ok := armKey(arm.Value)
+		if !ok {
+			continue
+		}
+		if seen[key] {
+			p.warn(fmt.Sprintf("Duplicate arm: %s of the if at line %d is unreachable", key, conditional.LineNumber), arm.LineNumber)
+		}
+		seen[key] = true
+	}
+
+	if !isExpression {
+		return nil
+	}
+	if uncovered := uncoveredCases(conditional); len(uncovered) > 0 {
+		return Error(fmt.Sprintf("Conditional used as a value does not handle: %s", strings.Join(uncovered, ", ")), conditional.LineNumber)
+	}
+	return nil
+}
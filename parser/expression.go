@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"shake/lexer"
 	"shake/types"
+	"strconv"
 )
 
 // TODO: Add expressions
@@ -19,6 +20,7 @@ import (
 */
 type NodeExpression interface {
 	GetType() types.Type
+	GetSpan() Span
 }
 type NodeTerm interface {
 	GetType() types.Type
@@ -31,9 +33,36 @@ func (nti NodeTermInt32) GetType() types.Type {
 	return types.TypeInt32
 }
 
+// parseInt32 checks that a number literal fits in an int32, so every backend
+// gets the same value
+func parseInt32(value string, line uint64) (NodeTermInt32, error) {
+	_, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return NodeTermInt32{}, Error(fmt.Sprintf("Number: %s does not fit in %s", value, types.TypeInt32), line)
+	}
+	return NodeTermInt32{Value: value}, nil
+}
+
+type NodeTermBool struct {
+	Value bool
+}
+
+func (ntb NodeTermBool) GetType() types.Type {
+	return types.TypeBool
+}
+
+// NodeTermEmpty is the lack of a value, e.g. an error that did not happen
+type NodeTermEmpty struct{}
+
+func (nte NodeTermEmpty) GetType() types.Type {
+	return types.TypeEmpty
+}
+
 type NodeTermIdentifier struct {
 	Type       types.Type
 	Identifier string
+	// set when a closure refers to this identifier, so it has to be shared by reference
+	Captured bool
 }
 
 func (nti NodeTermIdentifier) GetType() types.Type {
@@ -41,33 +70,50 @@ func (nti NodeTermIdentifier) GetType() types.Type {
 }
 
 type NodeExpressionBinary struct {
+	Type      types.Type
 	Left      NodeExpression
 	Right     NodeExpression
 	Operation string
+	Span      Span
 }
 
 func (neb NodeExpressionBinary) GetType() types.Type {
-	return types.TypeInt32
+	return neb.Type
+}
+
+func (neb NodeExpressionBinary) GetSpan() Span {
+	return neb.Span
 }
 
 type NodeExpressionLiteral struct {
 	Type  types.Type
 	Value NodeTerm
+	// empty for the values the parser adds, such as the implicit return of a function
+	Span Span
 }
 
 func (nel NodeExpressionLiteral) GetType() types.Type {
 	return nel.Type
 }
 
+func (nel NodeExpressionLiteral) GetSpan() Span {
+	return nel.Span
+}
+
 type NodeExpressionIdentifier struct {
 	Type       types.Type
 	Identifier NodeTerm
+	Span       Span
 }
 
 func (nei NodeExpressionIdentifier) GetType() types.Type {
 	return nei.Type
 }
 
+func (nei NodeExpressionIdentifier) GetSpan() Span {
+	return nei.Span
+}
+
 func (p *Parser) parseTerm() (NodeTerm, error) {
 	// current token is the term
 	token, err := p.tokens.Peek(0)
@@ -78,14 +124,20 @@ func (p *Parser) parseTerm() (NodeTerm, error) {
 	switch token.Type {
 	case lexer.TokenIdentifier:
 		// check if the identifier exists
-		identifier, ok := p.program.identifiers[token.Value]
+		identifier, ok := p.lookup(token.Value)
 		if !ok {
 			return nil, Error(fmt.Sprintf("Identifier: %s of type: %s does not exist in the current scope", token.Value, token.Type), token.LineNumber)
 		}
+		p.reference(token, identifier, false)
 		return identifier, nil
 	case lexer.TokenNumber:
-		return NodeTermInt32{
-			Value: token.Value,
+		return parseInt32(token.Value, token.LineNumber)
+	case lexer.TokenKeyword:
+		if token.Value != "true" && token.Value != "false" {
+			return nil, ExpectedError(fmt.Sprintf("number or identifier but found: %s", token.Value), token.LineNumber)
+		}
+		return NodeTermBool{
+			Value: token.Value == "true",
 		}, nil
 	default:
 		return nil, ExpectedError(fmt.Sprintf("number or identifier but found: %s", token.Type), token.LineNumber)
@@ -93,53 +145,164 @@ func (p *Parser) parseTerm() (NodeTerm, error) {
 }
 
 func (p *Parser) parseExpression() (NodeExpression, error) {
-	token, err := p.tokens.Peek(0)
-	if err != nil {
-		return nil, ExpectedError("token but found nothing", 0)
-	}
+	return p.parseBinary(0)
+}
 
-	// check if the next token is */+-
-	nextToken, err := p.tokens.Peek(1)
+// parseBinary parses operands joined by operations of at least minPrecedence
+func (p *Parser) parseBinary(minPrecedence int) (NodeExpression, error) {
+	left, err := p.parseOperand()
 	if err != nil {
-		return nil, ExpectedError("token but found nothing", 0)
+		return nil, err
 	}
-	if nextToken.Type == lexer.TokenOperation {
-		left, err := p.parseTerm()
+
+	// check if the next token is an operation that binds tightly enough
+	for {
+		nextToken, err := p.tokens.Peek(0)
+		if err != nil || nextToken.Type != lexer.TokenOperation {
+			return left, nil
+		}
+		precedence, err := nextToken.GetBinaryPrecedence()
+		if err != nil || precedence < minPrecedence {
+			return left, nil
+		}
+		// `if x == {` matches x against the arms of a conditional
+		if afterToken, err := p.tokens.Peek(1); err == nil && nextToken.Value == "==" &&
+			afterToken.Type == lexer.TokenPunctuation && afterToken.Value == "{" {
+			return left, nil
+		}
 		operation := p.tokens.Pop()
+
+		right, err := p.parseBinary(precedence + 1)
 		if err != nil {
-			return nil, ExpectedError("term but found nothing", token.LineNumber)
+			return nil, ExpectedError("expression but found nothing", operation.LineNumber)
 		}
-		right, err := p.parseExpression()
+		binaryType, err := binaryType(left.GetType(), operation, right.GetType())
 		if err != nil {
-			return nil, ExpectedError("expression but found nothing", token.LineNumber)
+			return nil, err
 		}
-		return &NodeExpressionBinary{
-			Left: &NodeExpressionLiteral{
-				Type:  left.GetType(),
-				Value: left,
-			},
+		left = &NodeExpressionBinary{
+			Type:      binaryType,
+			Left:      left,
 			Right:     right,
 			Operation: operation.Value,
-		}, nil
+			Span:      Span{Start: left.GetSpan().Start, End: right.GetSpan().End},
+		}
+	}
+}
+
+// binaryType returns the type of applying the operation to the operand types
+func binaryType(left types.Type, operation *lexer.Token, right types.Type) (types.Type, error) {
+	if left != right {
+		return types.TypeUnknown, Error(fmt.Sprintf("Operation: %s is not supported between: %s and %s", operation.Value, left, right), operation.LineNumber)
+	}
+	switch operation.Value {
+	case "==", "!=":
+		if left.IsInteger() || left == types.TypeBool {
+			return types.TypeBool, nil
+		}
+	case "<", "<=", ">", ">=":
+		if left.IsInteger() {
+			return types.TypeBool, nil
+		}
+	default:
+		if left.IsInteger() {
+			return left, nil
+		}
 	}
+	return types.TypeUnknown, Error(fmt.Sprintf("Operation: %s is not supported between: %s and %s", operation.Value, left, right), operation.LineNumber)
+}
 
-	// not an operation so parseTerm
-	term, err := p.parseTerm()
+// parseOperand parses a single operand of a binary expression, including any indexing after it
+func (p *Parser) parseOperand() (NodeExpression, error) {
+	token, err := p.tokens.Peek(0)
 	if err != nil {
-		return nil, ExpectedError("term but found nothing", token.LineNumber)
+		return nil, ExpectedError("token but found nothing", 0)
 	}
-	switch token.Type {
-	case lexer.TokenIdentifier:
-		return &NodeExpressionIdentifier{
-			Type:       term.GetType(),
-			Identifier: term,
-		}, nil
-	case lexer.TokenNumber:
-		return NodeExpressionLiteral{
-			Type:  term.GetType(),
+	start := position(token)
+
+	var operand NodeExpression
+	switch {
+	case token.Type == lexer.TokenIdentifier && builtins[token.Value]:
+		operand, err = p.parseBuiltin()
+		if err != nil {
+			return nil, err
+		}
+	case token.Type == lexer.TokenKeyword && token.Value == "if":
+		operand, err = p.parseConditional(true)
+		if err != nil {
+			return nil, err
+		}
+	case token.Type == lexer.TokenOperation && token.Value == "-":
+		// negative number literals, e.g. `-1`
+		numberToken, err := p.tokens.Peek(1)
+		if err != nil || numberToken.Type != lexer.TokenNumber {
+			return nil, ExpectedError("number after `-`", token.LineNumber)
+		}
+		p.tokens.Pop()
+		p.tokens.Pop()
+		term, err := parseInt32("-"+numberToken.Value, numberToken.LineNumber)
+		if err != nil {
+			return nil, err
+		}
+		operand = NodeExpressionLiteral{
+			Type:  types.TypeInt32,
 			Value: term,
-		}, nil
+			Span:  p.span(start),
+		}
+	case token.Type == lexer.TokenKeyword && token.Value == "fn":
+		operand, err = p.parseFunctionLiteral()
+		if err != nil {
+			return nil, err
+		}
+	case token.Type == lexer.TokenPunctuation && token.Value == "[",
+		token.Type == lexer.TokenKeyword && token.Value == "map":
+		operand, err = p.parseCollectionLiteral()
+		if err != nil {
+			return nil, err
+		}
 	default:
-		return nil, ExpectedError(fmt.Sprintf("number or identifier but found: %s", token.Type), token.LineNumber)
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		switch token.Type {
+		case lexer.TokenIdentifier:
+			operand = &NodeExpressionIdentifier{
+				Type:       term.GetType(),
+				Identifier: term,
+			}
+		case lexer.TokenNumber, lexer.TokenKeyword:
+			operand = NodeExpressionLiteral{
+				Type:  term.GetType(),
+				Value: term,
+				Span:  p.span(start),
+			}
+		default:
+			return nil, ExpectedError(fmt.Sprintf("number or identifier but found: %s", token.Type), token.LineNumber)
+		}
+	}
+
+	p.setSpan(operand, start)
+
+	// index into, call or call a method of the operand for as long as there are `[`, `(` or `.`
+	for {
+		nextToken, err := p.tokens.Peek(0)
+		if err != nil || nextToken.Type != lexer.TokenPunctuation {
+			return operand, nil
+		}
+		switch nextToken.Value {
+		case "[":
+			operand, err = p.parseIndex(operand)
+		case "(":
+			operand, err = p.parseCall(operand)
+		case ".":
+			operand, err = p.parseMethodCall(operand)
+		default:
+			return operand, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.setSpan(operand, start)
 	}
 }
@@ -0,0 +1,120 @@
+package parser
+
+import (
+	"fmt"
+	"shake/types"
+)
+
+// statementLine returns the line a statement starts on
+func statementLine(statement NodeScopedStatement) uint64 {
+	switch statement := statement.(type) {
+	case *NodeReturn:
+		return statement.LineNumber
+	case *NodeAssignment:
+		return statement.LineNumber
+	case *NodeIndexAssignment:
+		return statement.LineNumber
+	case *NodeExpressionStatement:
+		return statement.LineNumber
+	case *NodeForIn:
+		return statement.LineNumber
+	case *NodeConditional:
+		return statement.LineNumber
+	}
+	return 0
+}
+
+// scopeReturns reports whether every path through the scope returns, and
+// otherwise describes a path that falls through. Statements after a return
+// are reported as unreachable.
+func (p *Parser) scopeReturns(scope *NodeScope) (bool, string) {
+	path := ""
+	for i, statement := range scope.statements {
+		returns, statementPath := p.statementReturns(statement)
+		if returns {
+			if i+1 < len(scope.statements) {
+				p.warn("Unreachable statement after return", statementLine(scope.statements[i+1]))
+			}
+			return true, ""
+		}
+		if statementPath != "" {
+			path = statementPath
+		}
+	}
+	return false, path
+}
+
+func (p *Parser) statementReturns(statement NodeScopedStatement) (bool, string) {
+	switch statement := statement.(type) {
+	case *NodeReturn:
+		return true, ""
+	case *NodeForIn:
+		// the body might never run, but is still checked for unreachable statements
+		p.scopeReturns(statement.scope)
+		return false, ""
+	case *NodeConditional:
+		return p.conditionalReturns(statement)
+	}
+	return false, ""
+}
+
+func (p *Parser) conditionalReturns(conditional *NodeConditional) (bool, string) {
+	if conditional.scope != nil {
+		returns, path := p.scopeReturns(conditional.scope)
+		if !returns {
+			return false, describePath(fmt.Sprintf("the if at line %d is true", conditional.LineNumber), path)
+		}
+		return false, fmt.Sprintf("the if at line %d is false", conditional.LineNumber)
+	}
+
+	for _, arm := range conditional.Arms {
+		step := fmt.Sprintf("the arm at line %d of the if at line %d", arm.LineNumber, conditional.LineNumber)
+		if arm.scope == nil {
+			return false, step
+		}
+		returns, path := p.scopeReturns(arm.scope)
+		if !returns {
+			return false, describePath(step, path)
+		}
+	}
+	if uncovered := uncoveredCases(conditional); len(uncovered) > 0 {
+		return false, fmt.Sprintf("the if at line %d matches none of its arms", conditional.LineNumber)
+	}
+	return true, ""
+}
+
+func describePath(step string, rest string) string {
+	if rest == "" {
+		return step
+	}
+	return step + " -> " + rest
+}
+
+// checkReturns makes sure every path through a function returns. Entry
+// functions that fall through implicitly return 0.
+func (p *Parser) checkReturns(nodeFunction *NodeFunction) error {
+	returns, path := p.scopeReturns(nodeFunction.scope)
+	if returns || nodeFunction.returnType == types.TypeEmpty {
+		return nil
+	}
+	if nodeFunction.entry {
+		var zero NodeExpression = NodeExpressionLiteral{
+			Type:  nodeFunction.returnType,
+			Value: NodeTermInt32{Value: "0"},
+		}
+		nodeFunction.scope.statements = append(nodeFunction.scope.statements, &NodeReturn{
+			value:      &zero,
+			LineNumber: nodeFunction.line,
+		})
+		return nil
+	}
+
+	name := nodeFunction.name
+	if name == "" {
+		name = fmt.Sprintf("anonymous function at line %d", nodeFunction.line)
+	}
+	if path == "" {
+		return Error(fmt.Sprintf("Missing return in function: %s", name), nodeFunction.line)
+	}
+	return Error(fmt.Sprintf("Missing return in function: %s, this path falls through: %s", name, path), nodeFunction.line)
+}
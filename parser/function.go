@@ -4,72 +4,456 @@ import (
 	"encoding/json"
 	"fmt"
 	"shake/lexer"
+	"shake/types"
 )
 
 type NodeFunction struct {
 	scope *NodeScope
 	name  string
+	// set for methods, e.g. `s` in `fn (s: Square) Area(): int32`
+	receiver     *NodeTermIdentifier
+	parameters   []*NodeTermIdentifier
+	returnType   types.Type
+	functionType types.Type
+	// identifiers of enclosing functions used by this function
+	captures []*NodeTermIdentifier
+	// set by the `(entry)` decorator
+	entry bool
+	// set when the body is a single expression: `fn add(x: int32): int32: x + 1;`
+	inline bool
+	line   uint64
+	span   Span
+	// the `///` comments above the function
+	doc string
 }
 
 func (nf NodeFunction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(nf.name)
 }
 
-func (p *Parser) parseFunction() (*NodeFunction, error) {
-	// expected identifier: `main/add`
+// functions are values, an anonymous function is an expression
+func (nf NodeFunction) GetType() types.Type {
+	return nf.functionType
+}
+
+// GetSpan returns the span from `fn`, or the decorators before it, to the end of the body
+func (nf NodeFunction) GetSpan() Span {
+	return nf.span
+}
+
+func (nf *NodeFunction) Name() string {
+	return nf.name
+}
+
+// Receiver returns the receiver of a method, or nil for a function
+func (nf *NodeFunction) Receiver() *NodeTermIdentifier {
+	return nf.receiver
+}
+
+// QualifiedName returns the name of a function, or `Type.Name` for a method,
+// which unlike function names do not have to be unique
+func (nf *NodeFunction) QualifiedName() string {
+	if nf.receiver == nil {
+		return nf.name
+	}
+	return nf.receiver.Type.String() + "." + nf.name
+}
+
+// Parameters returns the parameters of the function, without the receiver of a method
+func (nf *NodeFunction) Parameters() []*NodeTermIdentifier {
+	return nf.parameters
+}
+
+func (nf *NodeFunction) ReturnType() types.Type {
+	return nf.returnType
+}
+
+func (nf *NodeFunction) Scope() *NodeScope {
+	return nf.scope
+}
+
+// Captures returns the identifiers of enclosing functions used by this function
+func (nf *NodeFunction) Captures() []*NodeTermIdentifier {
+	return nf.captures
+}
+
+func (nf *NodeFunction) IsEntry() bool {
+	return nf.entry
+}
+
+// Inline reports whether the body was written as `: expression;`, its scope
+// then holds a single return of the expression
+func (nf *NodeFunction) Inline() bool {
+	return nf.inline
+}
+
+// Doc returns the `///` comments above a top level function, when the lexer kept its trivia
+func (nf *NodeFunction) Doc() string {
+	return nf.doc
+}
+
+func (nf *NodeFunction) Line() uint64 {
+	return nf.line
+}
+
+func (nf *NodeFunction) capture(identifier *NodeTermIdentifier) {
+	for _, captured := range nf.captures {
+		if captured == identifier {
+			return
+		}
+	}
+	nf.captures = append(nf.captures, identifier)
+}
+
+type NodeExpressionCall struct {
+	Type       types.Type
+	Callee     NodeExpression
+	Arguments  []NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+func (nec NodeExpressionCall) GetType() types.Type {
+	return nec.Type
+}
+
+func (nec NodeExpressionCall) GetSpan() Span {
+	return nec.Span
+}
+
+func (p *Parser) parseFunction(decorators []string) (*NodeFunction, error) {
 	token, err := p.tokens.Peek(0)
 	if err != nil {
 		return nil, err
 	}
+	// methods have a receiver before their name: `fn (s: Square) Area(): int32`
+	var receiver *NodeTermIdentifier
+	if token.Type == lexer.TokenPunctuation && token.Value == "(" {
+		receiver, err = p.parseReceiver()
+		if err != nil {
+			return nil, err
+		}
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("method name but found nothing", 0)
+		}
+	}
+
+	// expected identifier: `main/add`
 	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
 	if err != nil {
 		return nil, err
 	}
 	funcIdentifier := p.tokens.Pop()
+	if _, ok := p.lookup(funcIdentifier.Value); ok && receiver == nil {
+		return nil, Error(fmt.Sprintf("Function: %s is already declared", funcIdentifier.Value), funcIdentifier.LineNumber)
+	}
 	nodeFunction := &NodeFunction{
-		name: funcIdentifier.Value,
+		name:     funcIdentifier.Value,
+		receiver: receiver,
+		line:     funcIdentifier.LineNumber,
+	}
+	for _, decorator := range decorators {
+		switch decorator {
+		case "entry":
+			if receiver != nil {
+				return nil, Error(fmt.Sprintf("Method: %s cannot be the entry", nodeFunction.QualifiedName()), funcIdentifier.LineNumber)
+			}
+			if p.program.entry != nil {
+				return nil, Error(fmt.Sprintf("Function: %s is already the entry", p.program.entry.name), funcIdentifier.LineNumber)
+			}
+			nodeFunction.entry = true
+			p.program.entry = nodeFunction
+		default:
+			return nil, Error(fmt.Sprintf("Unknown decorator: %s", decorator), funcIdentifier.LineNumber)
+		}
+	}
+
+	err = p.parseSignature(nodeFunction)
+	if err != nil {
+		return nil, err
+	}
+
+	// declare the function before any body is parsed, so it can call itself
+	// and be called from the functions above it
+	if receiver != nil {
+		err = p.declareMethod(nodeFunction)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		declared := &NodeTermIdentifier{
+			Type:       nodeFunction.functionType,
+			Identifier: nodeFunction.name,
+		}
+		p.declare(declared)
+		p.reference(funcIdentifier, declared, true)
 	}
 
-	// expected `(`
 	token, err = p.tokens.Peek(0)
+	if err == nil && token.Type == lexer.TokenPunctuation && (token.Value == "{" || token.Value == ":") {
+		p.bodies = append(p.bodies, body{function: nodeFunction, tokens: newBufferedTokens(p.tokens.skipBody())})
+		return nodeFunction, nil
+	}
+	err = p.parseBody(nodeFunction)
 	if err != nil {
 		return nil, err
 	}
-	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: "("})
+
+	return nodeFunction, nil
+}
+
+// parseFunctionLiteral parses an anonymous function, e.g. `fn(x: int32): int32 { return x; }`
+func (p *Parser) parseFunctionLiteral() (*NodeFunction, error) {
+	// consume the `fn` keyword
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`fn` but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenKeyword, Value: "fn"})
 	if err != nil {
 		return nil, err
 	}
 	p.tokens.Pop()
 
-	// expected `)`
-	token, err = p.tokens.Peek(0)
+	nodeFunction := &NodeFunction{
+		line: token.LineNumber,
+		span: Span{Start: position(token)},
+	}
+	err = p.parseSignature(nodeFunction)
 	if err != nil {
 		return nil, err
 	}
-	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: ")"})
+	err = p.parseBody(nodeFunction)
 	if err != nil {
 		return nil, err
 	}
+
+	return nodeFunction, nil
+}
+
+// parseSignature parses the parameters and the return type: `(x: int32, y: int32): int32`
+func (p *Parser) parseSignature(nodeFunction *NodeFunction) error {
+	// expected `(`
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return err
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: "("})
+	if err != nil {
+		return err
+	}
 	p.tokens.Pop()
 
-	// expected return type
+	// parse parameters until `)`
+	nodeFunction.parameters = []*NodeTermIdentifier{}
+	for {
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return ExpectedError("`)` but found nothing", 0)
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == ")" {
+			p.tokens.Pop()
+			break
+		}
+		if len(nodeFunction.parameters) > 0 {
+			err = p.expectPunctuation(",")
+			if err != nil {
+				return err
+			}
+			token, err = p.tokens.Peek(0)
+			if err != nil {
+				return ExpectedError("parameter but found nothing", 0)
+			}
+		}
+
+		err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+		if err != nil {
+			return err
+		}
+		parameterName := p.tokens.Pop()
+		err = p.expectPunctuation(":")
+		if err != nil {
+			return err
+		}
+		parameterType, err := p.parseType()
+		if err != nil {
+			return err
+		}
+		for _, parameter := range nodeFunction.parameters {
+			if parameter.Identifier == parameterName.Value {
+				return Error(fmt.Sprintf("Parameter: %s is declared twice", parameterName.Value), parameterName.LineNumber)
+			}
+		}
+		parameter := &NodeTermIdentifier{
+			Type:       parameterType,
+			Identifier: parameterName.Value,
+		}
+		p.reference(parameterName, parameter, true)
+		nodeFunction.parameters = append(nodeFunction.parameters, parameter)
+	}
+
+	// expected return type, entry functions return int32 by default
 	token, err = p.tokens.Peek(0)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	returnType := types.TypeInt32
+	if !nodeFunction.entry || token.Type != lexer.TokenPunctuation || token.Value != "{" {
+		err = p.expectPunctuation(":")
+		if err != nil {
+			return err
+		}
+		returnType, err = p.parseType()
+		if err != nil {
+			return err
+		}
+	}
+	if nodeFunction.entry && returnType != types.TypeInt32 {
+		return Error(fmt.Sprintf("Entry function must return: %s but returns: %s", types.TypeInt32, returnType), token.LineNumber)
+	}
+	nodeFunction.returnType = returnType
+
+	parameterTypes := make([]types.Type, len(nodeFunction.parameters))
+	for i, parameter := range nodeFunction.parameters {
+		parameterTypes[i] = parameter.Type
+	}
+	nodeFunction.functionType = types.Function(parameterTypes, returnType)
+
+	return nil
+}
+
+// parseBody parses the scope of the function with its parameters declared in it
+func (p *Parser) parseBody(nodeFunction *NodeFunction) error {
+	scope := p.newScope(nodeFunction.returnType)
+	scope.function = nodeFunction
+	if nodeFunction.receiver != nil {
+		scope.identifiers[nodeFunction.receiver.Identifier] = nodeFunction.receiver
+	}
+	for _, parameter := range nodeFunction.parameters {
+		scope.identifiers[parameter.Identifier] = parameter
+	}
+
+	// a declaration can be a single expression, not a literal, whose `;` would
+	// end the statement it is in
+	token, err := p.tokens.Peek(0)
+	if err == nil && nodeFunction.name != "" && token.Type == lexer.TokenPunctuation && token.Value == ":" {
+		nodeFunction.inline = true
+		err = p.parseInline(scope)
+	} else {
+		err = p.parseScope(scope)
 	}
-	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifierType})
 	if err != nil {
-		return nil, ExpectedError(fmt.Sprintf("Type got: %s", token.Value), token.LineNumber)
+		return err
 	}
+	nodeFunction.scope = scope
+	nodeFunction.span.End = end(p.tokens.last)
 
-	p.tokens.Pop()
+	return p.checkReturns(nodeFunction)
+}
+
+// parseInline parses the body of an inline function, `: expression;`, into
+// a scope that returns the expression
+func (p *Parser) parseInline(scope *NodeScope) error {
+	colon := p.tokens.Pop()
+	scope.start = position(colon)
+	lastScope := p.program.CurrentScope
+	p.program.CurrentScope = scope
+	defer func() { p.program.CurrentScope = lastScope }()
 
-	// parse scope``
-	scope, err := p.parseScope()
+	expression, err := p.parseExpression()
+	if err != nil {
+		return err
+	}
+	expression, err = p.convert(expression, scope.returnType, colon.LineNumber)
+	if err != nil {
+		return err
+	}
+	if scope.returnType != expression.GetType() {
+		return Error(fmt.Sprintf("Type of scope: %s is different from return type: %s", scope.returnType, expression.GetType()), colon.LineNumber)
+	}
+
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return ExpectedError("`;` but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenSemicolon})
+	if err != nil {
+		return err
+	}
+	scope.end = position(p.tokens.Pop())
+	scope.statements = append(scope.statements, &NodeReturn{value: &expression, LineNumber: colon.LineNumber, Span: expression.GetSpan()})
+	return nil
+}
+
+// parseCall parses `(arguments)` after an expression of a function type
+func (p *Parser) parseCall(callee NodeExpression) (*NodeExpressionCall, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`(` but found nothing", 0)
+	}
+	calleeType := callee.GetType()
+	if calleeType.Kind() != types.KindFunction {
+		return nil, Error(fmt.Sprintf("Cannot call type: %s", calleeType), token.LineNumber)
+	}
+
+	arguments, err := p.parseArguments(calleeType, token.LineNumber)
 	if err != nil {
 		return nil, err
 	}
-	nodeFunction.scope = scope
 
-	return nodeFunction, nil
+	return &NodeExpressionCall{
+		Type:       calleeType.Result(),
+		Callee:     callee,
+		Arguments:  arguments,
+		LineNumber: token.LineNumber,
+	}, nil
+}
+
+// parseArguments parses `(arguments)` and checks them against the parameters
+// of the function type
+func (p *Parser) parseArguments(calleeType types.Type, line uint64) ([]NodeExpression, error) {
+	err := p.expectPunctuation("(")
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := []NodeExpression{}
+	for {
+		currToken, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`)` but found nothing", line)
+		}
+		if currToken.Type == lexer.TokenPunctuation && currToken.Value == ")" {
+			p.tokens.Pop()
+			break
+		}
+		if len(arguments) > 0 {
+			err = p.expectPunctuation(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+		argument, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		arguments = append(arguments, argument)
+	}
+
+	parameterTypes := calleeType.Params()
+	if len(arguments) != len(parameterTypes) {
+		return nil, Error(fmt.Sprintf("Expected %d arguments but found %d when calling: %s", len(parameterTypes), len(arguments), calleeType), line)
+	}
+	for i, argument := range arguments {
+		argument, err = p.convert(argument, parameterTypes[i], line)
+		if err != nil {
+			return nil, err
+		}
+		if argument.GetType() != parameterTypes[i] {
+			return nil, Error(fmt.Sprintf("Mismatched type of argument %d: %s expected: %s", i+1, argument.GetType(), parameterTypes[i]), line)
+		}
+		arguments[i] = argument
+	}
+	return arguments, nil
 }
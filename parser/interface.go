@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"shake/lexer"
+	"shake/types"
+)
+
+type NodeMethodSignature struct {
+	Name       string
+	Parameters []*NodeTermIdentifier
+	ReturnType types.Type
+	// the type of the method without its receiver, e.g. `fn(int32): int32`
+	Type       types.Type
+	LineNumber uint64
+	Span       Span
+}
+
+type NodeInterface struct {
+	name     string
+	declared types.Type
+	methods  []NodeMethodSignature
+	line     uint64
+	// line of the closing `}`
+	end  uint64
+	span Span
+	// the `///` comments above the interface
+	doc string
+}
+
+func (ni NodeInterface) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ni.name)
+}
+
+func (ni *NodeInterface) Name() string {
+	return ni.name
+}
+
+// Doc returns the `///` comments above the interface, when the lexer kept its trivia
+func (ni *NodeInterface) Doc() string {
+	return ni.doc
+}
+
+func (ni *NodeInterface) Line() uint64 {
+	return ni.line
+}
+
+func (ni *NodeInterface) End() uint64 {
+	return ni.end
+}
+
+// Span returns the span from `interface` to the closing `}`
+func (ni *NodeInterface) Span() Span {
+	return ni.span
+}
+
+func (ni *NodeInterface) Methods() []NodeMethodSignature {
+	return ni.methods
+}
+
+// Method returns the signature of the method with the given name
+func (ni *NodeInterface) Method(name string) (NodeMethodSignature, bool) {
+	for _, method := range ni.methods {
+		if method.Name == name {
+			return method, true
+		}
+	}
+	return NodeMethodSignature{}, false
+}
+
+func (p *Parser) parseInterface() (*NodeInterface, error) {
+	// expected identifier: `Shape`
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("interface name but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+	if err != nil {
+		return nil, err
+	}
+	interfaceIdentifier := p.tokens.Pop()
+	if _, ok := p.program.interfaces[interfaceIdentifier.Value]; ok {
+		return nil, Error(fmt.Sprintf("Interface: %s is already declared", interfaceIdentifier.Value), interfaceIdentifier.LineNumber)
+	}
+	nodeInterface := &NodeInterface{
+		name:    interfaceIdentifier.Value,
+		methods: []NodeMethodSignature{},
+		line:    interfaceIdentifier.LineNumber,
+	}
+	// register the interface as a type first so its methods can use it
+	interfaceType, err := p.program.types.Declare(nodeInterface.name, types.KindInterface)
+	if err != nil {
+		return nil, Error(err.Error(), interfaceIdentifier.LineNumber)
+	}
+	nodeInterface.declared = interfaceType
+
+	// expected `{`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`{` but found nothing", interfaceIdentifier.LineNumber)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: "{"})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	// parse method signatures until `}`
+	for {
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`}` but found nothing", interfaceIdentifier.LineNumber)
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == "}" {
+			nodeInterface.end = p.tokens.Pop().LineNumber
+			break
+		}
+
+		method, err := p.parseMethodSignature()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := nodeInterface.Method(method.Name); ok {
+			return nil, Error(fmt.Sprintf("Method: %s is declared twice in interface: %s", method.Name, nodeInterface.name), token.LineNumber)
+		}
+		nodeInterface.methods = append(nodeInterface.methods, *method)
+	}
+
+	p.program.interfaces[nodeInterface.name] = nodeInterface
+
+	return nodeInterface, nil
+}
+
+func (p *Parser) parseMethodSignature() (*NodeMethodSignature, error) {
+	// expected identifier: `Area`
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("method name but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+	if err != nil {
+		return nil, err
+	}
+	methodIdentifier := p.tokens.Pop()
+
+	// the parameters and the return type are parsed like those of a function: `(scale: int32): int32`
+	signature := &NodeFunction{line: methodIdentifier.LineNumber}
+	err = p.parseSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+
+	// consume `;`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`;` but found nothing", methodIdentifier.LineNumber)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenSemicolon})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	return &NodeMethodSignature{
+		Name:       methodIdentifier.Value,
+		Parameters: signature.parameters,
+		ReturnType: signature.returnType,
+		Type:       signature.functionType,
+		LineNumber: methodIdentifier.LineNumber,
+		Span:       p.span(position(methodIdentifier)),
+	}, nil
+}
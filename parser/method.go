@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"fmt"
+	"shake/lexer"
+	"shake/types"
+)
+
+// NodeExpressionMethodCall calls a method on a value, e.g. `shape.Area()`
+type NodeExpressionMethodCall struct {
+	Type     types.Type
+	Receiver NodeExpression
+	Method   string
+	// the method of a receiver of a concrete type, nil when the receiver is an
+	// interface and the method is found by the type of its value when it runs
+	Function   *NodeFunction
+	Arguments  []NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+func (nemc NodeExpressionMethodCall) GetType() types.Type {
+	return nemc.Type
+}
+
+func (nemc NodeExpressionMethodCall) GetSpan() Span {
+	return nemc.Span
+}
+
+// NodeExpressionConversion turns a value into a value of an interface type it
+// satisfies, the value keeps its own type to find its methods by
+type NodeExpressionConversion struct {
+	Type       types.Type
+	Value      NodeExpression
+	LineNumber uint64
+}
+
+func (nec NodeExpressionConversion) GetType() types.Type {
+	return nec.Type
+}
+
+// GetSpan returns the span of the value, conversions are not written
+func (nec NodeExpressionConversion) GetSpan() Span {
+	return nec.Value.GetSpan()
+}
+
+// Method returns the method with the given name declared on a type
+func (np *NodeProgram) Method(receiver types.Type, name string) (*NodeFunction, bool) {
+	method, ok := np.methods[receiver][name]
+	return method, ok
+}
+
+// Interface returns the interface declared as the given type
+func (np *NodeProgram) Interface(declared types.Type) (*NodeInterface, bool) {
+	nodeInterface, ok := np.interfaces[declared.String()]
+	if !ok || nodeInterface.declared != declared {
+		return nil, false
+	}
+	return nodeInterface, true
+}
+
+// parseReceiver parses the receiver of a method: `(s: Square)`
+func (p *Parser) parseReceiver() (*NodeTermIdentifier, error) {
+	err := p.expectPunctuation("(")
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("receiver but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+	if err != nil {
+		return nil, err
+	}
+	receiverName := p.tokens.Pop()
+	err = p.expectPunctuation(":")
+	if err != nil {
+		return nil, err
+	}
+	receiverType, err := p.parseType()
+	if err != nil {
+		return nil, err
+	}
+	if receiverType.Kind() == types.KindInterface {
+		return nil, Error(fmt.Sprintf("Methods cannot be declared on interface: %s", receiverType), receiverName.LineNumber)
+	}
+	err = p.expectPunctuation(")")
+	if err != nil {
+		return nil, err
+	}
+
+	receiver := &NodeTermIdentifier{
+		Type:       receiverType,
+		Identifier: receiverName.Value,
+	}
+	p.reference(receiverName, receiver, true)
+	return receiver, nil
+}
+
+// declareMethod adds a method to the methods of its receiver type before any
+// body is parsed, so it can be called before its declaration
+func (p *Parser) declareMethod(method *NodeFunction) error {
+	for _, parameter := range method.parameters {
+		if parameter.Identifier == method.receiver.Identifier {
+			return Error(fmt.Sprintf("Parameter: %s is declared twice", parameter.Identifier), method.line)
+		}
+	}
+	receiverType := method.receiver.Type
+	if _, ok := p.program.Method(receiverType, method.name); ok {
+		return Error(fmt.Sprintf("Method: %s of type: %s is already declared", method.name, receiverType), method.line)
+	}
+	if p.program.methods[receiverType] == nil {
+		p.program.methods[receiverType] = make(map[string]*NodeFunction)
+	}
+	p.program.methods[receiverType][method.name] = method
+	return nil
+}
+
+// parseMethodCall parses `.Name(arguments)` after an expression
+func (p *Parser) parseMethodCall(receiver NodeExpression) (*NodeExpressionMethodCall, error) {
+	err := p.expectPunctuation(".")
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("method name but found nothing", 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+	if err != nil {
+		return nil, err
+	}
+	methodName := p.tokens.Pop()
+
+	call := &NodeExpressionMethodCall{
+		Receiver:   receiver,
+		Method:     methodName.Value,
+		LineNumber: methodName.LineNumber,
+	}
+	receiverType := receiver.GetType()
+	var methodType types.Type
+	if nodeInterface, ok := p.program.Interface(receiverType); ok {
+		method, ok := nodeInterface.Method(methodName.Value)
+		if !ok {
+			return nil, Error(fmt.Sprintf("Interface: %s has no method: %s", receiverType, methodName.Value), methodName.LineNumber)
+		}
+		methodType = method.Type
+	} else {
+		method, ok := p.program.Method(receiverType, methodName.Value)
+		if !ok {
+			return nil, Error(fmt.Sprintf("Type: %s has no method: %s", receiverType, methodName.Value), methodName.LineNumber)
+		}
+		call.Function = method
+		methodType = method.functionType
+	}
+
+	call.Arguments, err = p.parseArguments(methodType, methodName.LineNumber)
+	if err != nil {
+		return nil, err
+	}
+	call.Type = methodType.Result()
+	return call, nil
+}
+
+// convert turns value into a value of target when target is an interface the
+// type of value satisfies. Other values are returned as they are, the caller
+// reports a type that does not match.
+func (p *Parser) convert(value NodeExpression, target types.Type, line uint64) (NodeExpression, error) {
+	nodeInterface, ok := p.program.Interface(target)
+	if !ok || value.GetType() == target {
+		return value, nil
+	}
+	err := p.satisfies(value.GetType(), nodeInterface, line)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeExpressionConversion{
+		Type:       target,
+		Value:      value,
+		LineNumber: line,
+	}, nil
+}
+
+// satisfies checks that source has every method of the interface with the
+// same parameters and result
+func (p *Parser) satisfies(source types.Type, nodeInterface *NodeInterface, line uint64) error {
+	for _, method := range nodeInterface.methods {
+		var found types.Type
+		if sourceInterface, ok := p.program.Interface(source); ok {
+			sourceMethod, ok := sourceInterface.Method(method.Name)
+			if !ok {
+				return Error(fmt.Sprintf("Type: %s does not satisfy interface: %s, it has no method: %s", source, nodeInterface.name, method.Name), line)
+			}
+			found = sourceMethod.Type
+		} else {
+			sourceMethod, ok := p.program.Method(source, method.Name)
+			if !ok {
+				return Error(fmt.Sprintf("Type: %s does not satisfy interface: %s, it has no method: %s", source, nodeInterface.name, method.Name), line)
+			}
+			found = sourceMethod.functionType
+		}
+		if found != method.Type {
+			return Error(fmt.Sprintf("Type: %s does not satisfy interface: %s, method: %s is %s but the interface needs %s", source, nodeInterface.name, method.Name, found, method.Type), line)
+		}
+	}
+	return nil
+}
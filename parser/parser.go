@@ -2,73 +2,397 @@ package parser
 
 import (
 	"fmt"
-	"runtime/debug"
+	"iter"
 	"shake/lexer"
-	"shake/options"
-	"shake/queue"
+	"shake/trace"
 	"shake/types"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/fatih/color"
 )
 
 type NodeScopedStatement interface{}
 
+// Position is a place in the source, lines and columns start at 1
+type Position struct {
+	Line   uint64
+	Column uint64
+	// Column counted in UTF-16 code units
+	UTF16Column uint64
+}
+
+func position(token *lexer.Token) Position {
+	return Position{Line: token.LineNumber, Column: token.Column, UTF16Column: token.UTF16Column}
+}
+
+// Span is the source of a node, from its first token to the end of its last
+type Span struct {
+	Start Position
+	// the position right after the last token
+	End Position
+}
+
+// end returns the position right after a token
+func end(token lexer.Token) Position {
+	after := position(&token)
+	for _, r := range token.Value {
+		after.Column++
+		after.UTF16Column += uint64(utf16.RuneLen(r))
+	}
+	return after
+}
+
+// span returns the span from start to the end of the last token parsed
+func (p *Parser) span(start Position) Span {
+	return Span{Start: start, End: end(p.tokens.last)}
+}
+
+// setSpan gives a statement or an expression parsed from start the span up to
+// the last token parsed. Literals are values and get their span when created.
+func (p *Parser) setSpan(node NodeScopedStatement, start Position) {
+	span := p.span(start)
+	switch node := node.(type) {
+	case *NodeAssignment:
+		node.Span = span
+	case *NodeIndexAssignment:
+		node.Span = span
+	case *NodeExpressionStatement:
+		node.Span = span
+	case *NodeReturn:
+		node.Span = span
+	case *NodeForIn:
+		node.Span = span
+	case *NodeConditional:
+		node.Span = span
+	case *NodeFunction:
+		node.span = span
+	case *NodeExpressionBinary:
+		node.Span = span
+	case *NodeExpressionIdentifier:
+		node.Span = span
+	case *NodeExpressionCall:
+		node.Span = span
+	case *NodeExpressionMethodCall:
+		node.Span = span
+	case *NodeExpressionIndex:
+		node.Span = span
+	case *NodeExpressionBuiltin:
+		node.Span = span
+	case *NodeExpressionCollection:
+		node.Span = span
+	}
+}
+
+// Reference is an identifier in the source and what it resolves to
+type Reference struct {
+	Identifier *NodeTermIdentifier
+	Position   Position
+	// set where the identifier is declared rather than used
+	Declaration bool
+}
+
 type NodeScope struct {
 	statements  []NodeScopedStatement
 	identifiers map[string]*NodeTermIdentifier
 	returnType  types.Type
+	parent      *NodeScope
+	// set when this scope is the body of a function
+	function *NodeFunction
+	// positions of the `{` and `}`
+	start Position
+	end   Position
 }
 type NodeProgram struct {
 	NodeScope
 	CurrentScope *NodeScope
+	interfaces   map[string]*NodeInterface
+	// the methods declared on each type, by name
+	methods map[types.Type]map[string]*NodeFunction
+	// the types the program declares, which no other program sees
+	types *types.Registry
+	// the function marked with `(entry)`
+	entry *NodeFunction
+	// functions the host provides, which have no body in the program
+	hosts []*NodeTermIdentifier
+	// every identifier in the source in the order it was parsed
+	references []Reference
 }
 
 type Parser struct {
-	tokens  *queue.Queue[lexer.Token]
-	program *NodeProgram
+	tokens   *tokens
+	program  *NodeProgram
+	warnings []error
+	// the top level functions whose bodies are parsed after every declaration
+	bodies []body
+	// the variables declared with DeclareVariable
+	variables []*NodeTermIdentifier
 }
 
-func NewParser(tokens *queue.Queue[lexer.Token]) *Parser {
-	return &Parser{
-		tokens: tokens,
-		program: &NodeProgram{
-			NodeScope: NodeScope{
-				statements:  []NodeScopedStatement{},
-				identifiers: make(map[string]*NodeTermIdentifier),
-			},
+// body is a function whose signature is declared and the tokens of its body
+type body struct {
+	function *NodeFunction
+	tokens   *tokens
+}
+
+func (ns *NodeScope) Statements() []NodeScopedStatement {
+	return ns.statements
+}
+
+// Identifier returns the identifier declared in this scope with the given name
+func (ns *NodeScope) Identifier(name string) *NodeTermIdentifier {
+	return ns.identifiers[name]
+}
+
+func (ns *NodeScope) ReturnType() types.Type {
+	return ns.returnType
+}
+
+// End returns the line of the closing `}`
+func (ns *NodeScope) End() uint64 {
+	return ns.end.Line
+}
+
+// Range returns the positions of the `{` and `}` of the scope
+func (ns *NodeScope) Range() (Position, Position) {
+	return ns.start, ns.end
+}
+
+// Identifiers returns the identifiers declared in this scope, not in the enclosing ones
+func (ns *NodeScope) Identifiers() []*NodeTermIdentifier {
+	identifiers := make([]*NodeTermIdentifier, 0, len(ns.identifiers))
+	for _, identifier := range ns.identifiers {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Slice(identifiers, func(i, j int) bool {
+		return identifiers[i].Identifier < identifiers[j].Identifier
+	})
+	return identifiers
+}
+
+// Entry returns the function marked with `(entry)`, or nil
+func (np *NodeProgram) Entry() *NodeFunction {
+	return np.entry
+}
+
+// References returns every identifier of the source with what it resolves to
+func (np *NodeProgram) References() []Reference {
+	return np.references
+}
+
+// Hosts returns the functions declared with DeclareHost, in the order they were declared
+func (np *NodeProgram) Hosts() []*NodeTermIdentifier {
+	return np.hosts
+}
+
+// NewParser returns a parser of the tokens, which it pulls one at a time as
+// it parses, so a *lexer.Lexer only reads as much of the source as is parsed
+func NewParser(seq iter.Seq[lexer.Token]) *Parser {
+	program := &NodeProgram{
+		NodeScope: NodeScope{
+			statements:  []NodeScopedStatement{},
+			identifiers: make(map[string]*NodeTermIdentifier),
 		},
+		interfaces: make(map[string]*NodeInterface),
+		methods:    make(map[types.Type]map[string]*NodeFunction),
+		types:      types.NewRegistry(),
+	}
+	program.CurrentScope = &program.NodeScope
+	return &Parser{
+		tokens:  newTokens(seq),
+		program: program,
 	}
 }
 
+// DeclareHost declares a function the host provides, so the program can call
+// it. Hosts are declared before parsing the program.
+func (p *Parser) DeclareHost(name string, params []types.Type, result types.Type) error {
+	if _, ok := p.program.identifiers[name]; ok {
+		return Error(fmt.Sprintf("Function: %s is already declared", name), 0)
+	}
+	host := &NodeTermIdentifier{
+		Type:       types.Function(params, result),
+		Identifier: name,
+	}
+	p.declare(host)
+	p.program.hosts = append(p.program.hosts, host)
+	return nil
+}
+
+// DeclareVariable declares a variable in the program scope, which every
+// function can use, as the REPL does with the variables of earlier inputs.
+// Variables are declared before parsing the program.
+func (p *Parser) DeclareVariable(name string, t types.Type) (*NodeTermIdentifier, error) {
+	if _, ok := p.program.identifiers[name]; ok {
+		return nil, Error(fmt.Sprintf("Variable: %s is already declared", name), 0)
+	}
+	variable := &NodeTermIdentifier{Type: t, Identifier: name}
+	p.declare(variable)
+	p.variables = append(p.variables, variable)
+	return variable, nil
+}
+
+// resolveVariables looks the types of the declared variables up again once
+// the interfaces of the program are declared. A type of another program is
+// not the type of the same name this program declares.
+func (p *Parser) resolveVariables() error {
+	declarations := p.tokens
+	defer func() { p.tokens = declarations }()
+	for _, variable := range p.variables {
+		l := lexer.NewLexer(strings.NewReader(variable.Type.String()))
+		p.tokens = newBufferedTokens(slices.Collect(l.All()))
+		t, err := p.parseType()
+		if err != nil {
+			return Error(fmt.Sprintf("Type of variable: %s is not declared: %s", variable.Identifier, variable.Type), 0)
+		}
+		variable.Type = t
+	}
+	return nil
+}
+
 func (p *Parser) ParseProgram() (*NodeProgram, error) {
+	defer p.tokens.close()
 	token, err := p.tokens.TryPop()
 	for err == nil {
+		start := position(token)
+		// the doc comments are above the decorators when there are any
+		doc := token.Doc()
+		// decorators such as `(entry)` come before a function
+		decorators := []string{}
+		if token.Type == lexer.TokenPunctuation && token.Value == "(" {
+			decorators, err = p.parseDecorators()
+			if err != nil {
+				return nil, err
+			}
+			token, err = p.tokens.TryPop()
+			if err != nil {
+				return nil, ExpectedError("function after decorators but found nothing", 0)
+			}
+			if token.Type != lexer.TokenKeyword || token.Value != "fn" {
+				return nil, ExpectedError(fmt.Sprintf("function after decorators but found: %s", token.Value), token.LineNumber)
+			}
+		}
 		if token.Type != lexer.TokenKeyword {
-			return nil, ExpectedError("keywords - `fn/import`", token.LineNumber)
+			return nil, ExpectedError("keywords - `fn/interface/import`", token.LineNumber)
 		}
 		switch token.Value {
 		case "fn":
-			function, err := p.parseFunction()
+			function, err := p.parseFunction(decorators)
 			if err != nil {
 				return nil, err
 			}
+			function.span.Start = start
+			function.doc = doc
 			p.program.statements = append(p.program.statements, function)
+		case "interface":
+			nodeInterface, err := p.parseInterface()
+			if err != nil {
+				return nil, err
+			}
+			nodeInterface.span = p.span(start)
+			nodeInterface.doc = doc
+			p.program.statements = append(p.program.statements, nodeInterface)
 		// TODO: imports
 		case "import":
 		default:
-			return nil, ExpectedError("keywords - `fn/import`", token.LineNumber)
+			return nil, ExpectedError("keywords - `fn/interface/import`", token.LineNumber)
 		}
 		token, err = p.tokens.TryPop()
 	}
+	err = p.resolveVariables()
+	if err != nil {
+		return nil, err
+	}
+	err = p.parseBodies()
+	if err != nil {
+		return nil, err
+	}
 	return p.program, nil
 }
 
-func Error(reason string, line uint64) error {
-	if len(options.Options.Verbose) > 0 && options.Options.Verbose[0] {
-		debug.PrintStack()
+// parseBodies parses the bodies of the top level functions once all of them
+// are declared, so a function can call the functions declared after it
+func (p *Parser) parseBodies() error {
+	declarations := p.tokens
+	defer func() { p.tokens = declarations }()
+	for _, body := range p.bodies {
+		p.tokens = body.tokens
+		err := p.parseBody(body.function)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warnings returns the problems found while parsing that do not stop the program from running
+func (p *Parser) Warnings() []error {
+	return p.warnings
+}
+
+// parseDecorators parses `(entry)` or `(entry, other)` after the `(` was consumed
+func (p *Parser) parseDecorators() ([]string, error) {
+	decorators := []string{}
+	for {
+		token, err := p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("decorator but found nothing", 0)
+		}
+		err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+		if err != nil {
+			return nil, err
+		}
+		decorators = append(decorators, p.tokens.Pop().Value)
+
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return nil, ExpectedError("`)` but found nothing", 0)
+		}
+		if token.Type == lexer.TokenPunctuation && token.Value == "," {
+			p.tokens.Pop()
+			continue
+		}
+		return decorators, p.expectPunctuation(")")
+	}
+}
+
+// reference records that token is identifier
+func (p *Parser) reference(token *lexer.Token, identifier *NodeTermIdentifier, declaration bool) {
+	p.program.references = append(p.program.references, Reference{
+		Identifier:  identifier,
+		Position:    position(token),
+		Declaration: declaration,
+	})
+}
+
+func (p *Parser) warn(reason string, line uint64) {
+	p.warnings = append(p.warnings, Warning(reason, line))
+}
+
+// Diagnostic is an error or a warning about the program, at the line it was found
+type Diagnostic struct {
+	Reason     string
+	LineNumber uint64
+	Warning    bool
+}
+
+func (d *Diagnostic) Error() string {
+	if d.Warning {
+		c := color.New(color.FgYellow).Add(color.Underline)
+		return fmt.Sprintf("%s: %s at line: %d", c.Sprint("[Parser Warning]"), d.Reason, d.LineNumber)
 	}
 	c := color.New(color.FgRed).Add(color.Underline)
-	return fmt.Errorf("%s: %s at line: %d", c.Sprint("[Parser Error]"), reason, line)
+	return fmt.Sprintf("%s: %s at line: %d", c.Sprint("[Parser Error]"), d.Reason, d.LineNumber)
+}
+
+func Error(reason string, line uint64) error {
+	trace.Stack()
+	return &Diagnostic{Reason: reason, LineNumber: line}
+}
+func Warning(reason string, line uint64) error {
+	return &Diagnostic{Reason: reason, LineNumber: line, Warning: true}
 }
 func ExpectedError(reason string, line uint64) error {
 	return Error("Expected "+reason, line)
@@ -0,0 +1,175 @@
+package parser_test
+
+import (
+	"shake/lexer"
+	"shake/parser"
+	"strings"
+	"testing"
+)
+
+func parse(source string) (*parser.NodeProgram, error) {
+	return parser.NewParser(lexer.NewLexer(strings.NewReader(source)).All()).ParseProgram()
+}
+
+// TestTypePosition checks a type is parsed wherever the grammar expects one:
+// after a parameter, a function, a variable and inside other types
+func TestTypePosition(t *testing.T) {
+	program, err := parse(`fn f(s: []int32, m: map[int32]bool, g: fn(int32, int64): int32, a: [3]int64): fn(int32): []bool {
+    v: map[int32][]int64 = map[int32][]int64{};
+    return fn(x: int32): []bool {
+        return []bool{};
+    };
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	function := program.Identifier("f")
+	if function == nil {
+		t.Fatal("f is not declared")
+	}
+	want := "fn([]int32, map[int32]bool, fn(int32, int64): int32, [3]int64): fn(int32): []bool"
+	if got := function.Type.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"qualified", "fn f(v: math.Vec): int32 { return 1; }", "Unknown type: math.Vec"},
+		{"missing", "fn f(v: ): int32 { return 1; }", "type but found: )"},
+		{"map key", "fn f(m: map[[]int32]bool): int32 { return 1; }", "Invalid map key type: []int32"},
+		{"function result", "fn f(g: fn(int32)): int32 { return 1; }", "Expected : but found: )"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse(test.source)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestInline checks `fn name(params): T: expression;` declares a function
+// returning the expression
+func TestInline(t *testing.T) {
+	program, err := parse(`fn add(x: int32, y: int32): int32: x + y;
+fn main(): int32 {
+    return add(1, 2);
+}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := program.Identifier("add")
+	if add == nil || add.Type.String() != "fn(int32, int32): int32" {
+		t.Fatalf("got %+v, want add of fn(int32, int32): int32", add)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"return type", "fn f(x: int32): bool: x + 1;", "different from return type"},
+		{"semicolon", "fn f(x: int32): int32: x\nfn g(): int32 { return 1; }", "Expected Semicolon"},
+		{"literal", "fn f(): int32 { g = fn(): int32: 1; return 1; }", "Expected { but found: :"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse(test.source)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestForwardReferences checks every top level function and method is
+// declared before any body is parsed
+func TestForwardReferences(t *testing.T) {
+	program, err := parse(`interface Shape {
+    Area(): int32;
+}
+
+fn main(): int32 {
+    side = 3;
+    shape: Shape = side;
+    return ping(side.Area()) + shape.Area();
+}
+
+fn ping(n: int32): int32 {
+    if n <= 0 {
+        return 0;
+    }
+    return pong(n - 1);
+}
+
+fn pong(n: int32): int32: ping(n - 1) + 1;
+
+fn (side: int32) Area(): int32: side * side;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if program.Identifier("pong") == nil {
+		t.Error("pong is not declared")
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"undeclared", "fn main(): int32 { return missing(); }\nfn other(): int32 { return 1; }", "missing"},
+		{"redeclared", "fn f(): int32 { return g(); }\nfn g(): int32 { return 1; }\nfn g(): int32 { return 2; }", "Function: g is already declared"},
+		{"argument", "fn f(): int32 { return g(true); }\nfn g(x: int32): int32 { return x; }", "int32"},
+		{"unterminated", "fn g(): int32 { return 1; }\nfn f(): int32 { return g();", "found nothing"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parse(test.source)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestWarnings checks constants repeated where only the last one counts
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"map key", "m = map[int32]int32{1: 10, 2: 20, 1: 30};", []string{"Duplicate map key: 1 replaces the element before it"}},
+		{"map keys", "m = map[bool]int32{true: 1, false: 2, true: 3, false: 4};", []string{"Duplicate map key: true", "Duplicate map key: false"}},
+		{"map spelling", "m = map[int32]int32{7: 1, 07: 2};", []string{"Duplicate map key: 7"}},
+		{"map variables", "k = 1;\n    m = map[int32]int32{k: 1, k: 2};", nil},
+		{"arm", "x = 1;\n    y = if x == { 1: 2 1: 3 else: 4 };", []string{"Duplicate arm: 1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := parser.NewParser(lexer.NewLexer(strings.NewReader("fn main(): int32 {\n    " + test.body + "\n    return 0;\n}\n")).All())
+			_, err := p.ParseProgram()
+			if err != nil {
+				t.Fatal(err)
+			}
+			warnings := p.Warnings()
+			if len(warnings) != len(test.want) {
+				t.Fatalf("got %v, want %d warnings", warnings, len(test.want))
+			}
+			for i, warning := range warnings {
+				if !strings.Contains(warning.Error(), test.want[i]) {
+					t.Errorf("got %v, want %q", warning, test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -1,22 +1,32 @@
 package parser
 
-import "shake/lexer"
+import (
+	"shake/lexer"
+	"shake/types"
+)
 
-func (p *Parser) parseScope() (*NodeScope, error) {
+// newScope creates a scope nested inside the current scope
+func (p *Parser) newScope(returnType types.Type) *NodeScope {
+	return &NodeScope{
+		statements:  []NodeScopedStatement{},
+		identifiers: make(map[string]*NodeTermIdentifier),
+		returnType:  returnType,
+		parent:      p.program.CurrentScope,
+	}
+}
+
+func (p *Parser) parseScope(scope *NodeScope) error {
 	// expect `{`
 	token, err := p.tokens.Peek(0)
 	if err != nil {
-		return nil, ExpectedError("`{` but found nothing", 0)
+		return ExpectedError("`{` but found nothing", 0)
 	}
 	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: "{"})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	p.tokens.Pop()
+	scope.start = position(p.tokens.Pop())
 
-	scope := &NodeScope{
-		statements: []NodeScopedStatement{},
-	}
 	// set current scope
 	lastScope := p.program.CurrentScope
 	p.program.CurrentScope = scope
@@ -25,16 +35,16 @@ func (p *Parser) parseScope() (*NodeScope, error) {
 	for {
 		currToken, err := p.tokens.Peek(0)
 		if err != nil {
-			return nil, ExpectedError("`{` but found nothing", 0)
+			return ExpectedError("`{` but found nothing", 0)
 		}
 		if currToken.Type == lexer.TokenPunctuation && currToken.Value == "}" {
-			p.tokens.Pop()
+			scope.end = position(p.tokens.Pop())
 			break
 		}
 
 		statement, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		scope.statements = append(scope.statements, statement)
@@ -42,5 +52,35 @@ func (p *Parser) parseScope() (*NodeScope, error) {
 
 	// unset current scope
 	p.program.CurrentScope = lastScope
-	return scope, nil
+	return nil
+}
+
+// declare adds an identifier to the current scope
+func (p *Parser) declare(identifier *NodeTermIdentifier) {
+	p.program.CurrentScope.identifiers[identifier.Identifier] = identifier
+}
+
+// lookup resolves an identifier through the enclosing scopes. Identifiers that
+// belong to an enclosing function are captured by every function in between.
+func (p *Parser) lookup(name string) (*NodeTermIdentifier, bool) {
+	crossed := []*NodeFunction{}
+	for scope := p.program.CurrentScope; scope != nil; scope = scope.parent {
+		identifier, ok := scope.identifiers[name]
+		if !ok {
+			if scope.function != nil {
+				crossed = append(crossed, scope.function)
+			}
+			continue
+		}
+
+		// identifiers of the program scope are never captured
+		if scope.parent != nil && len(crossed) > 0 {
+			identifier.Captured = true
+			for _, function := range crossed {
+				function.capture(identifier)
+			}
+		}
+		return identifier, true
+	}
+	return nil, false
 }
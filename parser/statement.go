@@ -10,9 +10,26 @@ type NodeAssignment struct {
 	Identifier string
 	Type       types.Type
 	Expression *NodeExpression
+	// set when the type is written, as in `x: int32 = 1`, which always declares a new variable
+	Annotated bool
+	// the variable the identifier resolved to in the scope chain
+	Variable   *NodeTermIdentifier
+	LineNumber uint64
+	Span       Span
+}
+type NodeExpressionStatement struct {
+	Expression NodeExpression
+	LineNumber uint64
+	Span       Span
 }
 type NodeReturn struct {
-	value *NodeExpression
+	value      *NodeExpression
+	LineNumber uint64
+	Span       Span
+}
+
+func (nr *NodeReturn) Value() NodeExpression {
+	return *nr.value
 }
 
 func (p *Parser) parseReturn() (*NodeReturn, error) {
@@ -25,7 +42,7 @@ func (p *Parser) parseReturn() (*NodeReturn, error) {
 	if err != nil {
 		return nil, err
 	}
-	p.tokens.Pop()
+	returnToken := p.tokens.Pop()
 	// get the return value
 	expression, err := p.parseExpression()
 	if err != nil {
@@ -33,6 +50,14 @@ func (p *Parser) parseReturn() (*NodeReturn, error) {
 	}
 
 	currentScope := p.program.CurrentScope
+	// the arms of a conditional used as a value infer their type from the first return
+	if currentScope.returnType == types.TypeUnknown {
+		currentScope.returnType = expression.GetType()
+	}
+	expression, err = p.convert(expression, currentScope.returnType, returnToken.LineNumber)
+	if err != nil {
+		return nil, err
+	}
 	if currentScope.returnType != expression.GetType() {
 		return nil, Error(fmt.Sprintf("Type of scope: %s is different from return type: %s", currentScope.returnType, expression.GetType().String()), token.LineNumber)
 	}
@@ -49,7 +74,8 @@ func (p *Parser) parseReturn() (*NodeReturn, error) {
 	p.tokens.Pop()
 
 	return &NodeReturn{
-		value: &expression,
+		value:      &expression,
+		LineNumber: returnToken.LineNumber,
 	}, nil
 }
 
@@ -68,16 +94,28 @@ func (p *Parser) parseAssignment() (*NodeAssignment, error) {
 	// only consume type if exists and if not get the expression type
 	identifierToken, err := p.tokens.Peek(0)
 	if err != nil {
-		return nil, ExpectedError("type but found nothing", 0)
+		return nil, ExpectedError("`:` or `=` but found nothing", 0)
 	}
 
-	identifierType := types.GetType(identifierToken.Value)
-	var typeString *lexer.Token
-	if identifierType != types.TypeUnknown {
-		typeString = p.tokens.Pop()
+	identifierType := types.TypeUnknown
+	annotated := identifierToken.Type == lexer.TokenPunctuation && identifierToken.Value == ":"
+	if annotated {
+		p.tokens.Pop()
+		identifierType, err = p.parseType()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// consume the `=`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("`=` but found nothing", identifier.LineNumber)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenOperation, Value: "="})
+	if err != nil {
+		return nil, err
+	}
 	p.tokens.Pop()
 
 	expression, err := p.parseExpression()
@@ -88,10 +126,14 @@ func (p *Parser) parseAssignment() (*NodeAssignment, error) {
 	if identifierType == types.TypeUnknown {
 		identifierType = expression.GetType()
 	}
+	expression, err = p.convert(expression, identifierType, identifier.LineNumber)
+	if err != nil {
+		return nil, err
+	}
 
 	// check variable type and expression type match
 	if identifierType != expression.GetType() {
-		return nil, Error(fmt.Sprintf("Mismatched type when assigning variable %s of type %s and expression of type %s", identifier.Value, identifierType.String(), expression.GetType().String()), typeString.LineNumber)
+		return nil, Error(fmt.Sprintf("Mismatched type when assigning variable %s of type %s and expression of type %s", identifier.Value, identifierType.String(), expression.GetType().String()), identifier.LineNumber)
 	}
 
 	// consume the `;`
@@ -105,18 +147,66 @@ func (p *Parser) parseAssignment() (*NodeAssignment, error) {
 	}
 	p.tokens.Pop()
 
+	// without a type the variable is reassigned if it exists, otherwise it is declared
+	variable, exists := p.lookup(identifier.Value)
+	if exists && !annotated {
+		expression, err = p.convert(expression, variable.Type, identifier.LineNumber)
+		if err != nil {
+			return nil, err
+		}
+		if variable.Type != expression.GetType() {
+			return nil, Error(fmt.Sprintf("Mismatched type when assigning variable %s of type %s and expression of type %s", identifier.Value, variable.Type.String(), identifierType.String()), identifier.LineNumber)
+		}
+		identifierType = variable.Type
+		p.reference(identifier, variable, false)
+	} else {
+		// create the variable in the current scope
+		variable = &NodeTermIdentifier{
+			Type:       identifierType,
+			Identifier: identifier.Value,
+		}
+		p.declare(variable)
+		p.reference(identifier, variable, true)
+	}
+
 	assignment := &NodeAssignment{
 		Identifier: identifier.Value,
 		Type:       identifierType,
 		Expression: &expression,
+		Annotated:  annotated,
+		Variable:   variable,
+		LineNumber: identifier.LineNumber,
 	}
+	return assignment, nil
+}
 
-	// create the variable in the identifiers map
-	p.program.identifiers[identifier.Value] = &NodeTermIdentifier{
-		Type:       identifierType,
-		Identifier: identifier.Value,
+// parseExpressionStatement parses an expression used as a statement, e.g. `print(x);`
+func (p *Parser) parseExpressionStatement() (*NodeExpressionStatement, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return nil, ExpectedError("statement but found nothing", 0)
 	}
-	return assignment, nil
+	line := token.LineNumber
+	expression, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	// consume the `;`
+	token, err = p.tokens.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenSemicolon})
+	if err != nil {
+		return nil, err
+	}
+	p.tokens.Pop()
+
+	return &NodeExpressionStatement{
+		Expression: expression,
+		LineNumber: line,
+	}, nil
 }
 
 func (p *Parser) parseStatement() (NodeScopedStatement, error) {
@@ -124,8 +214,30 @@ func (p *Parser) parseStatement() (NodeScopedStatement, error) {
 	if err != nil {
 		return nil, ExpectedError("token but found nothing", 0)
 	}
-	if token.Type == lexer.TokenKeyword && token.Value == "return" {
-		return p.parseReturn()
+	start := position(token)
+	// `name(` starts a call and `name[` an assignment to an element
+	after := ""
+	if nextToken, err := p.tokens.Peek(1); err == nil && token.Type == lexer.TokenIdentifier && nextToken.Type == lexer.TokenPunctuation {
+		after = nextToken.Value
+	}
+	var statement NodeScopedStatement
+	switch {
+	case token.Type == lexer.TokenKeyword && token.Value == "return":
+		statement, err = p.parseReturn()
+	case token.Type == lexer.TokenKeyword && token.Value == "for":
+		statement, err = p.parseForIn()
+	case token.Type == lexer.TokenKeyword && token.Value == "if":
+		statement, err = p.parseConditional(false)
+	case after == "(":
+		statement, err = p.parseExpressionStatement()
+	case after == "[":
+		statement, err = p.parseIndexAssignment()
+	default:
+		statement, err = p.parseAssignment()
+	}
+	if err != nil {
+		return nil, err
 	}
-	return p.parseAssignment()
+	p.setSpan(statement, start)
+	return statement, nil
 }
@@ -0,0 +1,112 @@
+package parser
+
+import (
+	"fmt"
+	"iter"
+	"shake/lexer"
+)
+
+// tokens pulls tokens from the lexer as the parser asks for them and only
+// keeps the ones it looked ahead at
+type tokens struct {
+	seq    iter.Seq[lexer.Token]
+	next   func() (lexer.Token, bool)
+	stop   func()
+	buffer []lexer.Token
+	done   bool
+	// the last token popped, where the node being parsed ends so far
+	last lexer.Token
+}
+
+func newTokens(seq iter.Seq[lexer.Token]) *tokens {
+	return &tokens{seq: seq}
+}
+
+// fill pulls tokens until there are n buffered or the tokens end. Pulling
+// starts with the first token, so a parser that never parses holds nothing.
+func (t *tokens) fill(n int) {
+	if t.next == nil && !t.done {
+		t.next, t.stop = iter.Pull(t.seq)
+	}
+	for !t.done && len(t.buffer) < n {
+		token, ok := t.next()
+		if !ok {
+			t.done = true
+			t.stop()
+			break
+		}
+		t.buffer = append(t.buffer, token)
+	}
+}
+
+// Peek returns the token offset tokens ahead without removing it
+func (t *tokens) Peek(offset int) (*lexer.Token, error) {
+	t.fill(offset + 1)
+	if offset < 0 || offset >= len(t.buffer) {
+		return nil, fmt.Errorf("offset: %d not in range: %d", offset, len(t.buffer))
+	}
+	return &t.buffer[offset], nil
+}
+
+// Pop removes and returns the next token, nil when there are none. The parser
+// keeps some tokens, so every token is a copy.
+func (t *tokens) Pop() *lexer.Token {
+	t.fill(1)
+	if len(t.buffer) == 0 {
+		return nil
+	}
+	token := t.buffer[0]
+	t.buffer = t.buffer[1:]
+	t.last = token
+	return &token
+}
+
+func (t *tokens) TryPop() (*lexer.Token, error) {
+	_, err := t.Peek(0)
+	if err != nil {
+		return nil, err
+	}
+	return t.Pop(), nil
+}
+
+// close stops pulling tokens the parser will not use
+func (t *tokens) close() {
+	if !t.done && t.stop != nil {
+		t.stop()
+	}
+	t.done = true
+}
+
+// newBufferedTokens returns tokens that were already pulled, such as the body
+// of a function the parser comes back to
+func newBufferedTokens(buffer []lexer.Token) *tokens {
+	return &tokens{buffer: buffer, done: true}
+}
+
+// skipBody removes the tokens of a function body, a scope from `{` to its `}`
+// or an inline `: expression;`, and returns them. A body cut short returns
+// what there is, parsing it reports what is missing.
+func (t *tokens) skipBody() []lexer.Token {
+	body := []lexer.Token{}
+	depth := 0
+	for {
+		token, err := t.TryPop()
+		if err != nil {
+			return body
+		}
+		body = append(body, *token)
+		switch {
+		case token.Type == lexer.TokenPunctuation && token.Value == "{":
+			depth++
+		case token.Type == lexer.TokenPunctuation && token.Value == "}":
+			depth--
+			if depth == 0 && body[0].Value == "{" {
+				return body
+			}
+		case token.Type == lexer.TokenSemicolon:
+			if depth == 0 {
+				return body
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"shake/lexer"
+	"shake/types"
+	"strconv"
+)
+
+// maxArrayLength is the longest array, the bytecode keeps lengths in two bytes
+const maxArrayLength = math.MaxUint16
+
+// parseType parses a type such as `int32`, `[3]int32`, `[]int32`, `map[int32]int64`
+// or `fn(int32, int32): int32`. The lexer does not know types, an identifier
+// is a type because the grammar expects one where it is.
+func (p *Parser) parseType() (types.Type, error) {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return types.TypeUnknown, ExpectedError("type but found nothing", 0)
+	}
+
+	switch {
+	case token.Type == lexer.TokenPunctuation && token.Value == "[":
+		p.tokens.Pop()
+		// `[]` is a slice, `[N]` is an array
+		lengthToken, err := p.tokens.Peek(0)
+		if err != nil {
+			return types.TypeUnknown, ExpectedError("`]` or array length but found nothing", token.LineNumber)
+		}
+		length := -1
+		if lengthToken.Type == lexer.TokenNumber {
+			length, err = strconv.Atoi(lengthToken.Value)
+			if err != nil {
+				return types.TypeUnknown, Error(fmt.Sprintf("Invalid array length: %s", lengthToken.Value), lengthToken.LineNumber)
+			}
+			if length > maxArrayLength {
+				return types.TypeUnknown, Error(fmt.Sprintf("Array length: %d is over the limit of %d", length, maxArrayLength), lengthToken.LineNumber)
+			}
+			p.tokens.Pop()
+		}
+		err = p.expectPunctuation("]")
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		if length < 0 {
+			return types.Slice(elem), nil
+		}
+		return types.Array(elem, length), nil
+	case token.Type == lexer.TokenKeyword && token.Value == "map":
+		p.tokens.Pop()
+		err = p.expectPunctuation("[")
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		key, err := p.parseType()
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		if key.IsCollection() || key.Kind() == types.KindInterface {
+			return types.TypeUnknown, Error(fmt.Sprintf("Invalid map key type: %s", key), token.LineNumber)
+		}
+		err = p.expectPunctuation("]")
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		return types.Map(key, elem), nil
+	case token.Type == lexer.TokenKeyword && token.Value == "fn":
+		p.tokens.Pop()
+		err = p.expectPunctuation("(")
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		params := []types.Type{}
+		for {
+			currToken, err := p.tokens.Peek(0)
+			if err != nil {
+				return types.TypeUnknown, ExpectedError("`)` but found nothing", token.LineNumber)
+			}
+			if currToken.Type == lexer.TokenPunctuation && currToken.Value == ")" {
+				p.tokens.Pop()
+				break
+			}
+			if len(params) > 0 {
+				err = p.expectPunctuation(",")
+				if err != nil {
+					return types.TypeUnknown, err
+				}
+			}
+			param, err := p.parseType()
+			if err != nil {
+				return types.TypeUnknown, err
+			}
+			params = append(params, param)
+		}
+		err = p.expectPunctuation(":")
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		result, err := p.parseType()
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		return types.Function(params, result), nil
+	case token.Type == lexer.TokenIdentifier:
+		p.tokens.Pop()
+		name, err := p.parseQualified(token.Value)
+		if err != nil {
+			return types.TypeUnknown, err
+		}
+		parsedType := p.program.types.Lookup(name)
+		if parsedType == types.TypeUnknown {
+			return types.TypeUnknown, Error(fmt.Sprintf("Unknown type: %s", name), token.LineNumber)
+		}
+		return parsedType, nil
+	default:
+		return types.TypeUnknown, ExpectedError(fmt.Sprintf("type but found: %s", token.Value), token.LineNumber)
+	}
+}
+
+// parseQualified parses the rest of a name such as `math.Vec` after its
+// first identifier and returns the whole name
+func (p *Parser) parseQualified(name string) (string, error) {
+	for {
+		token, err := p.tokens.Peek(0)
+		if err != nil || token.Type != lexer.TokenPunctuation || token.Value != "." {
+			return name, nil
+		}
+		p.tokens.Pop()
+		token, err = p.tokens.Peek(0)
+		if err != nil {
+			return "", ExpectedError("identifier after `.` but found nothing", 0)
+		}
+		err = expectToken(token, lexer.Token{Type: lexer.TokenIdentifier})
+		if err != nil {
+			return "", err
+		}
+		p.tokens.Pop()
+		name += "." + token.Value
+	}
+}
+
+// expectPunctuation consumes the given punctuation or errors
+func (p *Parser) expectPunctuation(value string) error {
+	token, err := p.tokens.Peek(0)
+	if err != nil {
+		return ExpectedError(fmt.Sprintf("`%s` but found nothing", value), 0)
+	}
+	err = expectToken(token, lexer.Token{Type: lexer.TokenPunctuation, Value: value})
+	if err != nil {
+		return err
+	}
+	p.tokens.Pop()
+	return nil
+}
@@ -3,6 +3,7 @@ package queue
 import (
 	"encoding/json"
 	"fmt"
+	"iter"
 )
 
 type Queue[T any] struct {
@@ -54,6 +55,17 @@ func (q *Queue[T]) Peek(offset int) (*T, error) {
 	return &q.items[offset+q.head], nil
 }
 
+// All yields the elements from the front of the queue, removing each one as it is yielded.
+func (q *Queue[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for q.Size() > 0 {
+			if !yield(*q.Pop()) {
+				return
+			}
+		}
+	}
+}
+
 // NewQueue creates a new empty queue.
 func NewQueue[T any]() *Queue[T] {
 	return &Queue[T]{
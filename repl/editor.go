@@ -0,0 +1,214 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// ErrInterrupted is returned by ReadLine when Ctrl-C abandons the line
+var ErrInterrupted = errors.New("interrupted")
+
+// Editor reads lines from a terminal with line editing and history. When the
+// input is not a terminal, as when a file is piped in, it reads plain lines
+// and prints no prompts. The editing needs a Unix terminal, on other systems
+// the terminal edits the lines itself.
+type Editor struct {
+	in       *os.File
+	reader   *bufio.Reader
+	out      io.Writer
+	terminal bool
+	history  []string
+}
+
+func NewEditor(in *os.File, out io.Writer) *Editor {
+	return &Editor{
+		in:       in,
+		reader:   bufio.NewReader(in),
+		out:      out,
+		terminal: isTerminal(in),
+	}
+}
+
+// Terminal reports whether the lines are read from a terminal
+func (e *Editor) Terminal() bool {
+	return e.terminal
+}
+
+// ReadLine prints the prompt and returns the next line without its line
+// ending, or io.EOF once the input ends
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	if !e.terminal {
+		return e.readPlain()
+	}
+	restore, err := makeRaw(int(e.in.Fd()))
+	if err != nil {
+		// the terminal cannot be put in raw mode, let it edit the line
+		fmt.Fprint(e.out, prompt)
+		return e.readPlain()
+	}
+	defer restore()
+
+	line, err := e.edit(prompt)
+	if err == nil && strings.TrimSpace(line) != "" && (len(e.history) == 0 || e.history[len(e.history)-1] != line) {
+		e.history = append(e.history, line)
+	}
+	return line, err
+}
+
+func (e *Editor) readPlain() (string, error) {
+	line, err := e.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+func ctrl(key rune) rune {
+	return key & 0x1f
+}
+
+// edit reads keys until the line is entered. The line is redrawn after every
+// key, which assumes it fits in the width of the terminal.
+func (e *Editor) edit(prompt string) (string, error) {
+	line := []rune{}
+	cursor := 0
+	// the history entry shown, len(e.history) is the line being written and
+	// draft keeps it while older entries are shown
+	index := len(e.history)
+	draft := line
+
+	show := func(i int) {
+		if i < 0 || i > len(e.history) || i == index {
+			return
+		}
+		if index == len(e.history) {
+			draft = line
+		}
+		index = i
+		if index == len(e.history) {
+			line = draft
+		} else {
+			line = []rune(e.history[index])
+		}
+		cursor = len(line)
+	}
+
+	e.refresh(prompt, line, cursor)
+	for {
+		key, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case ctrl('c'):
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case ctrl('d'):
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if cursor < len(line) {
+				line = append(line[:cursor:cursor], line[cursor+1:]...)
+			}
+		case ctrl('a'):
+			cursor = 0
+		case ctrl('e'):
+			cursor = len(line)
+		case ctrl('b'):
+			cursor = max(cursor-1, 0)
+		case ctrl('f'):
+			cursor = min(cursor+1, len(line))
+		case ctrl('k'):
+			line = line[:cursor:cursor]
+		case ctrl('u'):
+			line = line[cursor:]
+			cursor = 0
+		case ctrl('w'):
+			start := cursor
+			for start > 0 && unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(line[start-1]) {
+				start--
+			}
+			line = append(line[:start:start], line[cursor:]...)
+			cursor = start
+		case ctrl('p'):
+			show(index - 1)
+		case ctrl('n'):
+			show(index + 1)
+		case ctrl('l'):
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case ctrl('h'), 127:
+			if cursor > 0 {
+				line = append(line[:cursor-1:cursor-1], line[cursor:]...)
+				cursor--
+			}
+		case '\t':
+			line = append(line[:cursor:cursor], append([]rune("  "), line[cursor:]...)...)
+			cursor += 2
+		case 0x1b:
+			switch e.escape() {
+			case "A":
+				show(index - 1)
+			case "B":
+				show(index + 1)
+			case "C":
+				cursor = min(cursor+1, len(line))
+			case "D":
+				cursor = max(cursor-1, 0)
+			case "H", "1~", "7~":
+				cursor = 0
+			case "F", "4~", "8~":
+				cursor = len(line)
+			case "3~":
+				if cursor < len(line) {
+					line = append(line[:cursor:cursor], line[cursor+1:]...)
+				}
+			}
+		default:
+			if unicode.IsPrint(key) {
+				line = append(line[:cursor:cursor], append([]rune{key}, line[cursor:]...)...)
+				cursor++
+			}
+		}
+		e.refresh(prompt, line, cursor)
+	}
+}
+
+// escape reads the rest of an escape sequence such as `ESC [ A` for the up
+// arrow and returns it without the `ESC [`, or `ESC O` some terminals send
+func (e *Editor) escape() string {
+	key, _, err := e.reader.ReadRune()
+	if err != nil || (key != '[' && key != 'O') {
+		return ""
+	}
+	sequence := []rune{}
+	for {
+		key, _, err = e.reader.ReadRune()
+		if err != nil {
+			return ""
+		}
+		sequence = append(sequence, key)
+		// the parameters are digits and `;`, the sequence ends with anything else
+		if !unicode.IsDigit(key) && key != ';' {
+			return string(sequence)
+		}
+	}
+}
+
+func (e *Editor) refresh(prompt string, line []rune, cursor int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+	if back := len(line) - cursor; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
@@ -0,0 +1,121 @@
+package repl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+const (
+	prompt = "> "
+	// the prompt of the lines after the first of an input with unclosed braces
+	continuation = "... "
+)
+
+const help = `Enter an expression to print its value and type, or an assignment, a
+statement, a function or an interface to add it to the session.
+Inputs with unclosed braces, brackets or parentheses continue on the next line.
+
+:type <expression>    print the type of an expression
+:ast <expression>     print the syntax tree of an expression as JSON
+:tokens <expression>  print the tokens of an expression as JSON
+:reset                forget everything entered so far
+:help                 print this help
+:quit                 leave, as does Ctrl-D
+
+Keys: arrows, Home, End, Ctrl-A/E/B/F to move, Ctrl-K/U/W to delete,
+Up, Down, Ctrl-P/N for the history and Ctrl-C to abandon the input.`
+
+var errQuit = errors.New("quit")
+
+// Run reads inputs from in and prints their results to out until the input
+// ends or `:quit` is entered
+func Run(in *os.File, out io.Writer) error {
+	editor := NewEditor(in, out)
+	session := NewSession()
+	if editor.Terminal() {
+		fmt.Fprintln(out, "shake repl, :help lists the commands")
+	}
+	for {
+		input, err := read(editor)
+		if err == ErrInterrupted {
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Ctrl-C stops a program that runs for too long
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		session.Context = ctx
+		output, err := eval(session, input)
+		stop()
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(out, "error: %s\n", Message(err))
+			continue
+		}
+		if output != "" {
+			fmt.Fprintln(out, output)
+		}
+	}
+}
+
+// read reads an input, which goes on over several lines until its braces are closed
+func read(editor *Editor) (string, error) {
+	input, err := editor.ReadLine(prompt)
+	if err != nil {
+		return "", err
+	}
+	// commands are always a single line
+	for !strings.HasPrefix(strings.TrimSpace(input), ":") && !Complete(input) {
+		line, err := editor.ReadLine(continuation)
+		if err == io.EOF {
+			// the input ended in the middle, what was read gets its error
+			return input, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		input += "\n" + line
+	}
+	return input, nil
+}
+
+// eval runs a command or evaluates an input in the session
+func eval(session *Session, input string) (string, error) {
+	trimmed := strings.TrimSpace(input)
+	if !strings.HasPrefix(trimmed, ":") {
+		return session.Eval(input)
+	}
+	name, argument, _ := strings.Cut(trimmed[1:], " ")
+	switch name {
+	case "type":
+		t, err := session.Type(argument)
+		if err != nil {
+			return "", err
+		}
+		return t.String(), nil
+	case "ast":
+		return session.AST(argument)
+	case "tokens":
+		return Tokens(argument)
+	case "reset":
+		*session = Session{declarations: map[string]string{}, Context: session.Context}
+		return "", nil
+	case "help":
+		return help, nil
+	case "quit", "q":
+		return "", errQuit
+	}
+	return "", fmt.Errorf("unknown command: :%s, :help lists the commands", name)
+}
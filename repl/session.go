@@ -0,0 +1,473 @@
+package repl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"shake/ast"
+	"shake/bytecode"
+	"shake/lexer"
+	"shake/parser"
+	"shake/types"
+	"shake/vm"
+	"sort"
+	"strings"
+)
+
+// function is the name of the function every input is evaluated in
+const function = "__repl"
+
+// value is the variable the type of an expression is found with
+const value = "__value"
+
+// Session is the program the inputs of a REPL build up. Every input is
+// compiled on its own, with the variables of the earlier inputs declared in
+// the program scope and their values kept in the globals of the VM.
+type Session struct {
+	// the source of the functions and interfaces in the order they were first
+	// declared, declaring a name again replaces it
+	names        []string
+	declarations map[string]string
+	// the variables assigned so far by the index of their global, assigning
+	// a variable again with a type replaces it
+	variables []variable
+	globals   []vm.Value
+	// the context every input runs with, the REPL cancels it on Ctrl-C
+	Context context.Context
+}
+
+// variable is a variable of an earlier input
+type variable struct {
+	name string
+	t    types.Type
+}
+
+func NewSession() *Session {
+	return &Session{declarations: map[string]string{}, Context: context.Background()}
+}
+
+// Complete reports whether input closes all the braces, brackets and
+// parentheses it opens, the REPL keeps reading lines until it does
+func Complete(input string) bool {
+	l := lexer.NewLexer(strings.NewReader(input))
+	depth := 0
+	for token := range l.All() {
+		if token.Type != lexer.TokenPunctuation {
+			continue
+		}
+		switch token.Value {
+		case "{", "(", "[":
+			depth++
+		case "}", ")", "]":
+			depth--
+		}
+	}
+	// the error is reported once the input is evaluated
+	return depth <= 0 || l.Err() != nil
+}
+
+// Eval runs one input and returns what to print for it: the value and type of
+// an expression, the variable of an assignment or the type of a declaration
+func (s *Session) Eval(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", nil
+	}
+	if name, ok := declaration(input); ok {
+		return s.declare(name, input)
+	}
+
+	expression := strings.TrimSpace(strings.TrimSuffix(input, ";"))
+	t, err := s.typeOf(expression)
+	if err == nil {
+		result, err := s.run(nil, expression, t)
+		if err != nil {
+			return "", err
+		}
+		return describe(result, t), nil
+	}
+	if !isStatement(input) {
+		return "", err
+	}
+
+	// the semicolon is optional, but a statement ending with a block has none
+	statement := input
+	if !strings.HasSuffix(statement, ";") {
+		statement += ";"
+	}
+	program, _, err := s.parse(s.source([]string{statement}, "", types.TypeEmpty))
+	if err != nil && strings.HasSuffix(input, "}") {
+		var retried error
+		program, _, retried = s.parse(s.source([]string{input}, "", types.TypeEmpty))
+		if retried == nil {
+			statement, err = input, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	_, err = s.run([]string{statement}, "", types.TypeEmpty)
+	if err != nil {
+		return "", err
+	}
+
+	assignment, ok := last(program).(*parser.NodeAssignment)
+	if !ok {
+		return "", nil
+	}
+	t = assignment.Variable.Type
+	if t.Kind() == types.KindFunction {
+		return fmt.Sprintf("%s: %s", assignment.Identifier, t), nil
+	}
+	return fmt.Sprintf("%s: %s = %s", assignment.Identifier, t, format(s.globals[s.global(assignment.Identifier)], t)), nil
+}
+
+// Type returns the type of an expression without running it
+func (s *Session) Type(expression string) (types.Type, error) {
+	return s.typeOf(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(expression), ";")))
+}
+
+// AST returns the syntax tree of an expression as the JSON of `parse --format json`
+func (s *Session) AST(expression string) (string, error) {
+	expression = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(expression), ";"))
+	program, _, err := s.parse(s.source([]string{value + " = " + expression + ";"}, "", types.TypeEmpty))
+	if err != nil {
+		return "", err
+	}
+	document, err := ast.FromProgram(program)
+	if err != nil {
+		return "", err
+	}
+	// the assignment to the value is the last statement of the last function
+	body := document.Statements[len(document.Statements)-1].Body
+	encoded, err := json.MarshalIndent(body[len(body)-1].Value, "", "  ")
+	return string(encoded), err
+}
+
+// Tokens returns the tokens of an expression as the JSON of `lex`
+func Tokens(expression string) (string, error) {
+	tokens, err := lexer.Lex(strings.NewReader(expression))
+	if err != nil {
+		return "", err
+	}
+	encoded, err := json.MarshalIndent(tokens, "", "  ")
+	return string(encoded), err
+}
+
+// isStatement reports whether input starts with the keyword of a statement or
+// assigns a variable, the errors of other inputs are the errors of an expression
+func isStatement(input string) bool {
+	tokens, err := lexer.Lex(strings.NewReader(input))
+	if err != nil || tokens.Size() == 0 {
+		return false
+	}
+	first := tokens.Pop()
+	if first.Type == lexer.TokenKeyword && (first.Value == "if" || first.Value == "for" || first.Value == "return") {
+		return true
+	}
+	for tokens.Size() > 0 {
+		token := tokens.Pop()
+		if token.Type == lexer.TokenOperation && token.Value == "=" {
+			return true
+		}
+		// the assignments inside blocks belong to an expression
+		if token.Type == lexer.TokenPunctuation && token.Value == "{" {
+			return false
+		}
+	}
+	return false
+}
+
+// declaration returns the name of the function or interface input declares,
+// or `Type.Name` for a method. A function without a name is an expression.
+func declaration(input string) (string, bool) {
+	tokens, err := lexer.Lex(strings.NewReader(input))
+	if err != nil || tokens.Size() < 2 {
+		return "", false
+	}
+	first := tokens.Pop()
+	// decorators such as `(entry)` come before a function
+	if first.Type == lexer.TokenPunctuation && first.Value == "(" {
+		for tokens.Size() > 0 && !(first.Type == lexer.TokenPunctuation && first.Value == ")") {
+			first = tokens.Pop()
+		}
+		if tokens.Size() < 2 {
+			return "", false
+		}
+		first = tokens.Pop()
+	}
+	name := tokens.Pop()
+	if first.Type != lexer.TokenKeyword || (first.Value != "fn" && first.Value != "interface") {
+		return "", false
+	}
+	// the receiver of a method: `(s: Square)`
+	receiver := ""
+	if first.Value == "fn" && name.Type == lexer.TokenPunctuation && name.Value == "(" {
+		// the type is everything after the `:` up to the `)` that closes the receiver
+		typed, depth := false, 0
+		for tokens.Size() > 0 {
+			token := tokens.Pop()
+			if token.Type == lexer.TokenPunctuation && token.Value == "(" {
+				depth++
+			}
+			if token.Type == lexer.TokenPunctuation && token.Value == ")" {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+			if typed {
+				receiver += token.Value
+			} else {
+				typed = token.Type == lexer.TokenPunctuation && token.Value == ":"
+			}
+		}
+		if tokens.Size() == 0 {
+			return "", false
+		}
+		name = tokens.Pop()
+		receiver += "."
+	}
+	if name.Type != lexer.TokenIdentifier {
+		return "", false
+	}
+	return receiver + name.Value, true
+}
+
+func (s *Session) declare(name string, input string) (string, error) {
+	previous, declared := s.declarations[name]
+	s.declarations[name] = input
+	if !declared {
+		s.names = append(s.names, name)
+	}
+	program, variables, err := s.parse(s.source(nil, "", types.TypeEmpty))
+	if err == nil {
+		_, err = bytecode.CompileGlobals(program, variables)
+	}
+	if err != nil {
+		if declared {
+			s.declarations[name] = previous
+		} else {
+			delete(s.declarations, name)
+			s.names = s.names[:len(s.names)-1]
+		}
+		return "", err
+	}
+
+	for _, statement := range program.Statements() {
+		switch statement := statement.(type) {
+		case *parser.NodeFunction:
+			if statement.QualifiedName() == name {
+				return fmt.Sprintf("%s: %s", name, statement.GetType()), nil
+			}
+		case *parser.NodeInterface:
+			if statement.Name() == name {
+				return fmt.Sprintf("interface %s", name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// source returns the declarations and a function running the statements,
+// then returning result of type t
+func (s *Session) source(statements []string, result string, t types.Type) string {
+	var b strings.Builder
+	for _, name := range s.names {
+		b.WriteString(s.declarations[name])
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "fn %s(): %s {\n", function, t)
+	for _, statement := range statements {
+		b.WriteString(statement)
+		b.WriteString("\n")
+	}
+	if result != "" {
+		fmt.Fprintf(&b, "return %s;\n", result)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// parse parses a program with the variables of the session declared and
+// returns them by the index of their global. The parser panics on some broken
+// inputs and the REPL reports those as errors instead of stopping.
+func (s *Session) parse(source string) (program *parser.NodeProgram, variables map[*parser.NodeTermIdentifier]int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			program, variables, err = nil, nil, fmt.Errorf("the parser failed: %v", r)
+		}
+	}()
+	l := lexer.NewLexer(strings.NewReader(source))
+	p := parser.NewParser(l.All())
+	variables = make(map[*parser.NodeTermIdentifier]int, len(s.variables))
+	for i, variable := range s.variables {
+		declared, err := p.DeclareVariable(variable.name, variable.t)
+		if err != nil {
+			return nil, nil, err
+		}
+		variables[declared] = i
+	}
+	program, err = p.ParseProgram()
+	if l.Err() != nil {
+		return nil, nil, l.Err()
+	}
+	return program, variables, err
+}
+
+// global returns the index of the global of the variable named name, or -1
+func (s *Session) global(name string) int {
+	for i, variable := range s.variables {
+		if variable.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// typeOf finds the type of an expression from the variable it is assigned to
+func (s *Session) typeOf(expression string) (types.Type, error) {
+	program, _, err := s.parse(s.source([]string{value + " = " + expression + ";"}, "", types.TypeEmpty))
+	if err != nil {
+		return types.TypeUnknown, err
+	}
+	assignment, ok := last(program).(*parser.NodeAssignment)
+	if !ok || assignment.Identifier != value {
+		return types.TypeUnknown, fmt.Errorf("not an expression: %s", expression)
+	}
+	return assignment.Variable.Type, nil
+}
+
+// evaluated returns the function inputs are evaluated in
+func evaluated(program *parser.NodeProgram) *parser.NodeFunction {
+	for _, statement := range program.Statements() {
+		if declared, ok := statement.(*parser.NodeFunction); ok && declared.Name() == function && declared.Receiver() == nil {
+			return declared
+		}
+	}
+	return nil
+}
+
+// last returns the last statement of the function inputs are evaluated in
+func last(program *parser.NodeProgram) parser.NodeScopedStatement {
+	declared := evaluated(program)
+	if declared == nil {
+		return nil
+	}
+	statements := declared.Scope().Statements()
+	if len(statements) == 0 {
+		return nil
+	}
+	return statements[len(statements)-1]
+}
+
+// run runs the statements, then returns the value of result, which has type
+// t. The variables the statements assign outside of blocks become variables
+// of the session once they ran.
+func (s *Session) run(statements []string, result string, t types.Type) (vm.Value, error) {
+	program, variables, err := s.parse(s.source(statements, result, t))
+	if err != nil {
+		return vm.Value{}, err
+	}
+	declared := evaluated(program)
+	if declared == nil {
+		return vm.Value{}, fmt.Errorf("function: %s was not parsed", function)
+	}
+
+	// assigning a variable of the session with a type declares it again,
+	// with the same global and the new type
+	assigned := append([]variable{}, s.variables...)
+	for _, identifier := range declared.Scope().Identifiers() {
+		index := s.global(identifier.Identifier)
+		if index < 0 {
+			index = len(assigned)
+			assigned = append(assigned, variable{})
+		}
+		assigned[index] = variable{name: identifier.Identifier, t: identifier.Type}
+		variables[identifier] = index
+	}
+
+	compiled, err := bytecode.CompileGlobals(program, variables)
+	if err != nil {
+		return vm.Value{}, err
+	}
+	index, ok := compiled.FunctionIndex(function)
+	if !ok {
+		return vm.Value{}, fmt.Errorf("function: %s was not compiled", function)
+	}
+	globals := append(s.globals, make([]vm.Value, len(assigned)-len(s.globals))...)
+	machine := vm.New(compiled)
+	err = machine.SetGlobals(globals)
+	if err != nil {
+		return vm.Value{}, err
+	}
+	value, err := machine.CallContext(s.Context, index)
+	// the globals that were assigned before the error keep their new values
+	s.globals = globals[:len(s.globals)]
+	if err != nil {
+		return vm.Value{}, err
+	}
+	s.variables, s.globals = assigned, globals
+	return value, nil
+}
+
+// Message returns what the REPL prints for err, the lines of the errors are
+// lines of the program the session builds and mean nothing to the user
+func Message(err error) string {
+	var diagnostic *parser.Diagnostic
+	if errors.As(err, &diagnostic) {
+		return diagnostic.Reason
+	}
+	var runtime *vm.RuntimeError
+	if errors.As(err, &runtime) {
+		return runtime.Reason
+	}
+	return err.Error()
+}
+
+// describe returns the value of an expression and its type, functions are
+// only their type and expressions without a value print nothing
+func describe(v vm.Value, t types.Type) string {
+	if t == types.TypeEmpty {
+		return ""
+	}
+	if t.Kind() == types.KindFunction {
+		return t.String()
+	}
+	return fmt.Sprintf("%s: %s", format(v, t), t)
+}
+
+// format returns a value the way it is written in the source
+func format(v vm.Value, t types.Type) string {
+	switch t.Kind() {
+	case types.KindArray, types.KindSlice:
+		elements, _ := v.Ref.([]vm.Value)
+		formatted := make([]string, len(elements))
+		for i, element := range elements {
+			formatted[i] = format(element, t.Elem())
+		}
+		return "[" + strings.Join(formatted, ", ") + "]"
+	case types.KindMap:
+		elements, _ := v.Ref.(map[int64]vm.Value)
+		keys := make([]int64, 0, len(elements))
+		for key := range elements {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		formatted := make([]string, len(keys))
+		for i, key := range keys {
+			formatted[i] = fmt.Sprintf("%s: %s", format(vm.Int(key), t.Key()), format(elements[key], t.Elem()))
+		}
+		return "map[" + strings.Join(formatted, ", ") + "]"
+	case types.KindFunction:
+		return t.String()
+	}
+	switch t {
+	case types.TypeEmpty:
+		return "empty"
+	case types.TypeBool:
+		return fmt.Sprint(v.Bool())
+	}
+	return v.String()
+}
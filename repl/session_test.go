@@ -0,0 +1,171 @@
+package repl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"shake/repl"
+	"strings"
+	"testing"
+)
+
+// eval enters the inputs in a new session and returns what each printed, or
+// its error
+func eval(t *testing.T, inputs ...string) []string {
+	t.Helper()
+	session := repl.NewSession()
+	outputs := make([]string, len(inputs))
+	for i, input := range inputs {
+		output, err := session.Eval(input)
+		if err != nil {
+			output = "error: " + repl.Message(err)
+		}
+		outputs[i] = output
+	}
+	return outputs
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []string
+		want   []string
+	}{
+		{"expression", []string{"1 + 2", "[]int32{1, 2}", "map[int32]bool{2: true, 1: false}"}, []string{"3: int32", "[1, 2]: []int32", "map[1: false, 2: true]: map[int32]bool"}},
+		{"assignment", []string{"x = 20", "x = x + 1;", "x * 2"}, []string{"x: int32 = 20", "x: int32 = 21", "42: int32"}},
+		{"retyped", []string{"x = 1", "x: bool = true", "x"}, []string{"x: int32 = 1", "x: bool = true", "true: bool"}},
+		{"declare", []string{"fn twice(n: int32): int32: n * 2;", "twice(4)"}, []string{"twice: fn(int32): int32", "8: int32"}},
+		{"redeclare", []string{"fn f(): int32: 1;", "g = f", "fn f(): bool: true;", "f()", "g()"}, []string{"f: fn(): int32", "g: fn(): int32", "f: fn(): bool", "true: bool", "1: int32"}},
+		{"broken declaration", []string{"fn f(): int32: 1;", "fn f(): int32: true;", "f()"}, []string{"f: fn(): int32", "error: Type of scope: int32 is different from return type: bool", "1: int32"}},
+		{"interface", []string{"interface Shape {\n    Area(): int32;\n}", "fn (side: int32) Area(): int32: side * side;", "s: Shape = 3", "s.Area()"}, []string{"interface Shape", "int32.Area: fn(): int32", "s: Shape = 3", "9: int32"}},
+		{"statement", []string{"n = 0", "for i, x in []int32{1, 2, 3} {\n    n = n + x;\n}", "n"}, []string{"n: int32 = 0", "", "6: int32"}},
+		// the variables a closure changes keep their values, the closure
+		// does not run again for later inputs
+		{"closure", []string{"count = 0", "next = fn(): int32 {\n    count = count + 1;\n    return count;\n}", "next()", "next()", "count"}, []string{"count: int32 = 0", "next: fn(): int32", "1: int32", "2: int32", "2: int32"}},
+		{"failure", []string{"xs = []int32{1}", "y = xs[3]", "y", "xs"}, []string{"xs: []int32 = [1]", "error: index 3 out of bounds for length 1", "error: Identifier: y of type: Identifier does not exist in the current scope", "[1]: []int32"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := eval(t, test.inputs...)
+			for i := range test.want {
+				if got[i] != test.want[i] {
+					t.Errorf("input %q: got %q, want %q", test.inputs[i], got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	session := repl.NewSession()
+	_, err := session.Eval("xs = []int64{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := session.Type("len(xs) > 0")
+	if err != nil || got.String() != "bool" {
+		t.Errorf(":type returned %v, %v, want bool", got, err)
+	}
+	_, err = session.Type("missing")
+	if err == nil {
+		t.Error(":type of an undeclared identifier returned no error")
+	}
+
+	encoded, err := session.AST("xs[0] + xs[0]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node struct {
+		Kind     string
+		Operator string
+		Type     string
+		Left     struct{ Kind string }
+	}
+	err = json.Unmarshal([]byte(encoded), &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Kind != "binary" || node.Operator != "+" || node.Type != "int64" || node.Left.Kind != "index" {
+		t.Errorf(":ast returned %s, want the binary of an index", encoded)
+	}
+
+	encoded, err = repl.Tokens("1 /* one */ + x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []map[string]any
+	err = json.Unmarshal([]byte(encoded), &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := []string{}
+	for _, token := range tokens {
+		if _, ok := token["Leading"]; ok {
+			t.Errorf(":tokens printed the trivia of %v", token)
+		}
+		values = append(values, token["Value"].(string))
+	}
+	if strings.Join(values, " ") != "1 + x" {
+		t.Errorf(":tokens returned %v, want 1 + x", values)
+	}
+}
+
+// TestRun checks the lines of an input with open braces are read as one input
+func TestRun(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input")
+	err := os.WriteFile(input, []byte(`fn sum(xs: []int32): int32 {
+    n = 0;
+    for i, x in xs {
+        n = n + x;
+    }
+    return n;
+}
+sum([]int32{
+    1, 2,
+    3,
+})
+:type sum
+:nope
+:quit
+1 + 1
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out := &bytes.Buffer{}
+	err = repl.Run(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `sum: fn([]int32): int32
+6: int32
+fn([]int32): int32
+error: unknown command: :nope, :help lists the commands
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tests := map[string]bool{
+		"1 + 2":                     true,
+		"fn f(): int32 {":           false,
+		"xs = []int32{1,\n2}":       true,
+		"f(1,":                      false,
+		"s = \"}\"":                 true,
+		"x = map[int32]int32{1: (2": false,
+	}
+	for input, want := range tests {
+		if got := repl.Complete(input); got != want {
+			t.Errorf("Complete(%q) = %v, want %v", input, got, want)
+		}
+	}
+}
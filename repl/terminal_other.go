@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package repl
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is only supported on Unix systems, elsewhere the editor prints the
+// prompt and reads whole lines, which the terminal edits without the keys of
+// the editor or the history
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.New("raw terminals are not supported on this system")
+}
+
+// isTerminal reports whether file is a character device, as consoles are
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,9 @@
+package repl
+
+import "golang.org/x/sys/unix"
+
+// the requests that get and set the attributes of a terminal
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
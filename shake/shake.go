@@ -0,0 +1,326 @@
+package shake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"shake/bytecode"
+	"shake/lexer"
+	"shake/parser"
+	"shake/types"
+	"shake/vm"
+	"sort"
+	"strings"
+)
+
+// Diagnostic is a problem found while compiling a program
+type Diagnostic struct {
+	Message string
+	// 0 when the problem is not at a line
+	Line uint64
+	// 0 when only the line is known, the lexer counts characters from 1
+	Column  uint64
+	Warning bool
+}
+
+func (d Diagnostic) String() string {
+	severity := "error"
+	if d.Warning {
+		severity = "warning"
+	}
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", severity, d.Message)
+	}
+	if d.Column == 0 {
+		return fmt.Sprintf("%s: %s at line: %d", severity, d.Message, d.Line)
+	}
+	return fmt.Sprintf("%s: %s at line: %d column: %d", severity, d.Message, d.Line, d.Column)
+}
+
+// Function is a function of the host that programs can call. Values are passed
+// as int32, int64, bool, []any for arrays and slices, map[int64]any for maps
+// and nil for empty.
+type Function struct {
+	Params []types.Type
+	Result types.Type
+	Call   func(arguments []any) (any, error)
+}
+
+// Limits bound what every call into a program can use, a zero field means no limit
+type Limits = vm.Limits
+
+// LimitError is returned by a call that exceeded one of its limits or whose
+// context was done, with the stack of the program at that point
+type LimitError = vm.LimitError
+
+type Options struct {
+	// Functions the programs can call, by name
+	Functions map[string]Function
+	Limits    Limits
+}
+
+// Program is a compiled program. It can be called from many goroutines at
+// once, every call runs on its own VM.
+type Program struct {
+	compiled  *bytecode.Program
+	functions map[string]Function
+	limits    Limits
+	// the type of every top level function, by name
+	signatures map[string]types.Type
+}
+
+// Compile compiles the source of a program. The program is nil when one of
+// the diagnostics is an error.
+func Compile(source string, options Options) (*Program, []Diagnostic) {
+	diagnostics := []Diagnostic{}
+	l := lexer.NewLexer(strings.NewReader(source))
+	p := parser.NewParser(l.All())
+	names := make([]string, 0, len(options.Functions))
+	for name := range options.Functions {
+		names = append(names, name)
+	}
+	// declare in a fixed order so the compiled program is always the same
+	sort.Strings(names)
+	for _, name := range names {
+		function := options.Functions[name]
+		err := p.DeclareHost(name, function.Params, function.Result)
+		if err != nil {
+			return nil, append(diagnostics, diagnostic(err))
+		}
+	}
+
+	program, err := p.ParseProgram()
+	for _, warning := range p.Warnings() {
+		diagnostics = append(diagnostics, diagnostic(warning))
+	}
+	// the parser sees the tokens end when the lexer fails
+	if l.Err() != nil {
+		err = l.Err()
+	}
+	if err != nil {
+		return nil, append(diagnostics, diagnostic(err))
+	}
+
+	compiled, err := bytecode.Compile(program)
+	if err != nil {
+		return nil, append(diagnostics, diagnostic(err))
+	}
+
+	signatures := map[string]types.Type{}
+	for _, host := range program.Hosts() {
+		signatures[host.Identifier] = host.Type
+	}
+	for _, statement := range program.Statements() {
+		if function, ok := statement.(*parser.NodeFunction); ok && function.Receiver() == nil {
+			signatures[function.Name()] = program.Identifier(function.Name()).Type
+		}
+	}
+	return &Program{
+		compiled:   compiled,
+		functions:  options.Functions,
+		limits:     options.Limits,
+		signatures: signatures,
+	}, diagnostics
+}
+
+// ansi matches the escape sequences that color the messages of errors
+var ansi = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// diagnostic keeps the reason and position of the errors of the lexer, the
+// parser and the compiler, other errors keep their message without color
+func diagnostic(err error) Diagnostic {
+	var lexed *lexer.Diagnostic
+	var parsed *parser.Diagnostic
+	var compiled *bytecode.Diagnostic
+	switch {
+	case errors.As(err, &lexed):
+		return Diagnostic{Message: lexed.Reason, Line: lexed.LineNumber, Column: lexed.Column}
+	case errors.As(err, &parsed):
+		return Diagnostic{Message: parsed.Reason, Line: parsed.LineNumber, Warning: parsed.Warning}
+	case errors.As(err, &compiled):
+		return Diagnostic{Message: compiled.Reason, Line: compiled.LineNumber}
+	}
+	return Diagnostic{Message: ansi.ReplaceAllString(err.Error(), "")}
+}
+
+// newVM returns a VM for a single call with the host functions registered
+func (p *Program) newVM() *vm.VM {
+	machine := vm.New(p.compiled)
+	machine.SetLimits(p.limits)
+	for name, function := range p.functions {
+		signature := p.signatures[name]
+		machine.Register(name, func(arguments []vm.Value) (vm.Value, error) {
+			values := make([]any, len(arguments))
+			for i, argument := range arguments {
+				value, err := fromValue(signature.Params()[i], argument)
+				if err != nil {
+					return vm.Value{}, err
+				}
+				values[i] = value
+			}
+			result, err := function.Call(values)
+			if err != nil {
+				return vm.Value{}, err
+			}
+			value, err := toValue(signature.Result(), result)
+			if err != nil {
+				return vm.Value{}, fmt.Errorf("result of host function: %s: %w", name, err)
+			}
+			return value, nil
+		})
+	}
+	return machine
+}
+
+// Call calls a top level function of the program by name
+func (p *Program) Call(name string, arguments ...any) (any, error) {
+	return p.CallContext(context.Background(), name, arguments...)
+}
+
+// CallContext is Call that stops with a *LimitError once ctx is done
+func (p *Program) CallContext(ctx context.Context, name string, arguments ...any) (any, error) {
+	index, ok := p.compiled.FunctionIndex(name)
+	signature, declared := p.signatures[name]
+	if !ok || !declared {
+		return nil, fmt.Errorf("function: %s is not declared", name)
+	}
+	params := signature.Params()
+	if len(arguments) != len(params) {
+		return nil, fmt.Errorf("function: %s expects %d arguments but found %d", name, len(params), len(arguments))
+	}
+
+	values := make([]vm.Value, len(arguments))
+	for i, argument := range arguments {
+		value, err := toValue(params[i], argument)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of function: %s: %w", i+1, name, err)
+		}
+		values[i] = value
+	}
+	result, err := p.newVM().CallContext(ctx, index, values...)
+	if err != nil {
+		return nil, err
+	}
+	return fromValue(signature.Result(), result)
+}
+
+// Run calls the entry function, the `(entry)` function or main, and returns its result
+func (p *Program) Run() (int64, error) {
+	return p.RunContext(context.Background())
+}
+
+// RunContext is Run that stops with a *LimitError once ctx is done
+func (p *Program) RunContext(ctx context.Context) (int64, error) {
+	return p.newVM().RunContext(ctx)
+}
+
+// toValue converts a Go value to a VM value of type t
+func toValue(t types.Type, value any) (vm.Value, error) {
+	switch t {
+	case types.TypeEmpty:
+		return vm.Value{}, nil
+	case types.TypeBool:
+		b, ok := value.(bool)
+		if !ok {
+			return vm.Value{}, fmt.Errorf("expected bool but found: %T", value)
+		}
+		return vm.Bool(b), nil
+	case types.TypeInt32, types.TypeInt64:
+		var i int64
+		switch value := value.(type) {
+		case int:
+			i = int64(value)
+		case int32:
+			i = int64(value)
+		case int64:
+			i = value
+		default:
+			return vm.Value{}, fmt.Errorf("expected %s but found: %T", t, value)
+		}
+		if t == types.TypeInt32 && (i < math.MinInt32 || i > math.MaxInt32) {
+			return vm.Value{}, fmt.Errorf("%d does not fit in int32", i)
+		}
+		return vm.Int(i), nil
+	}
+
+	switch t.Kind() {
+	case types.KindArray, types.KindSlice:
+		elements, ok := value.([]any)
+		if !ok {
+			return vm.Value{}, fmt.Errorf("expected []any for %s but found: %T", t, value)
+		}
+		if t.Kind() == types.KindArray && len(elements) != t.Len() {
+			return vm.Value{}, fmt.Errorf("expected %d elements for %s but found %d", t.Len(), t, len(elements))
+		}
+		converted := make([]vm.Value, len(elements))
+		for i, element := range elements {
+			var err error
+			converted[i], err = toValue(t.Elem(), element)
+			if err != nil {
+				return vm.Value{}, err
+			}
+		}
+		return vm.Value{Ref: converted}, nil
+	case types.KindMap:
+		entries, ok := value.(map[int64]any)
+		if !ok {
+			return vm.Value{}, fmt.Errorf("expected map[int64]any for %s but found: %T", t, value)
+		}
+		converted := make(map[int64]vm.Value, len(entries))
+		for key, entry := range entries {
+			element, err := toValue(t.Elem(), entry)
+			if err != nil {
+				return vm.Value{}, err
+			}
+			converted[key] = element
+		}
+		return vm.Value{Ref: converted}, nil
+	}
+	return vm.Value{}, fmt.Errorf("values of type: %s cannot be passed between Go and shake", t)
+}
+
+// fromValue converts a VM value of type t to a Go value
+func fromValue(t types.Type, value vm.Value) (any, error) {
+	switch t {
+	case types.TypeEmpty:
+		return nil, nil
+	case types.TypeBool:
+		return value.Bool(), nil
+	case types.TypeInt32:
+		return int32(value.Int), nil
+	case types.TypeInt64:
+		return value.Int, nil
+	}
+
+	switch t.Kind() {
+	case types.KindArray, types.KindSlice:
+		elements := value.Ref.([]vm.Value)
+		converted := make([]any, len(elements))
+		for i, element := range elements {
+			var err error
+			converted[i], err = fromValue(t.Elem(), element)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return converted, nil
+	case types.KindMap:
+		entries := value.Ref.(map[int64]vm.Value)
+		converted := make(map[int64]any, len(entries))
+		for key, entry := range entries {
+			element, err := fromValue(t.Elem(), entry)
+			if err != nil {
+				return nil, err
+			}
+			converted[key] = element
+		}
+		return converted, nil
+	case types.KindInterface:
+		// the value keeps its own type inside the interface
+		inner := value.Ref.(*vm.Interface)
+		return fromValue(inner.Type, inner.Value)
+	}
+	return nil, fmt.Errorf("values of type: %s cannot be passed between Go and shake", t)
+}
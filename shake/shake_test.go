@@ -0,0 +1,316 @@
+package shake_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"shake/shake"
+	"shake/types"
+	"shake/vm"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// TestDiagnostics checks the errors of every stage keep their position and
+// lose the color the command line prints them with
+func TestDiagnostics(t *testing.T) {
+	colored := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = colored }()
+
+	tests := []struct {
+		name   string
+		source string
+		want   shake.Diagnostic
+	}{
+		{"lexer", "fn main(): int32 {\n    x /* never closed\n}\n", shake.Diagnostic{Message: "unterminated block comment", Line: 2, Column: 7}},
+		{"parser", "fn main(): int32 {\n    return true;\n}\n", shake.Diagnostic{Message: "Type of scope: int32 is different from return type: bool", Line: 2}},
+		{"compiler", "fn main(): int32 {\n    xs = []int32{" + strings.Repeat("0, ", 1<<16) + "};\n    return len(xs);\n}\n", shake.Diagnostic{Message: "Too many elements in collection literal", Line: 2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program, diagnostics := shake.Compile(test.source, shake.Options{})
+			if program != nil || len(diagnostics) != 1 {
+				t.Fatalf("got %v, want one error", diagnostics)
+			}
+			if diagnostics[0] != test.want {
+				t.Errorf("got %+v, want %+v", diagnostics[0], test.want)
+			}
+			if strings.Contains(diagnostics[0].String(), "\x1b[") {
+				t.Errorf("the diagnostic is colored: %q", diagnostics[0].String())
+			}
+		})
+	}
+}
+
+// compile compiles a program that must have no diagnostics
+func compile(t *testing.T, source string, options shake.Options) *shake.Program {
+	t.Helper()
+	program, diagnostics := shake.Compile(source, options)
+	if program == nil || len(diagnostics) > 0 {
+		t.Fatalf("got %v, want no diagnostics", diagnostics)
+	}
+	return program
+}
+
+const calls = `fn add(x: int32, y: int64): int64 {
+    return y + y;
+}
+
+fn sum(xs: []int32): int32 {
+    total = 0;
+    for i, x in xs {
+        total = total + x;
+    }
+    return total;
+}
+
+fn lookup(m: map[int64]bool, k: int64): bool {
+    return m[k];
+}
+
+fn squares(n: int32): []int32 {
+    xs = []int32{};
+    for i, x in []int32{1, 2, 3} {
+        if i < n {
+            xs = append(xs, x * x);
+        }
+    }
+    return xs;
+}
+
+fn main(): int32 {
+    return sum([]int32{1, 2, 3});
+}
+`
+
+func TestCall(t *testing.T) {
+	program := compile(t, calls, shake.Options{})
+	tests := []struct {
+		name      string
+		function  string
+		arguments []any
+		want      any
+	}{
+		{"int", "add", []any{1, 2}, int64(4)},
+		{"int32 and int64", "add", []any{int32(1), int64(1) << 40}, int64(1) << 41},
+		{"slice", "sum", []any{[]any{int32(4), 5, int64(6)}}, int32(15)},
+		{"map", "lookup", []any{map[int64]any{7: true}, 7}, true},
+		{"result slice", "squares", []any{2}, []any{int32(1), int32(4)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := program.Call(test.function, test.arguments...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+
+	result, err := program.Run()
+	if err != nil || result != 6 {
+		t.Errorf("Run returned %d, %v, want 6", result, err)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	program := compile(t, calls, shake.Options{})
+	tests := []struct {
+		name      string
+		function  string
+		arguments []any
+		want      string
+	}{
+		{"undeclared", "missing", nil, "function: missing is not declared"},
+		{"arity", "add", []any{1}, "function: add expects 2 arguments but found 1"},
+		{"type", "add", []any{"1", 2}, "argument 1 of function: add: expected int32 but found: string"},
+		{"range", "add", []any{int64(1) << 31, 2}, "2147483648 does not fit in int32"},
+		{"element", "sum", []any{[]any{1, true}}, "expected int32 but found: bool"},
+		{"slice", "sum", []any{[]int32{1}}, "expected []any for []int32 but found: []int32"},
+		{"map", "lookup", []any{map[int32]any{}, 1}, "expected map[int64]any for map[int64]bool"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := program.Call(test.function, test.arguments...)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// TestFunctions checks the values host functions are called with and return
+func TestFunctions(t *testing.T) {
+	var got []any
+	options := shake.Options{Functions: map[string]shake.Function{
+		"record": {
+			Params: []types.Type{types.TypeInt32, types.TypeInt64, types.Slice(types.TypeBool), types.Map(types.TypeInt64, types.TypeInt32)},
+			Result: types.TypeInt32,
+			Call: func(arguments []any) (any, error) {
+				got = arguments
+				// results may be any of the integer types
+				return 10, nil
+			},
+		},
+		"wide": {
+			Result: types.TypeInt32,
+			Call: func(arguments []any) (any, error) {
+				return int64(1) << 40, nil
+			},
+		},
+		"fail": {
+			Result: types.TypeEmpty,
+			Call: func(arguments []any) (any, error) {
+				return nil, errors.New("refused")
+			},
+		},
+	}}
+	program := compile(t, `fn record1(x: int32, y: int64): int32 {
+    return record(x, y, []bool{true, false}, map[int64]int32{});
+}
+
+fn wide1(): int32 {
+    return wide();
+}
+
+fn fail1(): int32 {
+    fail();
+    return 0;
+}
+`, options)
+
+	result, err := program.Call("record1", 1, int64(2))
+	if err != nil || result != int32(10) {
+		t.Fatalf("got %v, %v, want 10", result, err)
+	}
+	want := []any{int32(1), int64(2), []any{true, false}, map[int64]any{}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("record was called with %#v, want %#v", got, want)
+	}
+
+	_, err = program.Call("wide1")
+	if err == nil || !strings.Contains(err.Error(), "result of host function: wide: 1099511627776 does not fit in int32") {
+		t.Errorf("got %v, want an out of range result", err)
+	}
+	_, err = program.Call("fail1")
+	if err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("got %v, want the error of the host function", err)
+	}
+}
+
+// TestLimits checks the limits of the options bound every call, and that
+// the context of a call stops it
+func TestLimits(t *testing.T) {
+	const source = `fn spin(n: int32): int32 {
+    return spin(n + 1);
+}
+
+fn grow(n: int32): int32 {
+    xs = []int32{};
+    for i, x in []int32{1, 2, 3, 4, 5, 6, 7, 8} {
+        xs = append(xs, x);
+    }
+    return len(xs) + n;
+}
+`
+	tests := []struct {
+		name     string
+		limits   shake.Limits
+		function string
+		want     vm.Limit
+	}{
+		{"steps", shake.Limits{Steps: 1000}, "spin", vm.LimitSteps},
+		{"depth", shake.Limits{Depth: 100}, "spin", vm.LimitDepth},
+		{"memory", shake.Limits{Memory: 400}, "grow", vm.LimitMemory},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			program := compile(t, source, shake.Options{Limits: test.limits})
+			_, err := program.Call(test.function, 0)
+			var limit *shake.LimitError
+			if !errors.As(err, &limit) || limit.Limit != test.want {
+				t.Fatalf("got %v, want the %s limit", err, test.want)
+			}
+			if len(limit.Trace) == 0 || limit.Trace[0].Function != test.function {
+				t.Errorf("got trace %+v, want %s innermost", limit.Trace, test.function)
+			}
+		})
+	}
+
+	program := compile(t, source, shake.Options{Limits: shake.Limits{Memory: 1 << 20}})
+	result, err := program.Call("grow", 1)
+	if err != nil || result != int32(9) {
+		t.Errorf("got %v, %v, want 9 within the limits", result, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	program = compile(t, source, shake.Options{})
+	_, err = program.CallContext(ctx, "spin", 0)
+	var limit *shake.LimitError
+	if !errors.As(err, &limit) || limit.Limit != vm.LimitTime || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the time limit", err)
+	}
+}
+
+// TestConcurrent calls one program from many goroutines at once, run it
+// with -race
+func TestConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	options := shake.Options{
+		Functions: map[string]shake.Function{
+			"count": {
+				Params: []types.Type{types.TypeInt32},
+				Result: types.TypeInt32,
+				Call: func(arguments []any) (any, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					calls++
+					return arguments[0], nil
+				},
+			},
+		},
+		Limits: shake.Limits{Steps: 1 << 20},
+	}
+	program := compile(t, `fn double(n: int32): int32 {
+    xs = []int32{};
+    for i, x in []int32{1, 2} {
+        xs = append(xs, count(n));
+    }
+    return xs[0] + xs[1];
+}
+`, options)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for n := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := program.Call("double", n)
+			if err == nil && result != int32(2*n) {
+				err = fmt.Errorf("double(%d) returned %v", n, result)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if calls != 100 {
+		t.Errorf("count was called %d times, want 100", calls)
+	}
+}
@@ -0,0 +1,20 @@
+package trace
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+)
+
+var enabled atomic.Bool
+
+// Enable makes Stack print, the compiler uses it to show where an error was created
+func Enable(on bool) {
+	enabled.Store(on)
+}
+
+// Stack prints the stack of the caller when tracing is enabled
+func Stack() {
+	if enabled.Load() {
+		debug.PrintStack()
+	}
+}
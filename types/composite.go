@@ -0,0 +1,147 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type Kind int
+
+const (
+	KindBasic Kind = iota
+	KindArray
+	KindSlice
+	KindMap
+	KindFunction
+	// declared with `interface`
+	KindInterface
+)
+
+// definition describes every type that is not a builtin
+type definition struct {
+	name   string
+	kind   Kind
+	key    Type
+	elem   Type
+	length int
+	params []Type
+	// the registry of the program that declared the type, or declared a type
+	// it is made of, nil when it is made of builtin types only
+	registry *Registry
+}
+
+var (
+	// mutex guards composites, which every program compiled by the process shares
+	mutex sync.Mutex
+	// composite types of builtin types are interned by name, so the same type
+	// is always the same Type
+	composites = map[string]Type{}
+)
+
+// intern returns the composite type named name. Types made of a declared type
+// are interned in the registry of its program instead of for the process.
+func intern(name string, d definition, parts ...Type) Type {
+	for _, part := range parts {
+		if part.definition != nil && part.definition.registry != nil {
+			d.registry = part.definition.registry
+			break
+		}
+	}
+	table, lock := composites, &mutex
+	if d.registry != nil {
+		table, lock = d.registry.composites, &d.registry.mutex
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if existing, ok := table[name]; ok {
+		return existing
+	}
+	d.name = name
+	declared := Type{definition: &d}
+	table[name] = declared
+	return declared
+}
+
+// Array returns the fixed size array type `[length]elem`
+func Array(elem Type, length int) Type {
+	return intern(fmt.Sprintf("[%d]%s", length, elem), definition{kind: KindArray, elem: elem, length: length}, elem)
+}
+
+// Slice returns the growable slice type `[]elem`
+func Slice(elem Type) Type {
+	return intern(fmt.Sprintf("[]%s", elem), definition{kind: KindSlice, elem: elem}, elem)
+}
+
+// Map returns the hash map type `map[key]elem`
+func Map(key Type, elem Type) Type {
+	return intern(fmt.Sprintf("map[%s]%s", key, elem), definition{kind: KindMap, key: key, elem: elem}, key, elem)
+}
+
+// Function returns the type of a function taking params and returning result
+func Function(params []Type, result Type) Type {
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = param.String()
+	}
+	name := fmt.Sprintf("fn(%s): %s", strings.Join(names, ", "), result)
+	return intern(name, definition{kind: KindFunction, elem: result, params: params}, append([]Type{result}, params...)...)
+}
+
+func (t Type) Kind() Kind {
+	if t.definition == nil {
+		return KindBasic
+	}
+	return t.definition.kind
+}
+
+// Elem returns the element type of an array or slice, the value type of a map
+// or the result type of a function
+func (t Type) Elem() Type {
+	if t.definition == nil || t.definition.kind == KindInterface {
+		return TypeUnknown
+	}
+	return t.definition.elem
+}
+
+// Key returns the key type of a map
+func (t Type) Key() Type {
+	if t.Kind() != KindMap {
+		return TypeUnknown
+	}
+	return t.definition.key
+}
+
+// Len returns the length of an array type
+func (t Type) Len() int {
+	if t.definition == nil {
+		return 0
+	}
+	return t.definition.length
+}
+
+// Params returns the parameter types of a function
+func (t Type) Params() []Type {
+	if t.definition == nil {
+		return nil
+	}
+	return t.definition.params
+}
+
+// Result returns the result type of a function
+func (t Type) Result() Type {
+	if t.Kind() != KindFunction {
+		return TypeUnknown
+	}
+	return t.Elem()
+}
+
+func (t Type) IsCollection() bool {
+	kind := t.Kind()
+	return kind == KindArray || kind == KindSlice || kind == KindMap
+}
+
+func (t Type) IsInteger() bool {
+	return t == TypeInt32 || t == TypeInt64
+}
@@ -1,21 +1,38 @@
 package types
 
-import "shake/bimap"
+import (
+	"fmt"
+	"shake/bimap"
+	"sync"
+)
+
+// Type is a builtin type, a composite type such as `[]int32` or a type a
+// program declares. The same type is always the same Type, so types compare
+// with ==, and the zero Type is TypeEmpty.
+type Type struct {
+	builtin builtin
+	// set for every type that is not a builtin
+	definition *definition
+}
 
-type Type int
+type builtin int
 
-const (
-	TypeEmpty Type = iota
-	TypeInt32
-	TypeInt64
-	TypeUnknown
+var (
+	TypeEmpty   = Type{builtin: 0}
+	TypeInt32   = Type{builtin: 1}
+	TypeInt64   = Type{builtin: 2}
+	TypeUnknown = Type{builtin: 3}
+	TypeBool    = Type{builtin: 4}
+	TypeError   = Type{builtin: 5}
 )
 
-var typeNamesMap = map[Type]string{
-	TypeEmpty:   "empty",
-	TypeInt32:   "int32",
-	TypeInt64:   "int64",
-	TypeUnknown: "unknown",
+var typeNamesMap = map[builtin]string{
+	TypeEmpty.builtin:   "empty",
+	TypeInt32.builtin:   "int32",
+	TypeInt64.builtin:   "int64",
+	TypeUnknown.builtin: "unknown",
+	TypeBool.builtin:    "bool",
+	TypeError.builtin:   "error",
 }
 
 // Create the bidirectional map from the map
@@ -25,15 +42,19 @@ func (t Type) String() string {
 	if err != nil {
 		panic("Failed to initialize typeNames: " + err.Error())
 	}
+	if t.definition != nil {
+		return t.definition.name
+	}
 
 	// Check if the type exists in the bidirectional map
-	value, ok := typeNames.GetByKey(t)
+	value, ok := typeNames.GetByKey(t.builtin)
 	if !ok {
 		return "Unknown"
 	}
 	return value
 }
 
+// GetType returns the builtin type with the given name, or TypeUnknown
 func GetType(typeName string) Type {
 	if err != nil {
 		panic("Failed to initialize typeNames: " + err.Error())
@@ -44,5 +65,48 @@ func GetType(typeName string) Type {
 	if !ok {
 		return TypeUnknown
 	}
-	return value
+	return Type{builtin: value}
+}
+
+// Registry holds the types one program declares, such as its interfaces.
+// Programs declaring the same name get different types, and the types of a
+// program are dropped with it.
+type Registry struct {
+	mutex sync.Mutex
+	named map[string]Type
+	// the composite types made of the declared ones, by name
+	composites map[string]Type
+}
+
+func NewRegistry() *Registry {
+	return &Registry{named: map[string]Type{}, composites: map[string]Type{}}
+}
+
+// Declare declares a new type of the given kind, e.g. KindInterface
+func (r *Registry) Declare(name string, kind Kind) (Type, error) {
+	if GetType(name) != TypeUnknown {
+		return TypeUnknown, fmt.Errorf("type %s: is a builtin type", name)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.named[name]; ok {
+		return TypeUnknown, fmt.Errorf("type %s: is already declared", name)
+	}
+	declared := Type{definition: &definition{name: name, kind: kind, registry: r}}
+	r.named[name] = declared
+	return declared, nil
+}
+
+// Lookup returns the builtin or declared type with the given name, or TypeUnknown
+func (r *Registry) Lookup(name string) Type {
+	if builtin := GetType(name); builtin != TypeUnknown {
+		return builtin
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	declared, ok := r.named[name]
+	if !ok {
+		return TypeUnknown
+	}
+	return declared
 }
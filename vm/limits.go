@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"fmt"
+	"shake/bytecode"
+	"strings"
+	"unsafe"
+
+	"github.com/fatih/color"
+)
+
+// Limits bound what a single call into the VM can use, a zero field means no limit
+type Limits struct {
+	// Steps is the most instructions a call can execute
+	Steps int64
+	// Depth is the most functions that can be running at once
+	Depth int
+	// Memory is the most bytes a call can allocate for collections and closures,
+	// and use for the frames of the functions that are running
+	Memory int64
+}
+
+type Limit int
+
+const (
+	LimitSteps Limit = iota
+	LimitDepth
+	LimitMemory
+	// LimitTime is exceeded when the context of the call is done
+	LimitTime
+)
+
+var limitNames = map[Limit]string{
+	LimitSteps:  "steps",
+	LimitDepth:  "depth",
+	LimitMemory: "memory",
+	LimitTime:   "time",
+}
+
+func (l Limit) String() string {
+	return limitNames[l]
+}
+
+// StackFrame is a function that was running when a limit was exceeded
+type StackFrame struct {
+	Function   string
+	LineNumber uint64
+}
+
+// LimitError stops a call that exceeded one of its limits
+type LimitError struct {
+	Limit  Limit
+	Reason string
+	// the functions that were running, innermost first
+	Trace []StackFrame
+	// how many outer frames did not fit in Trace
+	Omitted int
+	// the error of the context when the time limit was exceeded
+	Err error
+}
+
+func (e *LimitError) Error() string {
+	c := color.New(color.FgRed).Add(color.Underline)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", c.Sprint("[Limit Error]"), e.Reason)
+	for _, frame := range e.Trace {
+		fmt.Fprintf(&b, "\n\tin function: %s at line: %d", frame.Function, frame.LineNumber)
+	}
+	if e.Omitted > 0 {
+		fmt.Fprintf(&b, "\n\t... %d more", e.Omitted)
+	}
+	return b.String()
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// maxTrace is the most frames a LimitError keeps, deep recursion would make the trace huge
+const maxTrace = 64
+
+// valueSize is what a value in a collection takes, map entries also store their key
+var valueSize = int64(unsafe.Sizeof(Value{}))
+
+// frameSize is what a running function uses, its frame and its slots on the
+// stack: the callee and the locals
+func frameSize(function *bytecode.Function) int64 {
+	return int64(unsafe.Sizeof(frame{})) + int64(function.Locals+1)*valueSize
+}
+
+// SetLimits sets the limits of the following calls
+func (vm *VM) SetLimits(limits Limits) {
+	vm.limits = limits
+}
+
+// exceeded stops the call, offset is the instruction of the innermost frame that exceeded the limit
+func (vm *VM) exceeded(limit Limit, reason string, offset int) *LimitError {
+	trace := make([]StackFrame, 0, min(len(vm.frames), maxTrace))
+	for i := len(vm.frames) - 1; i >= 0 && len(trace) < maxTrace; i-- {
+		frame := vm.frames[i]
+		// outer frames are in the middle of a call, ip is right after it
+		at := frame.ip - 1
+		if i == len(vm.frames)-1 {
+			at = offset
+		}
+		trace = append(trace, StackFrame{
+			Function:   frame.closure.Function.Name,
+			LineNumber: frame.closure.Function.Lines[at],
+		})
+	}
+	return &LimitError{Limit: limit, Reason: reason, Trace: trace, Omitted: len(vm.frames) - len(trace)}
+}
+
+// allocate counts bytes against the memory limit
+func (vm *VM) allocate(offset int, bytes int64) error {
+	vm.allocated += bytes
+	if vm.limits.Memory > 0 && vm.allocated > vm.limits.Memory {
+		return vm.exceeded(LimitMemory, fmt.Sprintf("allocated more than the limit of %d bytes", vm.limits.Memory), offset)
+	}
+	return nil
+}
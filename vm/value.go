@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"fmt"
+	"shake/bytecode"
+	"shake/types"
+	"sort"
+	"strings"
+)
+
+// Value is a single value on the stack. Integers, bools and empty live in
+// Int, everything else is a reference.
+type Value struct {
+	Int int64
+	// []Value for arrays and slices, map[int64]Value for maps, *Closure for
+	// functions and *Interface for values of interface types
+	Ref any
+}
+
+// Interface is a value of an interface type, its methods are those of the type of Value
+type Interface struct {
+	Type  types.Type
+	Value Value
+}
+
+// Box holds a local that a closure captured, so every function sharing it sees updates
+type Box struct {
+	Value Value
+}
+
+type Closure struct {
+	Function *bytecode.Function
+	Captured []*Box
+	// the program the function was compiled in
+	unit *unit
+}
+
+func Int(i int64) Value {
+	return Value{Int: i}
+}
+
+func Bool(b bool) Value {
+	if b {
+		return Value{Int: 1}
+	}
+	return Value{}
+}
+
+func (v Value) Bool() bool {
+	return v.Int != 0
+}
+
+func (v Value) String() string {
+	switch ref := v.Ref.(type) {
+	case []Value:
+		elements := make([]string, len(ref))
+		for i, element := range ref {
+			elements[i] = element.String()
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case map[int64]Value:
+		keys := sortedKeys(ref)
+		elements := make([]string, len(keys))
+		for i, key := range keys {
+			elements[i] = fmt.Sprintf("%d: %s", key, ref[key])
+		}
+		return "map[" + strings.Join(elements, ", ") + "]"
+	case *Closure:
+		return "fn " + ref.Function.Name
+	case *Interface:
+		return ref.Value.String()
+	}
+	return fmt.Sprint(v.Int)
+}
+
+func sortedKeys(m map[int64]Value) []int64 {
+	keys := make([]int64, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
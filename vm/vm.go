@@ -0,0 +1,535 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"shake/bytecode"
+	"shake/types"
+	"unsafe"
+
+	"github.com/fatih/color"
+)
+
+type frame struct {
+	closure *Closure
+	ip      int
+	// index of the first local slot on the stack
+	base int
+}
+
+// unit is a compiled program ready to run. Closures keep the unit of their
+// function, so they can be called by a VM of another program that got them
+// through its globals.
+type unit struct {
+	program   *bytecode.Program
+	constants []Value
+	// a closure for every function, used for top level functions
+	functions []*Closure
+}
+
+type VM struct {
+	*unit
+	stack  []Value
+	frames []frame
+	// the variables kept between calls, see SetGlobals
+	globals []Value
+	// implementations of the functions the host provides, by name
+	hosts map[string]HostFunction
+
+	limits Limits
+	// what the current call used so far
+	steps     int64
+	allocated int64
+	// the context of the current call
+	context context.Context
+}
+
+// HostFunction implements a function the host provides to the program
+type HostFunction func(arguments []Value) (Value, error)
+
+// RuntimeError stops the program at the line of the instruction that failed
+type RuntimeError struct {
+	Reason     string
+	Function   string
+	LineNumber uint64
+	// the error of the host function that failed, if one did
+	Err error
+}
+
+func (e *RuntimeError) Error() string {
+	c := color.New(color.FgRed).Add(color.Underline)
+	return fmt.Sprintf("%s: %s in function: %s at line: %d", c.Sprint("[Runtime Error]"), e.Reason, e.Function, e.LineNumber)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func New(program *bytecode.Program) *VM {
+	u := &unit{
+		program:   program,
+		constants: make([]Value, len(program.Constants)),
+		functions: make([]*Closure, len(program.Functions)),
+	}
+	for i, constant := range program.Constants {
+		switch constant := constant.(type) {
+		case int64:
+			u.constants[i] = Int(constant)
+		case bool:
+			u.constants[i] = Bool(constant)
+		}
+	}
+	for i, function := range program.Functions {
+		u.functions[i] = &Closure{Function: function, unit: u}
+	}
+	return &VM{
+		unit:    u,
+		stack:   make([]Value, 0, 1024),
+		globals: make([]Value, len(program.Globals)),
+		hosts:   make(map[string]HostFunction),
+	}
+}
+
+// SetGlobals makes the VM read and write its globals in globals, which needs
+// a value for every global of the program. Values of globals can come from
+// the globals of a VM of another program, as the REPL keeps them between inputs.
+func (vm *VM) SetGlobals(globals []Value) error {
+	if len(globals) < len(vm.program.Globals) {
+		return fmt.Errorf("expected %d globals but found %d", len(vm.program.Globals), len(globals))
+	}
+	vm.globals = globals
+	return nil
+}
+
+// Register provides the implementation of a host function the program declared
+func (vm *VM) Register(name string, function HostFunction) {
+	vm.hosts[name] = function
+}
+
+// callHost calls the host function, arguments are copied so the host can keep them
+func (vm *VM) callHost(function *bytecode.Function, arguments []Value) (Value, error) {
+	host, ok := vm.hosts[function.Name]
+	if !ok {
+		return Value{}, fmt.Errorf("host function: %s is not registered", function.Name)
+	}
+	return host(append([]Value{}, arguments...))
+}
+
+// Run calls the entry function and returns its result
+func (vm *VM) Run() (int64, error) {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is Run that stops with a *LimitError once ctx is done
+func (vm *VM) RunContext(ctx context.Context) (int64, error) {
+	if err := cancelled(ctx); err != nil {
+		return 0, err
+	}
+	if vm.program.Entry < 0 {
+		return 0, &RuntimeError{Reason: "program has no entry function, mark one with (entry) or call it main"}
+	}
+	result, err := vm.CallContext(ctx, vm.program.Entry)
+	if err != nil {
+		return 0, err
+	}
+	return result.Int, nil
+}
+
+// Call calls the function at index in the program with the given arguments
+func (vm *VM) Call(index int, arguments ...Value) (Value, error) {
+	return vm.CallContext(context.Background(), index, arguments...)
+}
+
+// CallContext is Call that stops with a *LimitError once ctx is done
+func (vm *VM) CallContext(ctx context.Context, index int, arguments ...Value) (Value, error) {
+	if err := cancelled(ctx); err != nil {
+		return Value{}, err
+	}
+	closure := vm.functions[index]
+	if len(arguments) != closure.Function.Arity {
+		return Value{}, &RuntimeError{
+			Reason:   fmt.Sprintf("expected %d arguments but found %d", closure.Function.Arity, len(arguments)),
+			Function: closure.Function.Name,
+		}
+	}
+
+	if closure.Function.Host {
+		result, err := vm.callHost(closure.Function, arguments)
+		if err != nil {
+			return Value{}, &RuntimeError{Reason: err.Error(), Function: closure.Function.Name, Err: err}
+		}
+		return result, nil
+	}
+
+	vm.steps = 0
+	vm.allocated = 0
+	vm.context = ctx
+	err := vm.allocate(0, frameSize(closure.Function))
+	if err != nil {
+		return Value{}, err
+	}
+	vm.stack = append(vm.stack, Value{Ref: closure})
+	vm.stack = append(vm.stack, arguments...)
+	vm.call(closure, len(arguments))
+	err = vm.run(len(vm.frames))
+	if err != nil {
+		// leave the VM ready for the next call
+		vm.stack = vm.stack[:0]
+		vm.frames = vm.frames[:0]
+		return Value{}, err
+	}
+	return vm.pop(), nil
+}
+
+// cancelled stops a call before it starts when ctx is already done, the loop
+// only checks it every few steps and a host function would not check it at all
+func cancelled(ctx context.Context) *LimitError {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return &LimitError{Limit: LimitTime, Reason: ctx.Err().Error(), Err: ctx.Err()}
+}
+
+func (vm *VM) push(value Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// call pushes a frame for closure, whose arguments are on top of the stack
+func (vm *VM) call(closure *Closure, argumentCount int) {
+	base := len(vm.stack) - argumentCount
+	for len(vm.stack) < base+closure.Function.Locals {
+		vm.stack = append(vm.stack, Value{})
+	}
+	vm.frames = append(vm.frames, frame{closure: closure, base: base})
+}
+
+func (vm *VM) fail(current *frame, offset int, reason string) *RuntimeError {
+	return &RuntimeError{
+		Reason:     reason,
+		Function:   current.closure.Function.Name,
+		LineNumber: current.closure.Function.Lines[offset],
+	}
+}
+
+// run executes until the frame at depth returns
+func (vm *VM) run(depth int) error {
+	current := &vm.frames[len(vm.frames)-1]
+	code := current.closure.Function.Code
+	// the unit of the running function, which may not be the unit of the VM
+	u := current.closure.unit
+	done := vm.context.Done()
+
+	for {
+		offset := current.ip
+		op := bytecode.Opcode(code[offset])
+		current.ip++
+
+		vm.steps++
+		if vm.limits.Steps > 0 && vm.steps > vm.limits.Steps {
+			return vm.exceeded(LimitSteps, fmt.Sprintf("executed more than the limit of %d steps", vm.limits.Steps), offset)
+		}
+		// checking the context is slow, so it is only done every few steps
+		if done != nil && vm.steps%1024 == 0 {
+			select {
+			case <-done:
+				failure := vm.exceeded(LimitTime, vm.context.Err().Error(), offset)
+				failure.Err = vm.context.Err()
+				return failure
+			default:
+			}
+		}
+
+		switch op {
+		case bytecode.OpConstant:
+			vm.push(u.constants[bytecode.ReadUint16(code, current.ip)])
+			current.ip += 2
+		case bytecode.OpPop:
+			vm.pop()
+		case bytecode.OpLoadLocal:
+			vm.push(vm.stack[current.base+bytecode.ReadUint16(code, current.ip)])
+			current.ip += 2
+		case bytecode.OpStoreLocal:
+			vm.stack[current.base+bytecode.ReadUint16(code, current.ip)] = vm.pop()
+			current.ip += 2
+		case bytecode.OpBoxLocal:
+			slot := current.base + bytecode.ReadUint16(code, current.ip)
+			vm.stack[slot] = Value{Ref: &Box{Value: vm.stack[slot]}}
+			current.ip += 2
+		case bytecode.OpLoadBoxed:
+			box := vm.stack[current.base+bytecode.ReadUint16(code, current.ip)].Ref.(*Box)
+			vm.push(box.Value)
+			current.ip += 2
+		case bytecode.OpStoreBoxed:
+			box := vm.stack[current.base+bytecode.ReadUint16(code, current.ip)].Ref.(*Box)
+			box.Value = vm.pop()
+			current.ip += 2
+		case bytecode.OpLoadCaptured:
+			vm.push(current.closure.Captured[bytecode.ReadUint16(code, current.ip)].Value)
+			current.ip += 2
+		case bytecode.OpStoreCaptured:
+			current.closure.Captured[bytecode.ReadUint16(code, current.ip)].Value = vm.pop()
+			current.ip += 2
+		case bytecode.OpLoadGlobal:
+			vm.push(vm.globals[bytecode.ReadUint16(code, current.ip)])
+			current.ip += 2
+		case bytecode.OpStoreGlobal:
+			vm.globals[bytecode.ReadUint16(code, current.ip)] = vm.pop()
+			current.ip += 2
+		case bytecode.OpLoadFunction:
+			vm.push(Value{Ref: u.functions[bytecode.ReadUint16(code, current.ip)]})
+			current.ip += 2
+		case bytecode.OpClosure:
+			function := u.program.Functions[bytecode.ReadUint16(code, current.ip)]
+			current.ip += 2
+			err := vm.allocate(offset, int64(len(function.Captures))*int64(unsafe.Sizeof(&Box{})))
+			if err != nil {
+				return err
+			}
+			closure := &Closure{Function: function, Captured: make([]*Box, len(function.Captures)), unit: u}
+			for i, capture := range function.Captures {
+				if capture.Local {
+					closure.Captured[i] = vm.stack[current.base+capture.Index].Ref.(*Box)
+				} else {
+					closure.Captured[i] = current.closure.Captured[capture.Index]
+				}
+			}
+			vm.push(Value{Ref: closure})
+
+		case bytecode.OpAdd, bytecode.OpSubtract, bytecode.OpMultiply, bytecode.OpDivide:
+			width := code[current.ip]
+			current.ip++
+			right := vm.pop().Int
+			left := vm.pop().Int
+			var result int64
+			switch op {
+			case bytecode.OpAdd:
+				result = left + right
+			case bytecode.OpSubtract:
+				result = left - right
+			case bytecode.OpMultiply:
+				result = left * right
+			case bytecode.OpDivide:
+				if right == 0 {
+					return vm.fail(current, offset, "division by zero")
+				}
+				result = left / right
+			}
+			if width == 32 {
+				result = int64(int32(result))
+			}
+			vm.push(Int(result))
+		case bytecode.OpEqual, bytecode.OpNotEqual, bytecode.OpLess, bytecode.OpLessEqual, bytecode.OpGreater, bytecode.OpGreaterEqual:
+			right := vm.pop().Int
+			left := vm.pop().Int
+			var result bool
+			switch op {
+			case bytecode.OpEqual:
+				result = left == right
+			case bytecode.OpNotEqual:
+				result = left != right
+			case bytecode.OpLess:
+				result = left < right
+			case bytecode.OpLessEqual:
+				result = left <= right
+			case bytecode.OpGreater:
+				result = left > right
+			case bytecode.OpGreaterEqual:
+				result = left >= right
+			}
+			vm.push(Bool(result))
+
+		case bytecode.OpJump:
+			current.ip = bytecode.ReadUint16(code, current.ip)
+		case bytecode.OpJumpIfFalse:
+			if vm.pop().Bool() {
+				current.ip += 2
+			} else {
+				current.ip = bytecode.ReadUint16(code, current.ip)
+			}
+		case bytecode.OpCall:
+			argumentCount := int(code[current.ip])
+			current.ip++
+			callee, ok := vm.stack[len(vm.stack)-argumentCount-1].Ref.(*Closure)
+			if !ok {
+				return vm.fail(current, offset, "called value is not a function")
+			}
+			if vm.limits.Depth > 0 && len(vm.frames) >= vm.limits.Depth {
+				return vm.exceeded(LimitDepth, fmt.Sprintf("called more than the limit of %d functions deep", vm.limits.Depth), offset)
+			}
+			if callee.Function.Host {
+				arguments := vm.stack[len(vm.stack)-argumentCount:]
+				result, err := vm.callHost(callee.Function, arguments)
+				if err != nil {
+					failure := vm.fail(current, offset, err.Error())
+					failure.Err = err
+					return failure
+				}
+				// drop the arguments and the callee
+				vm.stack = vm.stack[:len(vm.stack)-argumentCount-1]
+				vm.push(result)
+				break
+			}
+			err := vm.allocate(offset, frameSize(callee.Function))
+			if err != nil {
+				return err
+			}
+			vm.call(callee, argumentCount)
+			current = &vm.frames[len(vm.frames)-1]
+			code = current.closure.Function.Code
+			u = current.closure.unit
+		case bytecode.OpInterface:
+			valueType, _ := u.program.Constants[bytecode.ReadUint16(code, current.ip)].(types.Type)
+			current.ip += 2
+			err := vm.allocate(offset, int64(unsafe.Sizeof(Interface{})))
+			if err != nil {
+				return err
+			}
+			vm.push(Value{Ref: &Interface{Type: valueType, Value: vm.pop()}})
+		case bytecode.OpLoadMethod:
+			name, _ := u.program.Constants[bytecode.ReadUint16(code, current.ip)].(string)
+			current.ip += 2
+			value, ok := vm.pop().Ref.(*Interface)
+			if !ok {
+				return vm.fail(current, offset, "method of a value that is not an interface")
+			}
+			index, ok := u.program.Methods[value.Type][name]
+			if !ok {
+				return vm.fail(current, offset, fmt.Sprintf("type %s has no method %s", value.Type, name))
+			}
+			vm.push(Value{Ref: u.functions[index]})
+			vm.push(value.Value)
+		case bytecode.OpReturn:
+			result := vm.pop()
+			// the memory of the frame is free again
+			vm.allocated -= frameSize(current.closure.Function)
+			// drop the locals and the callee
+			vm.stack = vm.stack[:current.base-1]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.push(result)
+			if len(vm.frames) < depth {
+				return nil
+			}
+			current = &vm.frames[len(vm.frames)-1]
+			code = current.closure.Function.Code
+			u = current.closure.unit
+
+		case bytecode.OpArray:
+			count := bytecode.ReadUint16(code, current.ip)
+			length := bytecode.ReadUint16(code, current.ip+2)
+			current.ip += 4
+			err := vm.allocate(offset, int64(length)*valueSize)
+			if err != nil {
+				return err
+			}
+			array := make([]Value, length)
+			copy(array, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(Value{Ref: array})
+		case bytecode.OpSlice:
+			count := bytecode.ReadUint16(code, current.ip)
+			current.ip += 2
+			err := vm.allocate(offset, int64(count)*valueSize)
+			if err != nil {
+				return err
+			}
+			slice := make([]Value, count)
+			copy(slice, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(Value{Ref: slice})
+		case bytecode.OpMap:
+			count := bytecode.ReadUint16(code, current.ip)
+			current.ip += 2
+			err := vm.allocate(offset, int64(count)*(8+valueSize))
+			if err != nil {
+				return err
+			}
+			m := make(map[int64]Value, count)
+			pairs := vm.stack[len(vm.stack)-2*count:]
+			for i := 0; i < len(pairs); i += 2 {
+				m[pairs[i].Int] = pairs[i+1]
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(Value{Ref: m})
+		case bytecode.OpIndex:
+			index := vm.pop().Int
+			switch collection := vm.pop().Ref.(type) {
+			case []Value:
+				if index < 0 || index >= int64(len(collection)) {
+					return vm.fail(current, offset, fmt.Sprintf("index %d out of bounds for length %d", index, len(collection)))
+				}
+				vm.push(collection[index])
+			case map[int64]Value:
+				value, ok := collection[index]
+				if !ok {
+					return vm.fail(current, offset, fmt.Sprintf("key %d not found in map", index))
+				}
+				vm.push(value)
+			default:
+				return vm.fail(current, offset, "indexed value is not a collection")
+			}
+		case bytecode.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop().Int
+			switch collection := vm.pop().Ref.(type) {
+			case []Value:
+				if index < 0 || index >= int64(len(collection)) {
+					return vm.fail(current, offset, fmt.Sprintf("index %d out of bounds for length %d", index, len(collection)))
+				}
+				collection[index] = value
+			case map[int64]Value:
+				if _, ok := collection[index]; !ok {
+					err := vm.allocate(offset, 8+valueSize)
+					if err != nil {
+						return err
+					}
+				}
+				collection[index] = value
+			default:
+				return vm.fail(current, offset, "indexed value is not a collection")
+			}
+		case bytecode.OpLength:
+			switch collection := vm.pop().Ref.(type) {
+			case []Value:
+				vm.push(Int(int64(len(collection))))
+			case map[int64]Value:
+				vm.push(Int(int64(len(collection))))
+			default:
+				return vm.fail(current, offset, "length of a value that is not a collection")
+			}
+		case bytecode.OpAppend:
+			count := int(code[current.ip])
+			current.ip++
+			err := vm.allocate(offset, int64(count)*valueSize)
+			if err != nil {
+				return err
+			}
+			elements := vm.stack[len(vm.stack)-count:]
+			slice, _ := vm.stack[len(vm.stack)-count-1].Ref.([]Value)
+			slice = append(slice, elements...)
+			vm.stack = vm.stack[:len(vm.stack)-count-1]
+			vm.push(Value{Ref: slice})
+		case bytecode.OpKeys:
+			m, _ := vm.pop().Ref.(map[int64]Value)
+			err := vm.allocate(offset, int64(len(m))*valueSize)
+			if err != nil {
+				return err
+			}
+			keys := make([]Value, 0, len(m))
+			for _, key := range sortedKeys(m) {
+				keys = append(keys, Int(key))
+			}
+			vm.push(Value{Ref: keys})
+		case bytecode.OpFail:
+			message, _ := u.program.Constants[bytecode.ReadUint16(code, current.ip)].(string)
+			return vm.fail(current, offset, message)
+		default:
+			return vm.fail(current, offset, fmt.Sprintf("unknown opcode: %d", op))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package vm_test
+
+import (
+	"context"
+	"errors"
+	"shake/codegen/testdata/fixture"
+	"shake/vm"
+	"strings"
+	"testing"
+)
+
+// TestCancelled checks a call with a done context does not start, even when
+// the program ends before the loop would check the context
+func TestCancelled(t *testing.T) {
+	compiled := fixture.Compile(t, fixture.ParseString(t, `
+fn main(): int32 {
+    return 42;
+}
+`))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	machine := vm.New(compiled)
+	_, err := machine.RunContext(ctx)
+	var limit *vm.LimitError
+	if !errors.As(err, &limit) || limit.Limit != vm.LimitTime || !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext returned %v, want a time limit error", err)
+	}
+	_, err = machine.CallContext(ctx, compiled.Entry)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CallContext returned %v, want a time limit error", err)
+	}
+
+	// the VM still runs once the context is not done
+	result, err := machine.Run()
+	if err != nil || result != 42 {
+		t.Errorf("Run returned %d, %v, want 42", result, err)
+	}
+}
+
+const limited = `fn spin(n: int32): int32 {
+    return spin(n + 1);
+}
+
+fn loop(): int32 {
+    n = 0;
+    for i, x in []int32{1, 2, 3} {
+        n = n + x;
+    }
+    return n;
+}
+
+fn grow(): int32 {
+    xs = []int32{};
+    for i, x in []int32{1, 2, 3, 4, 5, 6, 7, 8} {
+        xs = append(xs, x);
+    }
+    return len(xs);
+}
+
+fn leaf(): int32 {
+    return 1;
+}
+
+fn calls(): int32 {
+    n = 0;
+    for i, x in []int32{1, 2, 3, 4, 5, 6, 7, 8} {
+        n = n + leaf();
+    }
+    return n;
+}
+`
+
+// call calls the function of the limited program by name
+func call(t *testing.T, limits vm.Limits, name string, arguments ...vm.Value) (vm.Value, error) {
+	t.Helper()
+	compiled := fixture.Compile(t, fixture.ParseString(t, limited))
+	index, ok := compiled.FunctionIndex(name)
+	if !ok {
+		t.Fatalf("%s is not declared", name)
+	}
+	machine := vm.New(compiled)
+	machine.SetLimits(limits)
+	return machine.Call(index, arguments...)
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		limits    vm.Limits
+		function  string
+		arguments []vm.Value
+		want      vm.Limit
+		reason    string
+	}{
+		{"steps", vm.Limits{Steps: 20}, "loop", nil, vm.LimitSteps, "executed more than the limit of 20 steps"},
+		{"depth", vm.Limits{Depth: 10}, "spin", []vm.Value{vm.Int(0)}, vm.LimitDepth, "called more than the limit of 10 functions deep"},
+		{"collections", vm.Limits{Memory: 100}, "grow", nil, vm.LimitMemory, "allocated more than the limit of 100 bytes"},
+		// frames count too, the recursion allocates nothing else
+		{"frames", vm.Limits{Memory: 100_000}, "spin", []vm.Value{vm.Int(0)}, vm.LimitMemory, "allocated more than the limit of 100000 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := call(t, test.limits, test.function, test.arguments...)
+			var limit *vm.LimitError
+			if !errors.As(err, &limit) {
+				t.Fatalf("got %v, want a limit error", err)
+			}
+			if limit.Limit != test.want || limit.Reason != test.reason {
+				t.Errorf("got %s: %s, want %s: %s", limit.Limit, limit.Reason, test.want, test.reason)
+			}
+		})
+	}
+
+	// the frames of functions that returned are free again
+	result, err := call(t, vm.Limits{Steps: 1000, Depth: 2, Memory: 1000}, "calls")
+	if err != nil || result.Int != 8 {
+		t.Errorf("got %d, %v, want 8 within the limits", result.Int, err)
+	}
+}
+
+// TestLimitTrace checks the trace keeps the innermost frames of a deep
+// recursion and counts the others
+func TestLimitTrace(t *testing.T) {
+	_, err := call(t, vm.Limits{Depth: 100}, "spin", vm.Int(0))
+	var limit *vm.LimitError
+	if !errors.As(err, &limit) {
+		t.Fatalf("got %v, want a limit error", err)
+	}
+	if len(limit.Trace) != 64 || limit.Omitted != 36 {
+		t.Errorf("got %d frames and %d omitted, want 64 and 36", len(limit.Trace), limit.Omitted)
+	}
+	for _, frame := range limit.Trace {
+		if frame != (vm.StackFrame{Function: "spin", LineNumber: 2}) {
+			t.Fatalf("got frame %+v, want spin at line 2", frame)
+		}
+	}
+	if !strings.HasSuffix(err.Error(), "\n\t... 36 more") {
+		t.Errorf("got %q, want the omitted frames at the end", err.Error())
+	}
+
+	_, err = call(t, vm.Limits{Steps: 20}, "loop")
+	if !errors.As(err, &limit) || len(limit.Trace) != 1 || limit.Omitted != 0 {
+		t.Fatalf("got %v, want a single frame", err)
+	}
+	if limit.Trace[0].Function != "loop" || limit.Trace[0].LineNumber < 3 {
+		t.Errorf("got %+v, want the loop of loop", limit.Trace[0])
+	}
+}